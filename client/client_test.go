@@ -0,0 +1,414 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/internal/selfsigned"
+	"http2/session"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startH2C serves h over cleartext HTTP/2 and returns its address.
+func startH2C(t *testing.T, h session.FuncHandler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &session.Server{Handler: h, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+// countingTransport allows h2c and counts the connections it dials.
+func countingTransport(t *testing.T) (*Transport, *atomic.Int32) {
+	var dials atomic.Int32
+	tr := &Transport{
+		AllowHTTP: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	t.Cleanup(tr.CloseIdleConnections)
+	return tr, &dials
+}
+
+func TestGet(t *testing.T) {
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		res.SetHeader("content-type", "text/plain")
+		res.SetHeader("x-method", req.Method())
+		io.WriteString(res, "hello "+req.URL().RequestURI()+" "+req.Header().Get("x-test"))
+	})
+	tr, _ := countingTransport(t)
+	client := &http.Client{Transport: tr}
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/path?q=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Test", "yes")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "GET", resp.Header.Get("X-Method"))
+	assert.Equal(t, "hello /path?q=1 yes", string(body))
+	assert.EqualValues(t, len(body), resp.ContentLength)
+}
+
+func TestConcurrentRequestsShareConnection(t *testing.T) {
+	const n = 10
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		// Every request has to be in flight at once to get here.
+		arrived.Done()
+		arrived.Wait()
+		io.WriteString(res, req.URL().Path)
+	})
+	tr, dials := countingTransport(t)
+	client := &http.Client{Transport: tr}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("http://" + addr + "/" + string(rune('a'+i)))
+			if !assert.NoError(t, err) {
+				arrived.Done()
+				return
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			bodies[i] = string(b)
+		}()
+	}
+	wg.Wait()
+	for i, b := range bodies {
+		assert.Equal(t, "/"+string(rune('a'+i)), b)
+	}
+	assert.EqualValues(t, 1, dials.Load())
+}
+
+func TestLargeBodiesUseFlowControl(t *testing.T) {
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		io.Copy(res, req.Body)
+	})
+	tr, _ := countingTransport(t)
+	client := &http.Client{Transport: tr}
+
+	// Several times the default 64 KiB window in each direction.
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 20000)
+	resp, err := client.Post("http://"+addr+"/", "application/octet-stream", bytes.NewReader(payload))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, payload, body)
+	// The body outgrew the window, so sending it had to wait.
+	assert.Positive(t, tr.connPool().conns["http://"+addr][0].StallTime())
+}
+
+func TestTrailers(t *testing.T) {
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		io.Copy(io.Discard, req.Body)
+		res.Trailer().Set("x-got", req.Trailer().Get("x-sent"))
+		io.WriteString(res, "body")
+	})
+	tr, _ := countingTransport(t)
+
+	req, err := http.NewRequest("POST", "http://"+addr+"/", strings.NewReader("data"))
+	require.NoError(t, err)
+	req.Trailer = http.Header{"X-Sent": {"abc"}}
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Got"))
+}
+
+func TestCancelResetsStream(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		res.WriteHeader(session.Ok)
+		res.Flush()
+		<-req.Context().Done()
+		handlerDone <- req.Context().Err()
+	})
+	tr, _ := countingTransport(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	cancel()
+	_, err = io.ReadAll(resp.Body)
+	assert.ErrorIs(t, err, context.Canceled)
+	select {
+	case err := <-handlerDone:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't cancelled")
+	}
+}
+
+func TestServerReset(t *testing.T) {
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		res.Reset(session.ErrorCodeRefusedStream)
+	})
+	tr, _ := countingTransport(t)
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	var se *StreamError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, session.ErrorCodeRefusedStream, se.Code)
+
+	// The connection is still good.
+	assert.True(t, tr.connPool().conns["http://"+addr][0].usable())
+}
+
+func TestGoAwayFailsNewRequests(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &session.Server{
+		Handler:  session.FuncHandler(func(req *session.Request, res *session.Response) {}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	cc, err := NewClientConn(conn, settings.SettingsList{})
+	require.NoError(t, err)
+	defer cc.Close()
+
+	req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	resp, err := cc.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	require.Eventually(t, func() bool { return !cc.usable() }, 5*time.Second, 10*time.Millisecond)
+	_, err = cc.RoundTrip(req)
+	assert.ErrorIs(t, err, ConnNotUsable)
+}
+
+func TestServerHeaderTableSize(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &session.Server{
+		Handler: session.FuncHandler(func(req *session.Request, res *session.Response) {
+			io.WriteString(res, req.Header().Get("x-test"))
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	srv.Settings.Put(settings.HeaderTableSize, 0)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	cc, err := NewClientConn(conn, settings.SettingsList{})
+	require.NoError(t, err)
+	defer cc.Close()
+
+	// Each request would be encoded against the table the one
+	// before filled, if the client kept one.
+	for _, v := range []string{"one", "two"} {
+		req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"/", nil)
+		require.NoError(t, err)
+		req.Header.Set("X-Test", v)
+		resp, err := cc.RoundTrip(req)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		assert.Equal(t, v, string(body))
+	}
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	assert.Zero(t, cc.henc.MaxSize())
+}
+
+// respondOnce serves one connection, calling respond for its first
+// request with a function that sends a frame, and returns the
+// server's address.
+func respondOnce(t *testing.T, respond func(send func(typ frame.FrameType, flags uint8, sid frame.Sid, data []byte), sid frame.Sid)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fr := frame.NewFramer(conn)
+		if fr.ConsumePreface() != nil {
+			return
+		}
+		send := func(typ frame.FrameType, flags uint8, sid frame.Sid, data []byte) {
+			(&frame.FrameHeader{Length: uint32(len(data)), Type: typ, Flags: flags, Sid: sid}).Marshal(conn)
+			conn.Write(data)
+		}
+		send(frame.FrameSettings, 0, 0, nil)
+		for {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				return
+			}
+			if f.FrameHeader.Type == frame.FrameHeaders {
+				respond(send, f.FrameHeader.Sid)
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestLocalHeaderTableSize(t *testing.T) {
+	addr := respondOnce(t, func(send func(frame.FrameType, uint8, frame.Sid, []byte), sid frame.Sid) {
+		// The server grows its table to what the client allows.
+		block := append(hpack.TableSizeUpdate(8192).Encode(), 0x88) // :status 200
+		send(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_END_STREAM, sid, block)
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	var local settings.SettingsList
+	local.Put(settings.HeaderTableSize, 8192)
+	cc, err := NewClientConn(conn, local)
+	require.NoError(t, err)
+	defer cc.Close()
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	resp, err := cc.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestEndlessHeaderBlock(t *testing.T) {
+	addr := respondOnce(t, func(send func(frame.FrameType, uint8, frame.Sid, []byte), sid frame.Sid) {
+		send(frame.FrameHeaders, 0, sid, []byte{0x88})
+		filler := make([]byte, 1<<14)
+		for range maxHeaderBlock/len(filler) + 1 {
+			send(frame.FrameContinuation, 0, sid, filler)
+		}
+	})
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	cc, err := NewClientConn(conn, settings.SettingsList{})
+	require.NoError(t, err)
+	defer cc.Close()
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	_, err = cc.RoundTrip(req)
+	var ce *session.ConnError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, session.ErrorCodeEnhanceYourCalm, ce.ErrorCode)
+}
+
+func TestTLS(t *testing.T) {
+	cert, pool := testCert(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &session.Server{
+		Handler: session.FuncHandler(func(req *session.Request, res *session.Response) {
+			io.WriteString(res, req.Scheme())
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	tl := tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}})
+	go srv.Serve(tl)
+	defer srv.Close()
+
+	tr := &Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr}
+	resp, err := client.Get("https://" + l.Addr().String() + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "https", string(body))
+	require.NotNil(t, resp.TLS)
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+
+	// Plain http needs AllowHTTP.
+	_, err = client.Get("http://" + l.Addr().String() + "/")
+	assert.Error(t, err)
+
+	// A wrapped connection still has its TLS state.
+	wrapped := &Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+		WrapConn:        func(conn net.Conn) net.Conn { return struct{ net.Conn }{conn} },
+	}
+	defer wrapped.CloseIdleConnections()
+	resp, err = (&http.Client{Transport: wrapped}).Get("https://" + l.Addr().String() + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotNil(t, resp.TLS)
+	assert.Equal(t, "h2", resp.TLS.NegotiatedProtocol)
+}
+
+func TestRequestHeaders(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://example.com/a?b=c", strings.NewReader("xyz"))
+	require.NoError(t, err)
+	req.Header.Set("Connection", "keep-alive")
+	req.Header.Set("Te", "gzip")
+	req.Header.Set("X-Ok", "1")
+	fields, err := requestHeaders(req, true)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]string{
+		{":method", "POST"},
+		{":scheme", "https"},
+		{":authority", "example.com"},
+		{":path", "/a?b=c"},
+		{"x-ok", "1"},
+		{"content-length", "3"},
+	}, fields)
+
+	req.Header.Set("X-Bad", "a\r\nb")
+	_, err = requestHeaders(req, true)
+	assert.Error(t, err)
+}
+
+// testCert makes a self-signed certificate for 127.0.0.1 and any
+// other names given.
+func testCert(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	cert, err := selfsigned.New(append([]string{"127.0.0.1"}, names...)...)
+	require.NoError(t, err)
+	return cert, selfsigned.Pool(cert)
+}
@@ -0,0 +1,606 @@
+// Package client is an HTTP/2 client built on this module's framing
+// and HPACK code. A ClientConn multiplexes concurrent requests over a
+// single connection, and Transport adapts it to http.RoundTripper so
+// it can be used with http.Client.
+package client
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+)
+
+const (
+	// The window we give the server, per stream and for the
+	// connection. It's the protocol default so it needn't be
+	// announced.
+	initialWindow = 65535
+
+	// How many streams we open before the server's SETTINGS say
+	// how many it allows (RFC 9113 §6.5.2 recommends at least 100).
+	defaultMaxStreams = 100
+
+	// The most octets of header block buffered across CONTINUATION
+	// frames, so a server can't make us hold an endless one.
+	maxHeaderBlock = 1 << 20
+
+	maxStreamID = 1<<31 - 1
+)
+
+var (
+	ConnClosed = errors.New("client: connection closed")
+	// Returned by the connection after GOAWAY, or once it has used
+	// up its stream IDs.
+	ConnNotUsable = errors.New("client: connection can't take new requests")
+)
+
+// A StreamError is returned when the server resets a stream.
+type StreamError struct {
+	Sid  frame.Sid
+	Code session.ErrorCode
+}
+
+func (se *StreamError) Error() string {
+	return fmt.Sprintf("client: stream %d reset by server: %s", se.Sid, se.Code)
+}
+
+// A GoAwayError fails requests the server stopped before processing,
+// those on streams after LastStreamID.
+type GoAwayError struct {
+	LastStreamID frame.Sid
+	Code         session.ErrorCode
+	Debug        string
+}
+
+func (ge *GoAwayError) Error() string {
+	return fmt.Sprintf("client: server sent GOAWAY (last stream %d): %s %s", ge.LastStreamID, ge.Code, ge.Debug)
+}
+
+// A ClientConn is a client's HTTP/2 connection to one server.
+// Its methods are safe to call from multiple goroutines.
+type ClientConn struct {
+	conn net.Conn
+	fr   *frame.Framer
+	tls  *tls.ConnectionState
+
+	// wmu serializes writes and guards the HPACK encoder, so header
+	// blocks reach the server in the order they changed the table,
+	// and streams are opened in the order of their IDs.
+	wmu  sync.Mutex
+	bw   *bufio.Writer
+	henc *hpack.HeaderLookupTable
+
+	// Only used by readLoop.
+	hdec *hpack.HeaderLookupTable
+	// A header block waiting for CONTINUATION frames.
+	hdrSid       frame.Sid
+	hdrBlock     []byte
+	hdrEndStream bool
+
+	// mu guards everything below and the state of each stream. It's
+	// never held while writing, so a slow server can't block the
+	// read loop.
+	mu       sync.Mutex
+	cond     *sync.Cond
+	streams  map[frame.Sid]*clientStream
+	nextID   frame.Sid
+	reserved int // streams waiting for wmu to be opened
+	err      error
+	goAway   *GoAwayError
+
+	// Limits set by the server's SETTINGS.
+	maxFrameSize  uint32
+	maxStreams    uint32
+	initialWindow int64
+	// How much we may still send on the connection.
+	sendWindow int64
+	// Octets the user has read that the server hasn't been
+	// given back yet.
+	unacked int
+	// Time requests have spent waiting for send window.
+	stalled time.Duration
+
+	closeOnce sync.Once
+}
+
+// NewClientConn starts HTTP/2 on conn: it sends the connection
+// preface and the client's SETTINGS, and starts reading frames. conn
+// must already speak h2, either after ALPN or by prior knowledge.
+// Server push is always disabled.
+func NewClientConn(conn net.Conn, local settings.SettingsList) (*ClientConn, error) {
+	var cs *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		cs = &state
+	}
+	return newClientConn(conn, local, cs)
+}
+
+// newClientConn is NewClientConn with the TLS state given, for a conn
+// that wraps the *tls.Conn.
+func newClientConn(conn net.Conn, local settings.SettingsList, cs *tls.ConnectionState) (*ClientConn, error) {
+	cc := &ClientConn{
+		conn:          conn,
+		tls:           cs,
+		fr:            frame.NewFramer(bufio.NewReader(conn)),
+		bw:            bufio.NewWriter(conn),
+		henc:          hpack.NewHeaderLookupTable(),
+		hdec:          hpack.NewHeaderLookupTable(),
+		streams:       make(map[frame.Sid]*clientStream),
+		nextID:        1,
+		maxFrameSize:  16384,
+		maxStreams:    defaultMaxStreams,
+		initialWindow: 65535,
+		sendWindow:    65535,
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+
+	var sl settings.SettingsList
+	for _, s := range local.Settings {
+		sl.Put(s.Type, s.Value)
+	}
+	sl.Put(settings.EnablePush, 0)
+	// The server may grow its table up to what we advertise.
+	if size, ok := sl.Get(settings.HeaderTableSize); ok {
+		cc.hdec.SetSizeLimit(int(size))
+	}
+
+	cc.wmu.Lock()
+	cc.bw.Write(frame.ClientPreface)
+	err := cc.writeFrame(frame.FrameSettings, 0, 0, sl.ToPayload())
+	cc.wmu.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go cc.readLoop()
+	return cc, nil
+}
+
+// writeFrame sends one frame. Callers must hold wmu.
+func (cc *ClientConn) writeFrame(typ frame.FrameType, flags uint8, sid frame.Sid, data []byte) error {
+	fh := frame.FrameHeader{Length: uint32(len(data)), Type: typ, Sid: sid, Flags: flags}
+	if err := fh.Marshal(cc.bw); err != nil {
+		return err
+	}
+	if _, err := cc.bw.Write(data); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+func (cc *ClientConn) send(typ frame.FrameType, flags uint8, sid frame.Sid, data []byte) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	return cc.writeFrame(typ, flags, sid, data)
+}
+
+func (cc *ClientConn) sendReset(sid frame.Sid, code session.ErrorCode) error {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(code))
+	return cc.send(frame.FrameResetStream, 0, sid, data[:])
+}
+
+func (cc *ClientConn) sendWindowUpdate(sid frame.Sid, n int) error {
+	var data [4]byte
+	binary.BigEndian.PutUint32(data[:], uint32(n))
+	return cc.send(frame.FrameWindowUpdate, 0, sid, data[:])
+}
+
+// unusable says why no more streams can be opened. Callers must
+// hold mu.
+func (cc *ClientConn) unusable() error {
+	switch {
+	case cc.goAway != nil, cc.nextID > maxStreamID:
+		return ConnNotUsable
+	case cc.err != nil:
+		return cc.err
+	}
+	return nil
+}
+
+// CanTakeNewRequest reports whether a request could start a stream
+// right away, without waiting for others to finish.
+func (cc *ClientConn) CanTakeNewRequest() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.unusable() == nil && uint32(len(cc.streams)+cc.reserved) < cc.maxStreams
+}
+
+func (cc *ClientConn) usable() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.unusable() == nil
+}
+
+// idle reports whether no requests are in flight.
+func (cc *ClientConn) idle() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return len(cc.streams)+cc.reserved == 0
+}
+
+// StallTime reports how long requests on cc have spent waiting for
+// the server to open its flow-control windows.
+func (cc *ClientConn) StallTime() time.Duration {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.stalled
+}
+
+// Close tells the server the client is going away and closes the
+// connection. Requests still in flight fail with ConnClosed.
+func (cc *ClientConn) Close() error {
+	gf := session.GoawayFrame{ErrorCode: session.ErrorCodeNoError}
+	cc.send(frame.FrameGoaway, 0, 0, gf.Marshal())
+	cc.closeWithError(ConnClosed)
+	return nil
+}
+
+// closeWithError fails every stream with err and closes the
+// connection.
+func (cc *ClientConn) closeWithError(err error) {
+	cc.mu.Lock()
+	if cc.err == nil {
+		cc.err = err
+	}
+	for _, cs := range cc.streams {
+		cs.fail(cc.err)
+	}
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+	cc.closeOnce.Do(func() { cc.conn.Close() })
+}
+
+// forget removes a finished stream. Callers must hold mu.
+func (cc *ClientConn) forget(cs *clientStream) {
+	if cs.forgotten {
+		return
+	}
+	cs.forgotten = true
+	delete(cc.streams, cs.id)
+	if cs.stopCtx != nil {
+		cs.stopCtx()
+	}
+	cc.cond.Broadcast()
+	// After GOAWAY the connection is only kept for the streams
+	// the server is still finishing.
+	if cc.goAway != nil && len(cc.streams) == 0 {
+		go cc.closeOnce.Do(func() { cc.conn.Close() })
+	}
+}
+
+// returnWindow gives read octets back to the server once enough have
+// piled up, so it isn't sent a WINDOW_UPDATE for every read. cs is
+// nil for data the user never sees.
+func (cc *ClientConn) returnWindow(cs *clientStream, n int) {
+	var connInc, streamInc int
+	cc.mu.Lock()
+	cc.unacked += n
+	if cc.unacked >= initialWindow/2 {
+		connInc, cc.unacked = cc.unacked, 0
+	}
+	if cs != nil {
+		cs.unacked += n
+		if cs.unacked >= initialWindow/2 && !cs.recvEnd && cs.err == nil {
+			streamInc, cs.unacked = cs.unacked, 0
+		}
+	}
+	cc.mu.Unlock()
+
+	if connInc > 0 {
+		cc.sendWindowUpdate(0, connInc)
+	}
+	if streamInc > 0 {
+		cc.sendWindowUpdate(cs.id, streamInc)
+	}
+}
+
+func (cc *ClientConn) connError(code session.ErrorCode, reason string) error {
+	return &session.ConnError{ErrorCode: code, Reason: reason}
+}
+
+func (cc *ClientConn) readLoop() {
+	var err error
+	for {
+		var fr *frame.Frame
+		if fr, err = cc.fr.ReadFrame(); err != nil {
+			break
+		}
+		if err = cc.handleFrame(fr); err != nil {
+			break
+		}
+	}
+	var ce *session.ConnError
+	if errors.As(err, &ce) {
+		gf := session.GoawayFrame{ErrorCode: ce.ErrorCode, DebugInfo: []byte(ce.Reason)}
+		cc.send(frame.FrameGoaway, 0, 0, gf.Marshal())
+	} else if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = ConnClosed
+	}
+	cc.closeWithError(err)
+}
+
+func (cc *ClientConn) handleFrame(fr *frame.Frame) error {
+	fh, data := fr.FrameHeader, fr.Data
+
+	// Nothing may come between a HEADERS frame and the
+	// CONTINUATION frames that finish its block.
+	if cc.hdrBlock != nil && (fh.Type != frame.FrameContinuation || fh.Sid != cc.hdrSid) {
+		return cc.connError(session.ErrorCodeProtocol, "expected CONTINUATION")
+	}
+
+	switch fh.Type {
+	case frame.FrameSettings:
+		if fh.Sid != 0 {
+			return cc.connError(session.ErrorCodeProtocol, "SETTINGS on a stream")
+		}
+		// Bit 0 is ACK
+		if fh.Flag(0) {
+			if len(data) != 0 {
+				return cc.connError(session.ErrorCodeFrameSize, "SETTINGS ACK with a payload")
+			}
+			return nil
+		}
+		if len(data)%6 != 0 {
+			return cc.connError(session.ErrorCodeFrameSize, "SETTINGS payload must be a multiple of 6 octets")
+		}
+		sl := settings.SettingsListFromFramePayload(data)
+		if err := cc.applySettings(sl); err != nil {
+			return err
+		}
+		cc.wmu.Lock()
+		defer cc.wmu.Unlock()
+		// The encoder shrinks its table to fit, and the next header
+		// block tells the server so.
+		if size, ok := sl.Get(settings.HeaderTableSize); ok {
+			cc.henc.SetPeerLimit(int(size))
+		}
+		return cc.writeFrame(frame.FrameSettings, settings.STGS_ACK, 0, nil)
+
+	case frame.FramePing:
+		if fh.Sid != 0 {
+			return cc.connError(session.ErrorCodeProtocol, "PING on a stream")
+		}
+		if len(data) != 8 {
+			return cc.connError(session.ErrorCodeFrameSize, "PING payload must be 8 octets")
+		}
+		if fh.Flag(0) {
+			return nil
+		}
+		return cc.send(frame.FramePing, 0x01, 0, data)
+
+	case frame.FrameWindowUpdate:
+		if len(data) != 4 {
+			return cc.connError(session.ErrorCodeFrameSize, "WINDOW_UPDATE payload must be 4 octets")
+		}
+		return cc.handleWindowUpdate(fh.Sid, binary.BigEndian.Uint32(data)&maxStreamID)
+
+	case frame.FrameHeaders:
+		if fh.Sid == 0 {
+			return cc.connError(session.ErrorCodeProtocol, "HEADERS on stream 0")
+		}
+		block, err := frame.HeaderBlockFragment(fh, data)
+		if errors.Is(err, frame.PaddingTooLong) {
+			return cc.connError(session.ErrorCodeProtocol, "padding exceeds frame payload")
+		} else if err != nil {
+			return cc.connError(session.ErrorCodeFrameSize, "HEADERS too short for its padding and priority")
+		}
+		// Bit 2 is END_HEADERS
+		if !fh.Flag(2) {
+			cc.hdrSid, cc.hdrBlock, cc.hdrEndStream = fh.Sid, append([]byte{}, block...), fh.Flag(0)
+			return nil
+		}
+		return cc.handleHeaders(fh.Sid, block, fh.Flag(0))
+
+	case frame.FrameContinuation:
+		if cc.hdrBlock == nil {
+			return cc.connError(session.ErrorCodeProtocol, "unexpected CONTINUATION")
+		}
+		if len(cc.hdrBlock)+len(data) > maxHeaderBlock {
+			return cc.connError(session.ErrorCodeEnhanceYourCalm, "header block too large")
+		}
+		cc.hdrBlock = append(cc.hdrBlock, data...)
+		if !fh.Flag(2) {
+			return nil
+		}
+		block := cc.hdrBlock
+		cc.hdrBlock = nil
+		return cc.handleHeaders(cc.hdrSid, block, cc.hdrEndStream)
+
+	case frame.FrameData:
+		if fh.Sid == 0 {
+			return cc.connError(session.ErrorCodeProtocol, "DATA on stream 0")
+		}
+		return cc.handleData(fh, data)
+
+	case frame.FrameResetStream:
+		if fh.Sid == 0 {
+			return cc.connError(session.ErrorCodeProtocol, "RST_STREAM on stream 0")
+		}
+		if len(data) != 4 {
+			return cc.connError(session.ErrorCodeFrameSize, "RST_STREAM payload must be 4 octets")
+		}
+		code := session.ErrorCode(binary.BigEndian.Uint32(data))
+		cc.mu.Lock()
+		if cs := cc.streams[fh.Sid]; cs != nil {
+			cs.fail(&StreamError{Sid: fh.Sid, Code: code})
+		}
+		cc.mu.Unlock()
+
+	case frame.FrameGoaway:
+		if fh.Sid != 0 {
+			return cc.connError(session.ErrorCodeProtocol, "GOAWAY on a stream")
+		}
+		if len(data) < 8 {
+			return cc.connError(session.ErrorCodeFrameSize, "GOAWAY payload is too short")
+		}
+		cc.handleGoAway(session.GoawayFrameFromPayload(data))
+
+	case frame.FramePushPromise:
+		return cc.connError(session.ErrorCodeProtocol, "PUSH_PROMISE with push disabled")
+	}
+	// Anything else, like PRIORITY, is ignored.
+	return nil
+}
+
+func (cc *ClientConn) applySettings(sl *settings.SettingsList) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, s := range sl.Settings {
+		switch s.Type {
+		case settings.MaxFrameSize:
+			if s.Value < 16384 || s.Value > 1<<24-1 {
+				return cc.connError(session.ErrorCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE")
+			}
+			cc.maxFrameSize = s.Value
+		case settings.MaxConcurrentStreams:
+			cc.maxStreams = s.Value
+		case settings.InitialWindowSize:
+			if s.Value > maxStreamID {
+				return cc.connError(session.ErrorCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE")
+			}
+			// Open streams' windows change by the difference
+			// (RFC 9113 §6.9.2).
+			delta := int64(s.Value) - cc.initialWindow
+			for _, cs := range cc.streams {
+				cs.sendWindow += delta
+			}
+			cc.initialWindow = int64(s.Value)
+		}
+	}
+	cc.cond.Broadcast()
+	return nil
+}
+
+func (cc *ClientConn) handleWindowUpdate(sid frame.Sid, inc uint32) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if sid == 0 {
+		if inc == 0 {
+			return cc.connError(session.ErrorCodeProtocol, "WINDOW_UPDATE of 0")
+		}
+		cc.sendWindow += int64(inc)
+		if cc.sendWindow > maxStreamID {
+			return cc.connError(session.ErrorCodeFlowControl, "connection window overflow")
+		}
+	} else if cs := cc.streams[sid]; cs != nil {
+		cs.sendWindow += int64(inc)
+		if inc == 0 || cs.sendWindow > maxStreamID {
+			cs.reset(session.ErrorCodeFlowControl)
+		}
+	}
+	cc.cond.Broadcast()
+	return nil
+}
+
+func (cc *ClientConn) handleHeaders(sid frame.Sid, block []byte, endStream bool) error {
+	// Blocks are decoded even for streams we've given up on, so
+	// that the table stays in sync with the server's.
+	var fields [][2]string
+	err := hpack.DecodeBlock(cc.hdec, block, func(k, v string) {
+		fields = append(fields, [2]string{k, v})
+	})
+	if err != nil {
+		return cc.connError(session.ErrorCodeCompression, err.Error())
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cs := cc.streams[sid]
+	if cs == nil || cs.recvEnd {
+		return nil
+	}
+	if cs.resp == nil {
+		resp, err := cs.newResponse(fields, endStream)
+		if err != nil {
+			cs.reset(session.ErrorCodeProtocol)
+			return nil
+		}
+		if resp == nil {
+			// 1xx informational responses are skipped.
+			return nil
+		}
+		cs.resp = resp
+		cs.gotResponse = true
+		close(cs.respReady)
+	} else if err := cs.setTrailers(fields, endStream); err != nil {
+		cs.reset(session.ErrorCodeProtocol)
+		return nil
+	}
+	if endStream {
+		cs.endRecv()
+	}
+	return nil
+}
+
+func (cc *ClientConn) handleData(fh *frame.FrameHeader, data []byte) error {
+	payload := data
+	// Frame is padded. The first byte of the payload is the pad
+	// length.
+	if fh.Flag(3) {
+		if len(data) < 1 || int(data[0]) >= len(data) {
+			return cc.connError(session.ErrorCodeProtocol, "padding exceeds frame payload")
+		}
+		payload = data[1 : len(data)-int(data[0])]
+	}
+
+	cc.mu.Lock()
+	cs := cc.streams[fh.Sid]
+	switch {
+	case cs == nil:
+		cc.mu.Unlock()
+		// Most likely a stream we reset.
+		cc.returnWindow(nil, len(data))
+		return nil
+	case cs.resp == nil:
+		cs.reset(session.ErrorCodeProtocol)
+		cc.mu.Unlock()
+		cc.returnWindow(nil, len(data))
+		return nil
+	case cs.recvEnd:
+		cs.reset(session.ErrorCodeStreamClosed)
+		cc.mu.Unlock()
+		cc.returnWindow(nil, len(data))
+		return nil
+	}
+	cs.body.Write(payload)
+	if fh.Flag(0) {
+		cs.endRecv()
+	}
+	cc.mu.Unlock()
+
+	// Padding is given back right away, the payload once it's read.
+	if pad := len(data) - len(payload); pad > 0 {
+		cc.returnWindow(nil, pad)
+	}
+	return nil
+}
+
+func (cc *ClientConn) handleGoAway(gf *session.GoawayFrame) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	ge := &GoAwayError{LastStreamID: gf.LastStreamId, Code: gf.ErrorCode, Debug: string(gf.DebugInfo)}
+	cc.goAway = ge
+	// Later streams were never processed, so they're safe to retry.
+	for sid, cs := range cc.streams {
+		if sid > ge.LastStreamID {
+			cs.fail(ge)
+		}
+	}
+	if len(cc.streams) == 0 {
+		go cc.closeOnce.Do(func() { cc.conn.Close() })
+	}
+	cc.cond.Broadcast()
+}
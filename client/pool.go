@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A connPool holds a Transport's connections, keyed by scheme and
+// authority. A key can have several connections when one isn't
+// enough for the server's MAX_CONCURRENT_STREAMS, and a connection
+// can serve several keys once it has been coalesced.
+type connPool struct {
+	t *Transport
+
+	mu      sync.Mutex
+	conns   map[string][]*ClientConn
+	keys    map[*ClientConn][]string
+	dialing map[string]*dialCall
+}
+
+// A dialCall is a connection being dialled. Requests that need one
+// for the same key wait for it rather than dialling their own.
+type dialCall struct {
+	done chan struct{}
+	cc   *ClientConn
+	err  error
+}
+
+// How long a shared dial may take, since no single request's
+// context bounds it.
+const dialTimeout = 30 * time.Second
+
+// get returns a connection that can take a request right away,
+// dialling one if every connection for the key is busy.
+func (p *connPool) get(ctx context.Context, scheme, addr string) (*ClientConn, error) {
+	key := scheme + "://" + addr
+	for {
+		p.mu.Lock()
+		if cc := p.available(key); cc != nil {
+			p.mu.Unlock()
+			return cc, nil
+		}
+		call, ok := p.dialing[key]
+		if !ok {
+			call = &dialCall{done: make(chan struct{})}
+			if p.dialing == nil {
+				p.dialing = make(map[string]*dialCall)
+			}
+			p.dialing[key] = call
+			// Every request waiting for the dial shares it, so
+			// it mustn't end when the one that started it
+			// gives up.
+			go p.dial(context.WithoutCancel(ctx), call, key, scheme, addr)
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err != nil {
+			return nil, call.err
+		}
+		// The new connection may already be taken by other
+		// waiters.
+	}
+}
+
+// dial finds or dials a connection for key, adds it to the pool, and
+// wakes the requests waiting for call.
+func (p *connPool) dial(ctx context.Context, call *dialCall, key, scheme, addr string) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	if call.cc = p.coalesced(ctx, scheme, addr); call.cc == nil {
+		call.cc, call.err = p.t.dial(ctx, scheme, addr)
+	}
+
+	p.mu.Lock()
+	delete(p.dialing, key)
+	if call.err == nil {
+		p.add(key, call.cc)
+	}
+	p.mu.Unlock()
+	close(call.done)
+}
+
+// available picks a connection for key with room for another stream,
+// and drops the ones that can't take requests any more. Callers must
+// hold mu.
+func (p *connPool) available(key string) *ClientConn {
+	var found *ClientConn
+	var retired []*ClientConn
+	for _, cc := range p.conns[key] {
+		if !cc.usable() {
+			retired = append(retired, cc)
+		} else if found == nil && cc.CanTakeNewRequest() {
+			found = cc
+		}
+	}
+	for _, cc := range retired {
+		p.remove(cc)
+	}
+	return found
+}
+
+// add lists cc under key. Callers must hold mu.
+func (p *connPool) add(key string, cc *ClientConn) {
+	if p.conns == nil {
+		p.conns = make(map[string][]*ClientConn)
+		p.keys = make(map[*ClientConn][]string)
+	}
+	if slices.Contains(p.conns[key], cc) {
+		return
+	}
+	p.conns[key] = append(p.conns[key], cc)
+	p.keys[cc] = append(p.keys[cc], key)
+}
+
+// remove drops cc from every key it's listed under. Callers must
+// hold mu.
+func (p *connPool) remove(cc *ClientConn) {
+	for _, key := range p.keys[cc] {
+		p.conns[key] = slices.DeleteFunc(p.conns[key], func(c *ClientConn) bool { return c == cc })
+		if len(p.conns[key]) == 0 {
+			delete(p.conns, key)
+		}
+	}
+	delete(p.keys, cc)
+}
+
+// coalesced looks for a TLS connection that can also serve addr's
+// host (RFC 9113 §9.1.1): it must be to an address the host resolves
+// to, on the same port, and its certificate must be valid for the
+// host. It returns nil if there's none.
+func (p *connPool) coalesced(ctx context.Context, scheme, addr string) *ClientConn {
+	if scheme != "https" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	var candidates []*ClientConn
+	for cc := range p.keys {
+		if cc.tls == nil || !cc.CanTakeNewRequest() {
+			continue
+		}
+		if _, ccPort, err := net.SplitHostPort(cc.conn.RemoteAddr().String()); err == nil && ccPort == port {
+			candidates = append(candidates, cc)
+		}
+	}
+	p.mu.Unlock()
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// If this fails, dialling will fail the same way.
+	ips, err := p.t.lookupIP(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, cc := range candidates {
+		ccHost, _, _ := net.SplitHostPort(cc.conn.RemoteAddr().String())
+		remote := net.ParseIP(ccHost)
+		if !slices.ContainsFunc(ips, remote.Equal) {
+			continue
+		}
+		certs := cc.tls.PeerCertificates
+		if len(certs) > 0 && certs[0].VerifyHostname(host) == nil {
+			return cc
+		}
+	}
+	return nil
+}
+
+// closeIdle closes the connections with no requests in flight.
+func (p *connPool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for cc := range p.keys {
+		if cc.idle() {
+			cc.Close()
+			p.remove(cc)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolOpensConnectionsWhenSaturated(t *testing.T) {
+	const n = 4
+	var arrived sync.WaitGroup
+	arrived.Add(n)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &session.Server{
+		Handler: session.FuncHandler(func(req *session.Request, res *session.Response) {
+			if req.URL().Path == "/wait" {
+				arrived.Done()
+				arrived.Wait()
+			}
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	srv.Settings.Put(settings.MaxConcurrentStreams, 2)
+	go srv.Serve(l)
+	defer srv.Close()
+	tr, dials := countingTransport(t)
+	client := &http.Client{Transport: tr}
+	base := "http://" + l.Addr().String()
+
+	// Learn the server's limit first.
+	resp, err := client.Get(base + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(base + "/wait")
+			if !assert.NoError(t, err) {
+				arrived.Done()
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 2, dials.Load())
+}
+
+func TestSharedDialOutlivesFirstRequest(t *testing.T) {
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {})
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	var dials atomic.Int32
+	tr := &Transport{
+		AllowHTTP: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials.Add(1)
+			close(dialing)
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	defer tr.CloseIdleConnections()
+
+	// The first request starts the dial and gives up on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := tr.connPool().get(ctx, "http", addr)
+		first <- err
+	}()
+	<-dialing
+	second := make(chan error, 1)
+	go func() {
+		_, err := tr.connPool().get(context.Background(), "http", addr)
+		second <- err
+	}()
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+
+	// The second one still gets the connection.
+	close(release)
+	assert.NoError(t, <-second)
+	assert.EqualValues(t, 1, dials.Load())
+}
+
+func TestRetryRefusedStream(t *testing.T) {
+	var calls atomic.Int32
+	addr := startH2C(t, func(req *session.Request, res *session.Response) {
+		if calls.Add(1) < 3 {
+			res.Reset(session.ErrorCodeRefusedStream)
+			return
+		}
+		io.Copy(res, req.Body)
+	})
+	tr, _ := countingTransport(t)
+
+	req, err := http.NewRequest("POST", "http://"+addr+"/", strings.NewReader("again"))
+	require.NoError(t, err)
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "again", string(body))
+	assert.EqualValues(t, 3, calls.Load())
+
+	// Without GetBody the body can't be sent again.
+	calls.Store(0)
+	req, err = http.NewRequest("POST", "http://"+addr+"/", io.NopCloser(strings.NewReader("once")))
+	require.NoError(t, err)
+	_, err = tr.RoundTrip(req)
+	var se *StreamError
+	assert.ErrorAs(t, err, &se)
+}
+
+// goAwayServer answers the first connection's first request with
+// GOAWAY, as if it were shutting down before seeing it, and every
+// later request with an empty 200.
+func goAwayServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for first := true; ; first = false {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveGoAway(conn, first)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveGoAway(conn net.Conn, refuse bool) {
+	defer conn.Close()
+	fr := frame.NewFramer(conn)
+	if fr.ConsumePreface() != nil {
+		return
+	}
+	send := func(typ frame.FrameType, flags uint8, sid frame.Sid, data []byte) {
+		(&frame.FrameHeader{Length: uint32(len(data)), Type: typ, Flags: flags, Sid: sid}).Marshal(conn)
+		conn.Write(data)
+	}
+	send(frame.FrameSettings, 0, 0, nil)
+	tbl := hpack.NewHeaderLookupTable()
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			return
+		}
+		fh := f.FrameHeader
+		if fh.Type != frame.FrameHeaders {
+			continue
+		}
+		if refuse {
+			gf := session.GoawayFrame{LastStreamId: 0, ErrorCode: session.ErrorCodeNoError}
+			send(frame.FrameGoaway, 0, 0, gf.Marshal())
+			continue
+		}
+		hl := hpack.NewHeaderList(tbl)
+		hl.Put(":status", "200")
+		send(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_END_STREAM, fh.Sid, hl.Dump())
+	}
+}
+
+func TestRetryAfterGoAway(t *testing.T) {
+	addr := goAwayServer(t)
+	tr, dials := countingTransport(t)
+
+	req, err := http.NewRequest("GET", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	resp, err := tr.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.EqualValues(t, 2, dials.Load())
+}
+
+func TestCoalescing(t *testing.T) {
+	for _, tc := range []struct {
+		names []string
+		dials int32
+	}{
+		{[]string{"localhost"}, 1},
+		{nil, 2},
+	} {
+		cert, pool := testCert(t, tc.names...)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		srv := &session.Server{
+			Handler: session.FuncHandler(func(req *session.Request, res *session.Response) {
+				io.WriteString(res, req.Authority())
+			}),
+			ErrorLog: log.New(io.Discard, "", 0),
+		}
+		go srv.Serve(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}}))
+		defer srv.Close()
+
+		tr, dials := countingTransport(t)
+		// Without a matching certificate, the second dial
+		// still has to succeed.
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool, InsecureSkipVerify: tc.names == nil}
+		client := &http.Client{Transport: tr}
+		_, port, _ := net.SplitHostPort(l.Addr().String())
+		for _, host := range []string{"127.0.0.1", "localhost"} {
+			resp, err := client.Get("https://" + net.JoinHostPort(host, port) + "/")
+			require.NoError(t, err)
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, net.JoinHostPort(host, port), string(body))
+		}
+		assert.Equal(t, tc.dials, dials.Load(), tc.names)
+	}
+}
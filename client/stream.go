@@ -0,0 +1,589 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+)
+
+var bodyClosed = errors.New("client: read on closed response body")
+
+// A clientStream is one request and its response.
+type clientStream struct {
+	cc  *ClientConn
+	id  frame.Sid
+	req *http.Request
+
+	// Closed once resp or respErr is set.
+	respReady chan struct{}
+	body      pipe
+	// Stops watching the request's context.
+	stopCtx func() bool
+
+	// Guarded by cc.mu.
+	resp        *http.Response
+	respErr     error
+	gotResponse bool
+	sentEnd     bool // the request has been sent with END_STREAM
+	recvEnd     bool // the response ended with END_STREAM
+	err         error
+	forgotten   bool
+	sendWindow  int64
+	unacked     int
+}
+
+// RoundTrip sends req on a new stream and returns the response once
+// its headers arrive. The request body is sent in the background,
+// within the server's flow control windows. Cancelling the request's
+// context resets the stream.
+func (cc *ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	cs, err := cc.newStream(req, hasBody)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	if hasBody {
+		go cs.writeBody()
+	}
+
+	<-cs.respReady
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cs.respErr != nil {
+		return nil, cs.respErr
+	}
+	return cs.resp, nil
+}
+
+func (cc *ClientConn) newStream(req *http.Request, hasBody bool) (*clientStream, error) {
+	fields, err := requestHeaders(req, hasBody)
+	if err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+
+	// Wait for the server to allow another stream.
+	cc.mu.Lock()
+	stop := context.AfterFunc(ctx, func() {
+		cc.mu.Lock()
+		cc.cond.Broadcast()
+		cc.mu.Unlock()
+	})
+	for {
+		if err := cc.unusable(); err != nil {
+			cc.mu.Unlock()
+			stop()
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			cc.mu.Unlock()
+			stop()
+			return nil, err
+		}
+		if uint32(len(cc.streams)+cc.reserved) < cc.maxStreams {
+			break
+		}
+		cc.cond.Wait()
+	}
+	stop()
+	cc.reserved++
+	cc.mu.Unlock()
+
+	cs := &clientStream{cc: cc, req: req, respReady: make(chan struct{})}
+	cs.body.cond.L = &cs.body.mu
+
+	// Stream IDs have to increase in the order streams are opened,
+	// so the ID is picked with wmu held until HEADERS is sent.
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	cc.mu.Lock()
+	cc.reserved--
+	if err := cc.unusable(); err != nil {
+		cc.cond.Broadcast()
+		cc.mu.Unlock()
+		return nil, err
+	}
+	cs.id = cc.nextID
+	cc.nextID += 2
+	cs.sendWindow = cc.initialWindow
+	cs.sentEnd = !hasBody
+	cc.streams[cs.id] = cs
+	cs.stopCtx = context.AfterFunc(ctx, func() { cs.abort(ctx.Err()) })
+	maxFrame := int(cc.maxFrameSize)
+	cc.mu.Unlock()
+
+	if err := cc.writeHeaders(cs.id, fields, !hasBody, maxFrame); err != nil {
+		cc.closeWithError(err)
+		return nil, err
+	}
+	return cs, nil
+}
+
+// writeHeaders encodes a header block and sends it in a HEADERS frame,
+// followed by CONTINUATION frames if it doesn't fit. Callers must hold
+// wmu.
+func (cc *ClientConn) writeHeaders(sid frame.Sid, fields [][2]string, endStream bool, maxFrame int) error {
+	hl := hpack.NewHeaderList(cc.henc)
+	for _, f := range fields {
+		hl.Put(f[0], f[1])
+	}
+	block := hl.Dump()
+
+	typ := frame.FrameHeaders
+	var flags uint8
+	if endStream {
+		flags |= session.FLAG_END_STREAM
+	}
+	for {
+		chunk := block[:min(len(block), maxFrame)]
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= session.FLAG_END_HEADERS
+		}
+		if err := cc.writeFrame(typ, flags, sid, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		typ, flags = frame.FrameContinuation, 0
+	}
+}
+
+// Connection-specific fields, which HTTP/2 doesn't allow
+// (RFC 9113 §8.2.2).
+var connectionHeaders = []string{
+	"connection",
+	"host",
+	"keep-alive",
+	"proxy-connection",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// requestHeaders lists the fields for a request's header block. They
+// are checked before any are encoded, since a bad request mustn't
+// change the encoder's table.
+func requestHeaders(req *http.Request, hasBody bool) ([][2]string, error) {
+	if req.URL == nil {
+		return nil, errors.New("client: nil request URL")
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if host == "" {
+		return nil, errors.New("client: request has no host")
+	}
+	if method == http.MethodConnect {
+		return nil, errors.New("client: CONNECT is not supported")
+	}
+	fields := [][2]string{
+		{":method", method},
+		{":scheme", req.URL.Scheme},
+		{":authority", host},
+		{":path", req.URL.RequestURI()},
+	}
+
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lk := strings.ToLower(k)
+		if lk == "content-length" || slices.Contains(connectionHeaders, lk) {
+			continue
+		}
+		for _, v := range req.Header[k] {
+			// TE may only ask for trailers.
+			if lk == "te" && v != "trailers" {
+				continue
+			}
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return nil, fmt.Errorf("client: invalid value for header %q", k)
+			}
+			fields = append(fields, [2]string{lk, v})
+		}
+	}
+	if hasBody && req.ContentLength > 0 {
+		fields = append(fields, [2]string{"content-length", strconv.FormatInt(req.ContentLength, 10)})
+	}
+	return fields, nil
+}
+
+// writeBody sends the request body, then its trailers if it has any.
+func (cs *clientStream) writeBody() {
+	cc := cs.cc
+	body := cs.req.Body
+	defer body.Close()
+
+	trailers, err := trailerFields(cs.req.Trailer)
+	if err != nil {
+		cs.abort(err)
+		return
+	}
+	buf := make([]byte, 16384)
+	for {
+		n, rerr := body.Read(buf)
+		data := buf[:n]
+		for len(data) > 0 {
+			m, err := cs.awaitSendWindow(len(data))
+			if err != nil {
+				return
+			}
+			end := rerr == io.EOF && m == len(data) && trailers == nil
+			if err := cs.writeData(data[:m], end); err != nil {
+				return
+			}
+			data = data[m:]
+			if end {
+				return
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			cs.abort(rerr)
+			return
+		}
+	}
+
+	if trailers != nil {
+		cc.wmu.Lock()
+		cc.mu.Lock()
+		ok, maxFrame := cs.err == nil, int(cc.maxFrameSize)
+		cc.mu.Unlock()
+		if ok {
+			err = cc.writeHeaders(cs.id, trailers, true, maxFrame)
+		}
+		cc.wmu.Unlock()
+		if err != nil {
+			cc.closeWithError(err)
+			return
+		}
+		cs.sentEndStream()
+		return
+	}
+	cs.writeData(nil, true)
+}
+
+func trailerFields(h http.Header) ([][2]string, error) {
+	var fields [][2]string
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return nil, fmt.Errorf("client: invalid value for trailer %q", k)
+			}
+			fields = append(fields, [2]string{strings.ToLower(k), v})
+		}
+	}
+	return fields, nil
+}
+
+// awaitSendWindow waits until up to n octets may be sent, and takes
+// them from the stream's and the connection's windows.
+func (cs *clientStream) awaitSendWindow(n int) (int, error) {
+	cc := cs.cc
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	var since time.Time
+	defer func() {
+		if !since.IsZero() {
+			cc.stalled += time.Since(since)
+		}
+	}()
+	for {
+		if cs.err != nil {
+			return 0, cs.err
+		}
+		if cc.err != nil {
+			return 0, cc.err
+		}
+		allowed := min(int64(n), cs.sendWindow, cc.sendWindow, int64(cc.maxFrameSize))
+		if allowed > 0 {
+			cs.sendWindow -= allowed
+			cc.sendWindow -= allowed
+			return int(allowed), nil
+		}
+		if since.IsZero() {
+			since = time.Now()
+		}
+		cc.cond.Wait()
+	}
+}
+
+func (cs *clientStream) writeData(data []byte, endStream bool) error {
+	cc := cs.cc
+	var flags uint8
+	if endStream {
+		flags = session.FLAG_END_STREAM
+	}
+	cc.wmu.Lock()
+	cc.mu.Lock()
+	err := cs.err
+	cc.mu.Unlock()
+	if err == nil {
+		err = cc.writeFrame(frame.FrameData, flags, cs.id, data)
+		if err != nil {
+			cc.closeWithError(err)
+		}
+	}
+	cc.wmu.Unlock()
+	if err == nil && endStream {
+		cs.sentEndStream()
+	}
+	return err
+}
+
+func (cs *clientStream) sentEndStream() {
+	cs.cc.mu.Lock()
+	defer cs.cc.mu.Unlock()
+	cs.sentEnd = true
+	if cs.recvEnd {
+		cs.cc.forget(cs)
+	}
+}
+
+// newResponse builds the response from its header fields. It returns
+// nil for 1xx responses, which are followed by the real one. Callers
+// must hold cc.mu.
+func (cs *clientStream) newResponse(fields [][2]string, endStream bool) (*http.Response, error) {
+	var status string
+	header := make(http.Header)
+	for _, f := range fields {
+		if strings.HasPrefix(f[0], ":") {
+			if f[0] != ":status" || status != "" || len(header) > 0 {
+				return nil, fmt.Errorf("malformed pseudo-header %q", f[0])
+			}
+			status = f[1]
+			continue
+		}
+		header.Add(f[0], f[1])
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || len(status) != 3 {
+		return nil, fmt.Errorf("invalid :status %q", status)
+	}
+	if code < 200 {
+		if endStream {
+			return nil, errors.New("informational response ends the stream")
+		}
+		return nil, nil
+	}
+
+	resp := &http.Response{
+		Status:        status + " " + http.StatusText(code),
+		StatusCode:    code,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		ContentLength: -1,
+		Request:       cs.req,
+		TLS:           cs.cc.tls,
+	}
+	if cl := header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			resp.ContentLength = n
+		}
+	}
+	// Declared trailers are listed with no values until they arrive.
+	for _, v := range header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				if resp.Trailer == nil {
+					resp.Trailer = make(http.Header)
+				}
+				resp.Trailer[http.CanonicalHeaderKey(k)] = nil
+			}
+		}
+	}
+	switch {
+	case endStream:
+		resp.Body = http.NoBody
+		if resp.ContentLength < 0 && cs.req.Method != http.MethodHead {
+			resp.ContentLength = 0
+		}
+	case cs.req.Method == http.MethodHead:
+		resp.Body = http.NoBody
+	default:
+		resp.Body = &responseBody{cs: cs}
+	}
+	return resp, nil
+}
+
+// setTrailers records a trailer block. Callers must hold cc.mu.
+func (cs *clientStream) setTrailers(fields [][2]string, endStream bool) error {
+	if !endStream {
+		return errors.New("trailers don't end the stream")
+	}
+	if cs.resp.Trailer == nil {
+		cs.resp.Trailer = make(http.Header)
+	}
+	for _, f := range fields {
+		if strings.HasPrefix(f[0], ":") {
+			return fmt.Errorf("pseudo-header %q in trailers", f[0])
+		}
+		cs.resp.Trailer.Add(f[0], f[1])
+	}
+	return nil
+}
+
+// endRecv is called once the response is complete. Callers must hold
+// cc.mu.
+func (cs *clientStream) endRecv() {
+	cs.recvEnd = true
+	cs.body.closeWithError(io.EOF)
+	// The server may answer before it has the whole request; the
+	// rest of the body is still sent.
+	if cs.sentEnd {
+		cs.cc.forget(cs)
+	}
+}
+
+// fail ends the stream with err. Callers must hold cc.mu.
+func (cs *clientStream) fail(err error) {
+	if cs.err == nil {
+		cs.err = err
+	}
+	if !cs.gotResponse {
+		cs.gotResponse = true
+		cs.respErr = err
+		close(cs.respReady)
+	}
+	// A complete response can still be read.
+	if !cs.recvEnd {
+		cs.body.breakWithError(err)
+	}
+	cs.cc.forget(cs)
+}
+
+// reset ends a stream the server got wrong. Callers must hold cc.mu.
+func (cs *clientStream) reset(code session.ErrorCode) {
+	if cs.forgotten {
+		return
+	}
+	cs.fail(fmt.Errorf("client: stream %d: %s from server", cs.id, code))
+	go cs.cc.sendReset(cs.id, code)
+}
+
+// abort gives up on the stream from the client's side, telling the
+// server with RST_STREAM.
+func (cs *clientStream) abort(err error) {
+	cc := cs.cc
+	cc.mu.Lock()
+	if cs.forgotten {
+		cc.mu.Unlock()
+		return
+	}
+	cs.fail(err)
+	cc.mu.Unlock()
+	cc.sendReset(cs.id, session.ErrorCodeCancel)
+}
+
+type responseBody struct {
+	cs     *clientStream
+	mu     sync.Mutex
+	closed bool
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return 0, bodyClosed
+	}
+	n, err := b.cs.body.Read(p)
+	if n > 0 {
+		b.cs.cc.returnWindow(b.cs, n)
+	}
+	return n, err
+}
+
+// Close resets the stream if the response hasn't been read to the end.
+func (b *responseBody) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cs.abort(bodyClosed)
+	return nil
+}
+
+// A pipe buffers a response body between the read loop and the user.
+// The server ignoring flow control could make it grow without bound,
+// but a server is trusted that far.
+type pipe struct {
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  bytes.Buffer
+	err  error // returned once buf is empty
+}
+
+func (p *pipe) Read(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.buf.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.buf.Len() > 0 {
+		return p.buf.Read(data)
+	}
+	return 0, p.err
+}
+
+func (p *pipe) Write(data []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.buf.Write(data)
+		p.cond.Signal()
+	}
+}
+
+// closeWithError ends the body once what's buffered has been read.
+func (p *pipe) closeWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+}
+
+// breakWithError ends the body at once, dropping what's buffered.
+func (p *pipe) breakWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.buf.Reset()
+	p.cond.Broadcast()
+}
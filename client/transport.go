@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"http2/session"
+	"http2/session/settings"
+)
+
+// A Transport is an http.RoundTripper that pools HTTP/2 connections.
+// Requests to a host share its connections up to the server's
+// MAX_CONCURRENT_STREAMS, and a new connection is dialled when they're
+// all busy. Connections are retired once the server sends GOAWAY.
+//
+// Requests the server refused without processing them, those on
+// streams past a GOAWAY's last stream ID or reset with REFUSED_STREAM,
+// are retried. A request with a body is only retried if it has
+// GetBody, as requests from http.NewRequest usually do.
+type Transport struct {
+	// Used for https URLs. "h2" is always offered with ALPN, and
+	// the server must pick it.
+	TLSClientConfig *tls.Config
+
+	// Dials the TCP connection. Defaults to net.Dialer.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Whether http URLs are allowed. They're sent over cleartext
+	// HTTP/2 (h2c) with prior knowledge, with no upgrade from
+	// HTTP/1.1.
+	AllowHTTP bool
+
+	// Sent to the server in the client's SETTINGS frame.
+	Settings settings.SettingsList
+
+	// If set, is given each connection once it's ready for HTTP/2,
+	// after any TLS handshake, and the connection it returns is used
+	// instead. It lets tools watch the frames in plaintext.
+	WrapConn func(net.Conn) net.Conn
+
+	poolOnce sync.Once
+	pool     *connPool
+}
+
+// How many times a request the server refused is tried again.
+const maxRetries = 6
+
+func (t *Transport) connPool() *connPool {
+	t.poolOnce.Do(func() { t.pool = &connPool{t: t} })
+	return t.pool
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scheme, addr, err := t.target(req)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		cc, err := t.connPool().get(req.Context(), scheme, addr)
+		if err != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+		resp, err := cc.RoundTrip(req)
+		if err == nil || !retryable(err) || attempt == maxRetries {
+			return resp, err
+		}
+		retry, rerr := rewindBody(req)
+		if rerr != nil {
+			return nil, err
+		}
+		req = retry
+		// Give a server that's refusing streams a moment.
+		if err := backoff(req.Context(), err, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) target(req *http.Request) (scheme, addr string, err error) {
+	if req.URL == nil {
+		return "", "", errors.New("client: nil request URL")
+	}
+	scheme = req.URL.Scheme
+	switch {
+	case scheme == "https":
+	case scheme == "http" && t.AllowHTTP:
+	default:
+		return "", "", fmt.Errorf("client: unsupported scheme %q", scheme)
+	}
+	return scheme, authorityAddr(scheme, req.URL.Host), nil
+}
+
+// retryable reports whether err means the server didn't process the
+// request (RFC 9113 §8.7).
+func retryable(err error) bool {
+	var ge *GoAwayError
+	var se *StreamError
+	switch {
+	case errors.As(err, &ge), errors.Is(err, ConnNotUsable):
+		return true
+	case errors.As(err, &se):
+		return se.Code == session.ErrorCodeRefusedStream
+	}
+	return false
+}
+
+// rewindBody returns a request to send again, with a fresh body if
+// it has one.
+func rewindBody(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("client: can't retry a request without GetBody")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	retry := *req
+	retry.Body = body
+	return &retry, nil
+}
+
+func backoff(ctx context.Context, err error, attempt int) error {
+	var se *StreamError
+	if !errors.As(err, &se) {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(1<<attempt) * 10 * time.Millisecond)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// authorityAddr adds the scheme's default port to a URL host.
+func authorityAddr(scheme, host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "443"
+	if scheme == "http" {
+		port = "80"
+	}
+	// JoinHostPort brackets IPv6 literals, which the URL
+	// already has.
+	if len(host) > 1 && host[0] == '[' && host[len(host)-1] == ']' {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func (t *Transport) dial(ctx context.Context, scheme, addr string) (*ClientConn, error) {
+	dial := t.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// The TLS state is kept aside, since WrapConn hides the
+	// *tls.Conn.
+	var cs *tls.ConnectionState
+	if scheme == "https" {
+		tc := tls.Client(conn, t.tlsConfig(addr))
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		state := tc.ConnectionState()
+		if p := state.NegotiatedProtocol; p != "h2" {
+			conn.Close()
+			return nil, fmt.Errorf("client: %s negotiated %q instead of h2", addr, p)
+		}
+		conn, cs = tc, &state
+	}
+	if t.WrapConn != nil {
+		conn = t.WrapConn(conn)
+	}
+	return newClientConn(conn, t.Settings, cs)
+}
+
+func (t *Transport) tlsConfig(addr string) *tls.Config {
+	var cfg *tls.Config
+	if t.TLSClientConfig != nil {
+		cfg = t.TLSClientConfig.Clone()
+	} else {
+		cfg = new(tls.Config)
+	}
+	if !slices.Contains(cfg.NextProtos, "h2") {
+		cfg.NextProtos = append([]string{"h2"}, cfg.NextProtos...)
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	return cfg
+}
+
+// lookupIP resolves a host for connection coalescing.
+func (t *Transport) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
+
+// CloseIdleConnections closes connections with no requests in flight.
+// http.Client calls it from its own CloseIdleConnections.
+func (t *Transport) CloseIdleConnections() {
+	t.connPool().closeIdle()
+}
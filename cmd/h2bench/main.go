@@ -0,0 +1,345 @@
+// Command h2bench measures an HTTP/2 server, in the manner of h2load:
+//
+//	h2bench [-c conns] [-m streams] [-n requests | -d duration] [-r "METHOD PATH [BODY]"]... [url]
+//
+// It opens -c connections and keeps -m requests in flight on each,
+// cycling through the request mix given with -r. BODY is the size in
+// octets of the request body to send. Without a url it benchmarks the
+// Dispatcher in-process, over loopback TCP; the in-process server
+// drains request bodies and answers with as many octets as the "n"
+// query parameter asks for.
+//
+// The report gives requests per second, latency percentiles, how many
+// octets HPACK saved in each direction, and how long requests spent
+// waiting for the server's flow-control windows.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"http2/client"
+	"http2/pkg/framedump"
+	"http2/session"
+	"http2/session/settings"
+)
+
+// A request is one entry of the request mix.
+type request struct {
+	method string
+	path   string
+	body   int
+}
+
+type requestFlags []request
+
+func (r *requestFlags) String() string {
+	var s []string
+	for _, req := range *r {
+		s = append(s, fmt.Sprintf("%s %s %d", req.method, req.path, req.body))
+	}
+	return strings.Join(s, ", ")
+}
+
+func (r *requestFlags) Set(v string) error {
+	fields := strings.Fields(v)
+	if len(fields) < 2 || len(fields) > 3 {
+		return fmt.Errorf("request %q isn't \"METHOD PATH [BODY]\"", v)
+	}
+	req := request{method: fields[0], path: fields[1]}
+	if len(fields) == 3 {
+		n, err := strconv.Atoi(fields[2])
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad body size %q", fields[2])
+		}
+		req.body = n
+	}
+	*r = append(*r, req)
+	return nil
+}
+
+var defaultMix = []request{
+	{"GET", "/", 0},
+	{"GET", "/?n=16384", 0},
+	{"POST", "/", 4096},
+}
+
+type options struct {
+	conns    int
+	streams  int
+	requests int
+	duration time.Duration
+	mix      requestFlags
+	insecure bool
+}
+
+func main() {
+	var opts options
+	flag.IntVar(&opts.conns, "c", 1, "number of connections")
+	flag.IntVar(&opts.streams, "m", 10, "concurrent streams per connection")
+	flag.IntVar(&opts.requests, "n", 10000, "number of requests to send")
+	flag.DurationVar(&opts.duration, "d", 0, "run for this long instead of sending -n requests")
+	flag.Var(&opts.mix, "r", "a request of the mix, as \"METHOD PATH [BODY]\" (repeatable)")
+	flag.BoolVar(&opts.insecure, "k", false, "don't verify the server's certificate")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: h2bench [flags] [url]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || opts.conns < 1 || opts.streams < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(opts.mix) == 0 {
+		opts.mix = defaultMix
+	}
+
+	res, err := run(opts, flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "h2bench:", err)
+		os.Exit(1)
+	}
+	res.print(os.Stdout)
+}
+
+// A dialer opens the connections to benchmark.
+type dialer struct {
+	scheme string
+	host   string
+	dial   func(ctx context.Context) (net.Conn, error)
+	close  func()
+}
+
+// newDialer returns a dialer for target, or for a new in-process
+// server if target is empty.
+func newDialer(opts options, target string) (*dialer, error) {
+	if target == "" {
+		return inProcess()
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	d := &dialer{scheme: u.Scheme, host: u.Host, close: func() {}}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	switch u.Scheme {
+	case "http":
+		d.dial = func(ctx context.Context) (net.Conn, error) {
+			var nd net.Dialer
+			return nd.DialContext(ctx, "tcp", addr)
+		}
+	case "https":
+		cfg := &tls.Config{
+			ServerName:         u.Hostname(),
+			NextProtos:         []string{"h2"},
+			InsecureSkipVerify: opts.insecure,
+		}
+		d.dial = func(ctx context.Context) (net.Conn, error) {
+			td := tls.Dialer{Config: cfg}
+			conn, err := td.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			if p := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; p != "h2" {
+				conn.Close()
+				return nil, fmt.Errorf("%s negotiated %q instead of h2", addr, p)
+			}
+			return conn, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	return d, nil
+}
+
+// inProcess starts a server on loopback. net.Pipe won't do: the
+// Dispatcher and ClientConn both write from their read loops, which
+// deadlocks without a buffer between them.
+func inProcess() (*dialer, error) {
+	srv := &session.Server{
+		Handler:  session.FuncHandler(benchHandler),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	d := &dialer{scheme: "http", host: "h2bench", close: func() { srv.Close() }}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go srv.Serve(l)
+	addr := l.Addr().String()
+	d.dial = func(ctx context.Context) (net.Conn, error) {
+		var nd net.Dialer
+		return nd.DialContext(ctx, "tcp", addr)
+	}
+	return d, nil
+}
+
+var zeros = make([]byte, 16384)
+
+func benchHandler(req *session.Request, res *session.Response) {
+	if req.Body != nil {
+		io.Copy(io.Discard, req.Body)
+	}
+	n, _ := strconv.Atoi(req.URL().Query().Get("n"))
+	for n > 0 {
+		m := min(n, len(zeros))
+		if _, err := res.Write(zeros[:m]); err != nil {
+			return
+		}
+		n -= m
+	}
+}
+
+// A worker sends requests one after another on a connection.
+type worker struct {
+	latencies []time.Duration
+	failed    int
+	non2xx    int
+	received  int64
+	lastErr   error
+}
+
+func run(opts options, target string) (*result, error) {
+	d, err := newDialer(opts, target)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	conns := make([]*client.ClientConn, opts.conns)
+	sent, recv := &meter{}, &meter{}
+	for i := range conns {
+		conn, err := d.dial(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		out, in := newHeaderMeter(sent), newHeaderMeter(recv)
+		cc, err := client.NewClientConn(framedump.Tap(conn, out, in), settings.SettingsList{})
+		if err != nil {
+			return nil, err
+		}
+		defer cc.Close()
+		conns[i] = cc
+	}
+
+	ctx := context.Background()
+	if opts.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	// Each worker takes the next request number until -n have been
+	// taken or the duration is up.
+	var next atomic.Int64
+	take := func() (int64, bool) {
+		if ctx.Err() != nil {
+			return 0, false
+		}
+		i := next.Add(1) - 1
+		return i, opts.duration > 0 || i < int64(opts.requests)
+	}
+
+	workers := make([]*worker, opts.conns*opts.streams)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		w := &worker{}
+		workers[i] = w
+		cc := conns[i%opts.conns]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				n, ok := take()
+				if !ok {
+					return
+				}
+				w.do(ctx, cc, d, opts.mix[n%int64(len(opts.mix))])
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	res := &result{elapsed: elapsed, sent: sent, recv: recv}
+	for _, cc := range conns {
+		res.stalled += cc.StallTime()
+	}
+	for _, w := range workers {
+		res.latencies = append(res.latencies, w.latencies...)
+		res.failed += w.failed
+		res.non2xx += w.non2xx
+		res.received += w.received
+		if w.lastErr != nil {
+			res.lastErr = w.lastErr
+		}
+	}
+	return res, nil
+}
+
+func (w *worker) do(ctx context.Context, cc *client.ClientConn, d *dialer, r request) {
+	var body io.Reader
+	if r.body > 0 {
+		body = io.LimitReader(zeroReader{}, int64(r.body))
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, d.scheme+"://"+d.host+r.path, body)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	start := time.Now()
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		// Requests cut off by the end of the run don't count.
+		if ctx.Err() == nil {
+			w.fail(err)
+		}
+		return
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		if ctx.Err() == nil {
+			w.fail(err)
+		}
+		return
+	}
+	w.latencies = append(w.latencies, time.Since(start))
+	w.received += n
+	if resp.StatusCode/100 != 2 {
+		w.non2xx++
+	}
+}
+
+func (w *worker) fail(err error) {
+	w.failed++
+	w.lastErr = err
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
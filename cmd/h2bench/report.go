@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+)
+
+// A meter totals the header blocks sent in one direction over all
+// connections: their size on the wire, and the size of the fields
+// they decode to, counted as "name: value\r\n" as in HTTP/1.1.
+type meter struct {
+	wire, raw atomic.Int64
+}
+
+// A headerMeter follows one direction of one connection for its
+// meter. It needs its own HPACK table to decode the blocks.
+type headerMeter struct {
+	*meter
+	table *hpack.HeaderLookupTable
+	block []byte
+}
+
+// newHeaderMeter returns a writer that splits the bytes written to it
+// into frames and meters their header blocks.
+func newHeaderMeter(m *meter) *frame.Splitter {
+	hm := &headerMeter{meter: m, table: hpack.NewHeaderLookupTable()}
+	return frame.NewSplitter(hm.frame)
+}
+
+func (m *headerMeter) frame(fh *frame.FrameHeader, data []byte) {
+	switch fh.Type {
+	case frame.FrameHeaders:
+		block, err := frame.HeaderBlockFragment(fh, data)
+		if err != nil {
+			return
+		}
+		m.block = append(m.block[:0], block...)
+	case frame.FrameContinuation:
+		m.block = append(m.block, data...)
+	default:
+		return
+	}
+	if !fh.Flag(2) {
+		return
+	}
+	m.wire.Add(int64(len(m.block)))
+	hpack.DecodeBlock(m.table, m.block, func(k, v string) {
+		m.raw.Add(int64(len(k) + len(v) + 4))
+	})
+}
+
+type result struct {
+	elapsed   time.Duration
+	latencies []time.Duration
+	failed    int
+	non2xx    int
+	received  int64
+	lastErr   error
+	sent      *meter
+	recv      *meter
+	stalled   time.Duration
+}
+
+// percentile returns the latency below which p of the sorted
+// latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p * float64(len(sorted)))
+	return sorted[min(i, len(sorted)-1)]
+}
+
+func (r *result) print(w io.Writer) {
+	done := len(r.latencies)
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "requests:     %d done, %d failed, %d non-2xx in %v\n",
+		done, r.failed, r.non2xx, r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput:   %.1f req/s, %.2f MB/s of response bodies\n",
+		float64(done)/secs, float64(r.received)/secs/1e6)
+	if done > 0 {
+		sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+		var sum time.Duration
+		for _, l := range r.latencies {
+			sum += l
+		}
+		fmt.Fprintf(w, "latency:      min %v, mean %v, p50 %v, p90 %v, p99 %v, max %v\n",
+			r.latencies[0], sum/time.Duration(done),
+			percentile(r.latencies, 0.5), percentile(r.latencies, 0.9),
+			percentile(r.latencies, 0.99), r.latencies[done-1])
+	}
+	for _, dir := range []struct {
+		name string
+		m    *meter
+	}{{"sent", r.sent}, {"received", r.recv}} {
+		wire, raw := dir.m.wire.Load(), dir.m.raw.Load()
+		var saved float64
+		if raw > 0 {
+			saved = 100 * float64(raw-wire) / float64(raw)
+		}
+		fmt.Fprintf(w, "headers %-9s %d octets for %d (%d saved, %.1f%%)\n",
+			dir.name+":", wire, raw, raw-wire, saved)
+	}
+	fmt.Fprintf(w, "flow control: requests stalled %v in all\n", r.stalled)
+	if r.lastErr != nil {
+		fmt.Fprintf(w, "last error:   %v\n", r.lastErr)
+	}
+}
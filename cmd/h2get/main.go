@@ -0,0 +1,216 @@
+// Command h2get performs HTTP/2 requests with this module's client,
+// much like curl:
+//
+//	h2get [flags] url...
+//
+// Several URLs are requested concurrently, over one connection per
+// server, and their responses printed in order. Without TLS (http
+// URLs) the server has to accept HTTP/2 with prior knowledge.
+//
+// With -v every frame sent (>) and received (<) is printed to stderr,
+// with header blocks, SETTINGS, WINDOW_UPDATE, RST_STREAM and GOAWAY
+// decoded.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"http2/client"
+	"http2/pkg/framedump"
+)
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q isn't Name: value", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+type options struct {
+	method   string
+	headers  headerFlags
+	data     string
+	include  bool
+	verbose  bool
+	insecure bool
+	color    bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.method, "X", "", "request method (default GET, or POST with -d)")
+	flag.Var(&opts.headers, "H", "request header as \"Name: value\" (repeatable)")
+	flag.StringVar(&opts.data, "d", "", "request body; @file reads it from a file and @- from stdin")
+	flag.BoolVar(&opts.include, "i", false, "print the response status, headers and trailers")
+	flag.BoolVar(&opts.verbose, "v", false, "dump every frame sent and received to stderr")
+	flag.BoolVar(&opts.insecure, "k", false, "don't verify the server's certificate")
+	flag.BoolVar(&opts.color, "color", false, "colour the frame dump")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: h2get [flags] url...")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(opts, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "h2get:", err)
+		os.Exit(1)
+	}
+}
+
+// readBody loads the body given with -d, so it can be sent for every
+// URL.
+func readBody(data string) ([]byte, error) {
+	name, ok := strings.CutPrefix(data, "@")
+	switch {
+	case !ok:
+		return []byte(data), nil
+	case name == "-":
+		return io.ReadAll(os.Stdin)
+	default:
+		return os.ReadFile(name)
+	}
+}
+
+func newTransport(opts options) *client.Transport {
+	tr := &client.Transport{
+		AllowHTTP:       true,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.insecure},
+	}
+	if opts.verbose {
+		// Each connection gets its own decoders, since each side
+		// has its own HPACK table.
+		var mu sync.Mutex
+		tr.WrapConn = func(conn net.Conn) net.Conn {
+			mu.Lock()
+			fmt.Fprintf(os.Stderr, "* connected to %s\n", conn.RemoteAddr())
+			mu.Unlock()
+			out := framedump.NewDecoder(os.Stderr, "> ", &mu)
+			in := framedump.NewDecoder(os.Stderr, "< ", &mu)
+			out.Color, in.Color = opts.color, opts.color
+			framedump.Pair(out, in)
+			return framedump.Tap(conn, out, in)
+		}
+	}
+	return tr
+}
+
+func run(opts options, urls []string) error {
+	var body []byte
+	if opts.data != "" {
+		var err error
+		if body, err = readBody(opts.data); err != nil {
+			return err
+		}
+	}
+	method := opts.method
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+
+	tr := newTransport(opts)
+	defer tr.CloseIdleConnections()
+
+	outputs := make([]bytes.Buffer, len(urls))
+	errs := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fetch(tr, opts, method, u, body, &outputs[i])
+		}()
+	}
+	wg.Wait()
+
+	var failed int
+	for i, u := range urls {
+		if len(urls) > 1 {
+			fmt.Printf("==> %s <==\n", u)
+		}
+		os.Stdout.Write(outputs[i].Bytes())
+		if errs[i] != nil {
+			if len(urls) > 1 {
+				fmt.Fprintf(os.Stderr, "h2get: %s: %v\n", u, errs[i])
+			}
+			failed++
+		}
+	}
+	if failed > 0 && len(urls) > 1 {
+		return fmt.Errorf("%d of %d requests failed", failed, len(urls))
+	} else if failed > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func fetch(tr *client.Transport, opts options, method, url string, body []byte, w io.Writer) error {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, rd)
+	if err != nil {
+		return err
+	}
+	for _, h := range opts.headers {
+		k, v, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if opts.include {
+		fmt.Fprintf(w, "HTTP/2 %d\n", resp.StatusCode)
+		printHeader(w, resp.Header)
+		fmt.Fprintln(w)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if opts.include && len(resp.Trailer) > 0 {
+		fmt.Fprintln(w)
+		printHeader(w, resp.Trailer)
+	}
+	return nil
+}
+
+func printHeader(w io.Writer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s: %s\n", strings.ToLower(k), v)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Command h2spy sits between an HTTP/2 client and server and prints
+// the frames each side sends:
+//
+//	h2spy -upstream host:port [-listen addr] [-cert file -key file] [-o file]
+//
+// It terminates the client's TLS with the given certificate, or a
+// self-signed one for localhost, connects to the upstream with TLS
+// (or, with -h2c, cleartext prior knowledge), and relays the traffic
+// untouched. Each direction is decoded with its own HPACK table, so
+// header blocks are shown in full even though the proxy takes no part
+// in either side's compression state.
+//
+// Lines are prefixed with the connection number and ">" for frames
+// from the client or "<" for frames from the upstream. With -o the
+// transcript is also recorded, uncoloured, to a file.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"http2/internal/selfsigned"
+	"http2/pkg/framedump"
+)
+
+type options struct {
+	listen   string
+	upstream string
+	certFile string
+	keyFile  string
+	h2c      bool
+	insecure bool
+	color    bool
+	maxData  int
+	record   string
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.listen, "listen", "127.0.0.1:8443", "address to accept clients on")
+	flag.StringVar(&opts.upstream, "upstream", "", "host:port of the server to relay to")
+	flag.StringVar(&opts.certFile, "cert", "", "certificate shown to clients (default: self-signed for localhost)")
+	flag.StringVar(&opts.keyFile, "key", "", "key for -cert")
+	flag.BoolVar(&opts.h2c, "h2c", false, "speak cleartext HTTP/2 to the upstream")
+	flag.BoolVar(&opts.insecure, "k", false, "don't verify the upstream's certificate")
+	flag.BoolVar(&opts.color, "color", true, "colour the transcript on stdout")
+	flag.IntVar(&opts.maxData, "data", 64, "octets of each DATA frame to show")
+	flag.StringVar(&opts.record, "o", "", "also record the transcript to this file")
+	flag.Parse()
+	if opts.upstream == "" || flag.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: h2spy -upstream host:port [flags]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err := run(opts); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(opts options) error {
+	var cert tls.Certificate
+	var err error
+	if opts.certFile != "" {
+		cert, err = tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+	} else {
+		cert, err = selfsigned.New("localhost", "127.0.0.1", "::1")
+	}
+	if err != nil {
+		return err
+	}
+
+	sp := &spy{opts: opts, out: os.Stdout}
+	if opts.record != "" {
+		f, err := os.Create(opts.record)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sp.record = f
+	}
+
+	l, err := tls.Listen("tcp", opts.listen, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	sp.logf("listening on %s, relaying to %s", l.Addr(), opts.upstream)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go sp.relay(conn.(*tls.Conn), sp.nextID.Add(1))
+	}
+}
+
+// A spy relays connections and writes their transcript.
+type spy struct {
+	opts   options
+	out    io.Writer
+	record io.Writer
+	nextID atomic.Int64
+	// Keeps the lines of different connections and directions
+	// apart.
+	mu sync.Mutex
+}
+
+func (sp *spy) logf(format string, args ...any) {
+	line := fmt.Sprintf("* "+format+"\n", args...)
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	io.WriteString(sp.out, line)
+	if sp.record != nil {
+		io.WriteString(sp.record, line)
+	}
+}
+
+// decoders returns the writers that transcribe the two directions of
+// a connection: a pair of Decoders for stdout, and one for the
+// recording, each with its own HPACK tables.
+func (sp *spy) decoders(id int64) (out, in io.Writer) {
+	sinks := []io.Writer{sp.out}
+	if sp.record != nil {
+		sinks = append(sinks, sp.record)
+	}
+	var outs, ins []io.Writer
+	for i, w := range sinks {
+		o := framedump.NewDecoder(w, fmt.Sprintf("#%d > ", id), &sp.mu)
+		n := framedump.NewDecoder(w, fmt.Sprintf("#%d < ", id), &sp.mu)
+		framedump.Pair(o, n)
+		for _, d := range []*framedump.Decoder{o, n} {
+			d.Color = i == 0 && sp.opts.color
+			d.MaxData = sp.opts.maxData
+		}
+		outs = append(outs, o)
+		ins = append(ins, n)
+	}
+	return io.MultiWriter(outs...), io.MultiWriter(ins...)
+}
+
+func (sp *spy) dialUpstream() (net.Conn, error) {
+	if sp.opts.h2c {
+		return net.Dial("tcp", sp.opts.upstream)
+	}
+	host, _, _ := net.SplitHostPort(sp.opts.upstream)
+	conn, err := tls.Dial("tcp", sp.opts.upstream, &tls.Config{
+		ServerName:         host,
+		NextProtos:         []string{"h2"},
+		InsecureSkipVerify: sp.opts.insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p := conn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		conn.Close()
+		return nil, fmt.Errorf("upstream negotiated %q instead of h2", p)
+	}
+	return conn, nil
+}
+
+func (sp *spy) relay(client *tls.Conn, id int64) {
+	defer client.Close()
+	if err := client.Handshake(); err != nil {
+		sp.logf("#%d TLS handshake with %s: %v", id, client.RemoteAddr(), err)
+		return
+	}
+	if p := client.ConnectionState().NegotiatedProtocol; p != "h2" {
+		sp.logf("#%d client %s negotiated %q instead of h2", id, client.RemoteAddr(), p)
+		return
+	}
+	upstream, err := sp.dialUpstream()
+	if err != nil {
+		sp.logf("#%d %v", id, err)
+		return
+	}
+	defer upstream.Close()
+	sp.logf("#%d %s <-> %s", id, client.RemoteAddr(), upstream.RemoteAddr())
+
+	// The frames are passed on as they are, so the two sides agree
+	// on every stream and table without the proxy re-encoding them.
+	out, in := sp.decoders(id)
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn, tap io.Writer) {
+		io.Copy(dst, io.TeeReader(src, tap))
+		// Either side hanging up ends the relay.
+		dst.Close()
+		src.Close()
+		done <- struct{}{}
+	}
+	go pipe(upstream, client, out)
+	go pipe(client, upstream, in)
+	<-done
+	<-done
+	sp.logf("#%d closed", id)
+}
@@ -0,0 +1,230 @@
+// Command hpack decodes and encodes HPACK header blocks.
+//
+// Decoding reads one hex-encoded header block per line (whitespace is
+// ignored, lines starting with '#' are comments) and prints every
+// representation along with the dynamic table after each block:
+//
+//	hpack decode [-table-size n] [file]
+//
+// Encoding reads header lists and prints one hex block per list. Lists
+// are either "Name: value" lines separated by a blank line, or (with
+// -json) a stream of JSON arrays of {"name": "value"} objects:
+//
+//	hpack encode [-json] [-index default|incremental|none|never] [-table] [file]
+//
+// In both modes the dynamic table is shared across blocks, as it is
+// across a connection.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"http2/hpack"
+	"io"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: hpack decode [flags] [file]")
+	fmt.Fprintln(os.Stderr, "       hpack encode [flags] [file]")
+	os.Exit(2)
+}
+
+// openInput returns the file named by the first positional
+// argument, or stdin.
+func openInput(fs *flag.FlagSet) io.ReadCloser {
+	if fs.NArg() == 0 || fs.Arg(0) == "-" {
+		return io.NopCloser(os.Stdin)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return f
+}
+
+func decodeMain(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	tableSize := fs.Int("table-size", hpack.DefaultTableSize, "SETTINGS_HEADER_TABLE_SIZE advertised to the encoder")
+	fs.Parse(args)
+
+	in := openInput(fs)
+	defer in.Close()
+
+	tbl := hpack.NewHeaderLookupTable()
+	tbl.SetSizeLimit(*tableSize)
+	tbl.SetMaxSize(*tableSize)
+
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 1<<24)
+	blockNo := 0
+	for sc.Scan() {
+		line := strings.Join(strings.Fields(sc.Text()), "")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		data, err := hex.DecodeString(line)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", blockNo, err)
+		}
+		fmt.Printf("=== Block %d (%d octets)\n", blockNo, len(data))
+		err = hpack.DecodeBlockFunc(tbl, data, func(hdr hpack.Header, k, v string) {
+			if _, ok := hdr.(hpack.TableSizeUpdate); ok {
+				fmt.Println(hdr)
+				return
+			}
+			fmt.Printf("%s\n    %s: %s\n", hdr, k, v)
+		})
+		if err != nil {
+			return fmt.Errorf("block %d: %w", blockNo, err)
+		}
+		fmt.Print(tbl)
+		blockNo++
+	}
+	return sc.Err()
+}
+
+func indexPolicy(name string) (func(k, v string) hpack.LiteralIndexType, error) {
+	constant := func(typ hpack.LiteralIndexType) func(k, v string) hpack.LiteralIndexType {
+		return func(k, v string) hpack.LiteralIndexType {
+			return typ
+		}
+	}
+	switch name {
+	case "default":
+		return hpack.DefaultIndexPolicy, nil
+	case "incremental":
+		return constant(hpack.IncrementalIndex), nil
+	case "none":
+		return constant(hpack.NoIndex), nil
+	case "never":
+		return constant(hpack.NeverIndex), nil
+	}
+	return nil, fmt.Errorf("unknown index policy %q", name)
+}
+
+type headerField struct {
+	k, v string
+}
+
+// readTextLists parses "Name: value" lines, with header lists
+// separated by blank lines.
+func readTextLists(rd io.Reader) ([][]headerField, error) {
+	var (
+		ret  [][]headerField
+		curr []headerField
+	)
+	sc := bufio.NewScanner(rd)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			if curr != nil {
+				ret = append(ret, curr)
+				curr = nil
+			}
+			continue
+		}
+		// Pseudo-header names start with a colon, so look
+		// for the separator after it.
+		i := strings.Index(line[1:], ":")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed header line %q", line)
+		}
+		i += 1
+		curr = append(curr, headerField{strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])})
+	}
+	if curr != nil {
+		ret = append(ret, curr)
+	}
+	return ret, sc.Err()
+}
+
+// readJSONLists parses a stream of JSON arrays in the
+// hpack-test-case "headers" format.
+func readJSONLists(rd io.Reader) ([][]headerField, error) {
+	var ret [][]headerField
+	dec := json.NewDecoder(rd)
+	for {
+		var list []map[string]string
+		err := dec.Decode(&list)
+		if errors.Is(err, io.EOF) {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+		var fields []headerField
+		for _, h := range list {
+			for k, v := range h {
+				fields = append(fields, headerField{k, v})
+			}
+		}
+		ret = append(ret, fields)
+	}
+}
+
+func encodeMain(args []string) error {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	useJSON := fs.Bool("json", false, "read header lists as JSON")
+	index := fs.String("index", "default", "literal indexing policy: default, incremental, none or never")
+	showTable := fs.Bool("table", false, "print the dynamic table after each block")
+	fs.Parse(args)
+
+	policy, err := indexPolicy(*index)
+	if err != nil {
+		return err
+	}
+	in := openInput(fs)
+	defer in.Close()
+
+	var lists [][]headerField
+	if *useJSON {
+		lists, err = readJSONLists(in)
+	} else {
+		lists, err = readTextLists(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	tbl := hpack.NewHeaderLookupTable()
+	for _, fields := range lists {
+		hl := hpack.NewHeaderList(tbl)
+		hl.Policy = policy
+		for _, f := range fields {
+			hl.Put(f.k, f.v)
+		}
+		fmt.Println(hex.EncodeToString(hl.Dump()))
+		if *showTable {
+			fmt.Fprint(os.Stderr, tbl)
+		}
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "decode":
+		err = decodeMain(os.Args[2:])
+	case "encode":
+		err = encodeMain(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "hpack:", err)
+		os.Exit(1)
+	}
+}
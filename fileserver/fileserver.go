@@ -0,0 +1,308 @@
+// Package fileserver serves static files from an fs.FS.
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"http2/session"
+)
+
+// A FileServer is a session.Handler serving the files of an fs.FS.
+// It answers conditional requests (If-None-Match, If-Modified-Since)
+// with 304 Not Modified, and Range requests with 206 Partial Content.
+type FileServer struct {
+	fsys fs.FS
+
+	// Removed from the request path before looking up the file,
+	// for serving a directory under a URL prefix.
+	StripPrefix string
+
+	// The file served for a directory. Defaults to index.html.
+	Index string
+
+	// Whether to list directories that have no index file.
+	Browse bool
+}
+
+func New(fsys fs.FS) *FileServer {
+	return &FileServer{fsys: fsys, Index: "index.html"}
+}
+
+func (s *FileServer) Handle(req *session.Request, res *session.Response) {
+	if req.Method() != "GET" && req.Method() != "HEAD" {
+		res.SetHeader("allow", "GET, HEAD")
+		res.WriteHeader(session.MethodNotAllowed)
+		return
+	}
+
+	urlPath, ok := strings.CutPrefix(req.URL().Path, s.StripPrefix)
+	if !ok {
+		res.WriteHeader(session.NotFound)
+		return
+	}
+	name, ok := fsName(urlPath)
+	if !ok {
+		res.WriteHeader(session.BadRequest)
+		return
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		writeError(res, err)
+		return
+	}
+	if info.IsDir() {
+		// Relative links in an index only work with a trailing slash.
+		if !strings.HasSuffix(urlPath, "/") {
+			redirect(res, req.URL().Path+"/", req.URL().RawQuery)
+			return
+		}
+		index := path.Join(name, s.Index)
+		if info, err = fs.Stat(s.fsys, index); err == nil && !info.IsDir() {
+			name = index
+		} else if s.Browse {
+			s.listDir(res, name)
+			return
+		} else {
+			res.WriteHeader(session.Forbidden)
+			return
+		}
+	}
+	s.serveFile(req, res, name, info)
+}
+
+// fsName turns a URL path into an fs.FS name. Paths that try to
+// climb out of the root with .. are refused rather than cleaned.
+func fsName(urlPath string) (string, bool) {
+	if strings.Contains(urlPath, "\x00") || strings.Contains(urlPath, "\\") {
+		return "", false
+	}
+	for _, seg := range strings.Split(urlPath, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+func writeError(res *session.Response, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		res.WriteHeader(session.NotFound)
+	case errors.Is(err, fs.ErrPermission):
+		res.WriteHeader(session.Forbidden)
+	default:
+		res.WriteHeader(session.ServerError)
+	}
+}
+
+func redirect(res *session.Response, location, query string) {
+	if query != "" {
+		location += "?" + query
+	}
+	res.SetHeader("location", location)
+	res.WriteHeader(session.Moved)
+}
+
+// etag is derived from the size and modification time, which is
+// cheap and changes whenever the file is rewritten.
+func etag(info fs.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func (s *FileServer) serveFile(req *session.Request, res *session.Response, name string, info fs.FileInfo) {
+	tag := etag(info)
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	res.SetHeader("etag", tag)
+	if !info.ModTime().IsZero() {
+		res.SetHeader("last-modified", modTime.Format(http.TimeFormat))
+	}
+	res.SetHeader("accept-ranges", "bytes")
+
+	if notModified(req, tag, modTime) {
+		res.WriteHeader(session.NotModified)
+		return
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		var err error
+		if ctype, err = s.sniff(name); err != nil {
+			writeError(res, err)
+			return
+		}
+	}
+
+	size := info.Size()
+	var ranges []byteRange
+	if h := req.Header().Get("range"); h != "" && rangeApplies(req, tag, modTime) {
+		var err error
+		ranges, err = parseRange(h, size)
+		if errors.Is(err, unsatisfiableRange) {
+			res.SetHeader("content-range", fmt.Sprintf("bytes */%d", size))
+			res.WriteHeader(session.RangeNotSatisfiable)
+			return
+		}
+	}
+
+	if len(ranges) > 1 {
+		s.serveMultipart(res, name, ctype, size, ranges)
+		return
+	}
+	r := byteRange{0, size - 1}
+	if len(ranges) == 1 {
+		r = ranges[0]
+		res.SetHeader("content-range", r.contentRange(size))
+		res.SetResponseCode(session.PartialContent)
+	}
+	res.SetHeader("content-type", ctype)
+	res.SetHeader("content-length", strconv.FormatInt(r.length(), 10))
+	if req.Method() == "HEAD" {
+		return
+	}
+	if err := s.copyRange(res, name, r); err != nil {
+		res.Reset(session.ErrorCodeInternal)
+	}
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since if there
+// is no If-None-Match (RFC 9110 §13.2.2).
+func notModified(req *session.Request, tag string, modTime time.Time) bool {
+	if inm := req.Header().Get("if-none-match"); inm != "" {
+		return etagMatches(inm, tag)
+	}
+	if ims := req.Header().Get("if-modified-since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		return err == nil && !modTime.After(t)
+	}
+	return false
+}
+
+// etagMatches uses the weak comparison If-None-Match calls for.
+func etagMatches(list, tag string) bool {
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeApplies checks If-Range: a Range is only honoured if the
+// representation hasn't changed (RFC 9110 §13.1.5).
+func rangeApplies(req *session.Request, tag string, modTime time.Time) bool {
+	ir := req.Header().Get("if-range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == tag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && t.Equal(modTime)
+}
+
+// sniff detects the content type from the start of the file.
+func (s *FileServer) sniff(name string) (string, error) {
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// copyRange writes part of a file. The file is opened again for each
+// range since fs.File only has to support reading in order.
+func (s *FileServer) copyRange(w io.Writer, name string, r byteRange) error {
+	if r.length() <= 0 {
+		return nil
+	}
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var rd io.Reader
+	switch ff := f.(type) {
+	case io.ReaderAt:
+		rd = io.NewSectionReader(ff, r.start, r.length())
+	case io.Seeker:
+		if _, err := ff.Seek(r.start, io.SeekStart); err != nil {
+			return err
+		}
+		rd = io.LimitReader(f, r.length())
+	default:
+		if _, err := io.CopyN(io.Discard, f, r.start); err != nil {
+			return err
+		}
+		rd = io.LimitReader(f, r.length())
+	}
+	_, err = io.Copy(w, rd)
+	return err
+}
+
+// serveMultipart answers a request for several ranges with a
+// multipart/byteranges body (RFC 9110 §14.6).
+func (s *FileServer) serveMultipart(res *session.Response, name, ctype string, size int64, ranges []byteRange) {
+	mw := multipart.NewWriter(res)
+	res.SetHeader("content-type", "multipart/byteranges; boundary="+mw.Boundary())
+	res.WriteHeader(session.PartialContent)
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {ctype},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err != nil {
+			return
+		}
+		if err := s.copyRange(part, name, r); err != nil {
+			res.Reset(session.ErrorCodeInternal)
+			return
+		}
+	}
+	mw.Close()
+}
+
+func (s *FileServer) listDir(res *session.Response, name string) {
+	entries, err := fs.ReadDir(s.fsys, name)
+	if err != nil {
+		writeError(res, err)
+		return
+	}
+	res.SetHeader("content-type", "text/html; charset=utf-8")
+	fmt.Fprintf(res, "<!DOCTYPE html>\n<pre>\n")
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		}
+		u := url.URL{Path: n}
+		fmt.Fprintf(res, "<a href=\"%s\">%s</a>\n", u.EscapedPath(), html.EscapeString(n))
+	}
+	fmt.Fprintf(res, "</pre>\n")
+}
@@ -0,0 +1,176 @@
+package fileserver
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"http2/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var modTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"index.html":      {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+	"css/site.css":    {Data: []byte("body{}"), ModTime: modTime},
+	"data/digits":     {Data: []byte("0123456789"), ModTime: modTime},
+	"empty/.keep":     {Data: nil, ModTime: modTime},
+	"docs/readme.txt": {Data: []byte("read me"), ModTime: modTime},
+}
+
+func get(t *testing.T, s *FileServer, method, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	session.ToHTTPHandler(s).ServeHTTP(rec, r)
+	return rec
+}
+
+func TestServeFile(t *testing.T) {
+	s := New(testFS)
+
+	rec := get(t, s, "GET", "/css/site.css")
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "body{}", rec.Body.String())
+	assert.Equal(t, "text/css; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "6", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "Fri, 01 Mar 2024 12:00:00 GMT", rec.Header().Get("Last-Modified"))
+	assert.NotEmpty(t, rec.Header().Get("Etag"))
+
+	// No extension, so the type is sniffed.
+	rec = get(t, s, "GET", "/data/digits")
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = get(t, s, "HEAD", "/data/digits")
+	assert.Equal(t, 200, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Body.String())
+
+	assert.Equal(t, 404, get(t, s, "GET", "/missing").Code)
+	assert.Equal(t, 405, get(t, s, "POST", "/index.html").Code)
+}
+
+func TestServeDirectory(t *testing.T) {
+	s := New(testFS)
+
+	rec := get(t, s, "GET", "/")
+	assert.Equal(t, "<h1>home</h1>", rec.Body.String())
+
+	rec = get(t, s, "GET", "/docs?x=1")
+	assert.Equal(t, 301, rec.Code)
+	assert.Equal(t, "/docs/?x=1", rec.Header().Get("Location"))
+
+	assert.Equal(t, 403, get(t, s, "GET", "/docs/").Code)
+
+	s.Browse = true
+	rec = get(t, s, "GET", "/docs/")
+	assert.Equal(t, 200, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<a href="readme.txt">readme.txt</a>`)
+}
+
+func TestTraversal(t *testing.T) {
+	s := New(testFS)
+	for _, target := range []string{"/../secret", "/css/../../secret", "/css/..%2f..%2fsecret", "/a%5c..%5csecret"} {
+		assert.Equal(t, 400, get(t, s, "GET", target).Code, target)
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	s := New(testFS)
+	s.StripPrefix = "/static"
+	assert.Equal(t, "body{}", get(t, s, "GET", "/static/css/site.css").Body.String())
+	assert.Equal(t, 404, get(t, s, "GET", "/css/site.css").Code)
+}
+
+func TestConditional(t *testing.T) {
+	s := New(testFS)
+	tag := get(t, s, "GET", "/index.html").Header().Get("Etag")
+
+	rec := get(t, s, "GET", "/index.html", "If-None-Match", `"other", `+tag)
+	assert.Equal(t, 304, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, tag, rec.Header().Get("Etag"))
+
+	assert.Equal(t, 304, get(t, s, "GET", "/index.html", "If-None-Match", "W/"+tag).Code)
+	assert.Equal(t, 200, get(t, s, "GET", "/index.html", "If-None-Match", `"other"`).Code)
+
+	assert.Equal(t, 304, get(t, s, "GET", "/index.html", "If-Modified-Since", "Fri, 01 Mar 2024 12:00:00 GMT").Code)
+	assert.Equal(t, 200, get(t, s, "GET", "/index.html", "If-Modified-Since", "Fri, 01 Mar 2024 11:59:59 GMT").Code)
+}
+
+func TestRange(t *testing.T) {
+	s := New(testFS)
+
+	rec := get(t, s, "GET", "/data/digits", "Range", "bytes=2-4")
+	assert.Equal(t, 206, rec.Code)
+	assert.Equal(t, "234", rec.Body.String())
+	assert.Equal(t, "bytes 2-4/10", rec.Header().Get("Content-Range"))
+	assert.Equal(t, "3", rec.Header().Get("Content-Length"))
+
+	rec = get(t, s, "GET", "/data/digits", "Range", "bytes=-3")
+	assert.Equal(t, "789", rec.Body.String())
+
+	rec = get(t, s, "GET", "/data/digits", "Range", "bytes=20-")
+	assert.Equal(t, 416, rec.Code)
+	assert.Equal(t, "bytes */10", rec.Header().Get("Content-Range"))
+
+	// Malformed ranges are ignored.
+	rec = get(t, s, "GET", "/data/digits", "Range", "lines=1-2")
+	assert.Equal(t, 200, rec.Code)
+
+	tag := rec.Header().Get("Etag")
+	assert.Equal(t, 206, get(t, s, "GET", "/data/digits", "Range", "bytes=0-0", "If-Range", tag).Code)
+	assert.Equal(t, 200, get(t, s, "GET", "/data/digits", "Range", "bytes=0-0", "If-Range", `"stale"`).Code)
+}
+
+func TestMultiRange(t *testing.T) {
+	s := New(testFS)
+	rec := get(t, s, "GET", "/data/digits", "Range", "bytes=0-1, 8-")
+	require.Equal(t, 206, rec.Code)
+
+	mt, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mt)
+
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	var ranges, bodies []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		b, _ := io.ReadAll(p)
+		ranges = append(ranges, p.Header.Get("Content-Range"))
+		bodies = append(bodies, string(b))
+	}
+	assert.Equal(t, []string{"bytes 0-1/10", "bytes 8-9/10"}, ranges)
+	assert.Equal(t, []string{"01", "89"}, bodies)
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := parseRange("bytes=0-", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{0, 4}}, r)
+
+	r, err = parseRange("bytes=3-100, -2", 5)
+	require.NoError(t, err)
+	assert.Equal(t, []byteRange{{3, 4}, {3, 4}}, r)
+
+	_, err = parseRange("bytes=5-", 5)
+	assert.ErrorIs(t, err, unsatisfiableRange)
+
+	for _, h := range []string{"bytes=", "bytes=a-b", "bytes=4-2", "bytes=1", "items=0-1"} {
+		_, err = parseRange(h, 5)
+		assert.ErrorIs(t, err, invalidRange, h)
+	}
+}
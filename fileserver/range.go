@@ -0,0 +1,85 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A byteRange is the inclusive range of octets [start, end].
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+var (
+	invalidRange       = errors.New("invalid range")
+	unsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// Clients asking for more ranges than this get the whole file.
+const maxRanges = 16
+
+// parseRange parses a Range header for a file of the given size
+// (RFC 9110 §14.1.2). Ranges that start past the end are dropped; if
+// none are left the range is unsatisfiable. invalidRange means the
+// header should be ignored.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, invalidRange
+	}
+	var ranges []byteRange
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRanges {
+		return nil, invalidRange
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, invalidRange
+		}
+		var r byteRange
+		if first == "" {
+			// Suffix range: the last n octets.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, invalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{max(size-n, 0), size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, invalidRange
+			}
+			r = byteRange{start, size - 1}
+			if last != "" {
+				end, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, invalidRange
+				}
+				r.end = min(end, size-1)
+			}
+			if start >= size {
+				continue
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, unsatisfiableRange
+	}
+	return ranges, nil
+}
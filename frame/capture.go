@@ -0,0 +1,185 @@
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// A capture file records the frames of one connection. It starts with
+// captureMagic and the time of the first record, as a uvarint count of
+// microseconds since the Unix epoch. Each record follows as:
+//
+//	direction  1 octet, 0 inbound or 1 outbound
+//	delta      uvarint, microseconds since the previous record
+//	frame      the frame as it was on the wire, header and payload
+var captureMagic = []byte("H2CAP\x01")
+
+var BadCapture = errors.New("frame: not a capture file")
+
+// A Direction says which way a recorded frame went, from the point of
+// view of the side that recorded it.
+type Direction uint8
+
+const (
+	Inbound Direction = iota
+	Outbound
+)
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// A Record is one frame of a capture.
+type Record struct {
+	Time  time.Time
+	Dir   Direction
+	Frame *Frame
+}
+
+// A CaptureWriter records frames to a capture file. It's safe for
+// concurrent use, so both directions of a connection can share one.
+type CaptureWriter struct {
+	// Timestamps the records. Defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	last    time.Time
+	err     error
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{w: w}
+}
+
+// WriteFrame records a frame. Once a write fails, every later one
+// returns the same error.
+func (cw *CaptureWriter) WriteFrame(dir Direction, fh *FrameHeader, data []byte) error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if cw.err != nil {
+		return cw.err
+	}
+	now := time.Now()
+	if cw.Now != nil {
+		now = cw.Now()
+	}
+
+	var buf bytes.Buffer
+	if !cw.started {
+		buf.Write(captureMagic)
+		buf.Write(binary.AppendUvarint(nil, uint64(now.UnixMicro())))
+		cw.last = time.UnixMicro(now.UnixMicro())
+		cw.started = true
+	}
+	buf.WriteByte(byte(dir))
+	// Records from concurrent writers can arrive a little out of
+	// order; they're kept in the order written.
+	delta := max(now.Sub(cw.last), 0)
+	buf.Write(binary.AppendUvarint(nil, uint64(delta.Microseconds())))
+	cw.last = cw.last.Add(delta.Truncate(time.Microsecond))
+	fh.Marshal(&buf)
+	buf.Write(data)
+
+	_, cw.err = cw.w.Write(buf.Bytes())
+	return cw.err
+}
+
+// Inbound returns a writer that records the bytes written to it as
+// inbound frames. It's meant to be the tap of an io.TeeReader on the
+// connection. A leading client preface is skipped.
+func (cw *CaptureWriter) Inbound() io.Writer {
+	return cw.splitter(Inbound)
+}
+
+// Outbound is like Inbound, for the frames sent on the connection.
+func (cw *CaptureWriter) Outbound() io.Writer {
+	return cw.splitter(Outbound)
+}
+
+func (cw *CaptureWriter) splitter(dir Direction) *Splitter {
+	return NewSplitter(func(fh *FrameHeader, data []byte) {
+		cw.WriteFrame(dir, fh, data)
+	})
+}
+
+// Close closes the underlying writer, if it's an io.Closer.
+func (cw *CaptureWriter) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if c, ok := cw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// A CaptureReader reads the records of a capture file.
+type CaptureReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+// NewCaptureReader checks that r holds a capture, and returns a reader
+// for its records.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, captureMagic) {
+		return nil, BadCapture
+	}
+	start, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, BadCapture
+	}
+	return &CaptureReader{r: br, last: time.UnixMicro(int64(start))}, nil
+}
+
+// ReadRecord returns the next record, or io.EOF after the last one.
+func (cr *CaptureReader) ReadRecord() (*Record, error) {
+	dir, err := cr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	delta, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	f, err := NewFramer(cr.r).ReadFrame()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	cr.last = cr.last.Add(time.Duration(delta) * time.Microsecond)
+	return &Record{Time: cr.last, Dir: Direction(dir), Frame: f}, nil
+}
+
+// ReadAll returns the remaining records.
+func (cr *CaptureReader) ReadAll() ([]*Record, error) {
+	var recs []*Record
+	for {
+		rec, err := cr.ReadRecord()
+		if err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// unexpected turns an EOF in the middle of a record into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package frame
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	var file bytes.Buffer
+	cw := NewCaptureWriter(&file)
+	cw.Now = func() time.Time { return now }
+
+	// The inbound side starts with the preface, and arrives in
+	// pieces that don't line up with frames.
+	var wire bytes.Buffer
+	wire.Write(ClientPreface)
+	(&FrameHeader{Type: FrameSettings}).Marshal(&wire)
+	(&FrameHeader{Length: 5, Type: FrameData, Flags: 1, Sid: 1}).Marshal(&wire)
+	wire.WriteString("hello")
+	in := cw.Inbound()
+	data := wire.Bytes()
+	for len(data) > 0 {
+		n := min(len(data), 5)
+		in.Write(data[:n])
+		data = data[n:]
+	}
+	now = now.Add(1500 * time.Microsecond)
+	require.NoError(t, cw.WriteFrame(Outbound, &FrameHeader{Length: 2, Type: FrameWindowUpdate, Sid: 1}, []byte{0, 5}))
+
+	cr, err := NewCaptureReader(&file)
+	require.NoError(t, err)
+	recs, err := cr.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, recs, 3)
+
+	assert.Equal(t, Inbound, recs[0].Dir)
+	assert.Equal(t, FrameSettings, recs[0].Frame.FrameHeader.Type)
+	assert.True(t, recs[0].Time.Equal(start))
+
+	assert.Equal(t, FrameData, recs[1].Frame.FrameHeader.Type)
+	assert.Equal(t, "hello", string(recs[1].Frame.Data))
+
+	assert.Equal(t, Outbound, recs[2].Dir)
+	assert.Equal(t, []byte{0, 5}, recs[2].Frame.Data)
+	assert.Equal(t, 1500*time.Microsecond, recs[2].Time.Sub(start))
+}
+
+func TestCaptureReaderErrors(t *testing.T) {
+	_, err := NewCaptureReader(bytes.NewReader([]byte("PRI * HTTP/2.0")))
+	assert.ErrorIs(t, err, BadCapture)
+
+	var file bytes.Buffer
+	cw := NewCaptureWriter(&file)
+	require.NoError(t, cw.WriteFrame(Inbound, &FrameHeader{Length: 3, Type: FrameData, Sid: 1}, []byte("abc")))
+	cr, err := NewCaptureReader(bytes.NewReader(file.Bytes()[:file.Len()-1]))
+	require.NoError(t, err)
+	_, err = cr.ReadRecord()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
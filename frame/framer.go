@@ -1,17 +1,19 @@
 package frame
 
 import (
-	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
 )
 
 var ClientPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
 var UnexpectedPreface = errors.New("unexpected preface")
+var FrameTooLarge = errors.New("frame larger than the maximum frame size")
 
 type Framer struct {
 	Incoming io.Reader
+
+	// The largest payload ReadFrame accepts. Zero means no limit.
+	MaxFrameSize uint32
 }
 
 func NewFramer(rd io.Reader) *Framer {
@@ -41,13 +43,16 @@ func (this *Framer) readPayload(n uint32) ([]uint8, error) {
 // Read a frame from the incoming connection. Returns the
 // frame header object + the frame payload if nonzero. If
 // the frame doesn't have a payload, ReadFrame returns a nil
-// slice.
+// slice. A frame longer than MaxFrameSize is a FrameTooLarge error,
+// and its payload is left unread.
 func (this *Framer) ReadFrame() (*Frame, error) {
 	fh, err := this.readHeader()
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("\x1b[33mReceive Frame\x1b[0m %s\n", fh)
+	if this.MaxFrameSize > 0 && fh.Length > this.MaxFrameSize {
+		return nil, FrameTooLarge
+	}
 	fr := Frame{
 		FrameHeader: fh,
 	}
@@ -58,7 +63,6 @@ func (this *Framer) ReadFrame() (*Frame, error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(hex.Dump(data[:min(len(data), 1024)]))
 	fr.Data = data
 	return &fr, nil
 }
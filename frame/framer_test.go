@@ -36,3 +36,10 @@ func TestFramer(t *testing.T) {
 
 	assert.EqualValues(t, "\xab\xcd", string(fr.Data))
 }
+
+func TestFramerMaxFrameSize(t *testing.T) {
+	framer := NewFramer(strings.NewReader("\x00\x00\x0c\x00\x01\x00\x00\x00\x02Hello, world"))
+	framer.MaxFrameSize = 11
+	_, err := framer.ReadFrame()
+	assert.ErrorIs(t, err, FrameTooLarge)
+}
@@ -0,0 +1,90 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	PayloadTooShort = errors.New("frame: payload too short for the fields its flags announce")
+	PaddingTooLong  = errors.New("frame: padding exceeds the payload")
+)
+
+// A Splitter cuts the bytes one side of a connection sent into frames,
+// for tools that watch a connection rather than take part in it.
+type Splitter struct {
+	// Called with each frame as it's completed. data is only valid
+	// during the call.
+	Frame func(fh *FrameHeader, data []byte)
+
+	pending []byte
+	// Whether the start of the stream has been checked for the
+	// client preface.
+	checked bool
+}
+
+func NewSplitter(f func(fh *FrameHeader, data []byte)) *Splitter {
+	return &Splitter{Frame: f}
+}
+
+// Write skips a leading client preface and passes every complete frame
+// to Frame. It never fails, so a Splitter can be the tap of an
+// io.TeeReader or io.MultiWriter without breaking the connection it's
+// watching.
+func (s *Splitter) Write(p []byte) (int, error) {
+	s.pending = append(s.pending, p...)
+	if !s.checked {
+		n := min(len(s.pending), len(ClientPreface))
+		if !bytes.Equal(s.pending[:n], ClientPreface[:n]) {
+			s.checked = true
+		} else if n == len(ClientPreface) {
+			s.pending = s.pending[n:]
+			s.checked = true
+		} else {
+			return len(p), nil
+		}
+	}
+	for len(s.pending) >= 9 {
+		var fh FrameHeader
+		fh.Unmarshal(bytes.NewReader(s.pending[:9]))
+		n := 9 + int(fh.Length)
+		if len(s.pending) < n {
+			break
+		}
+		s.Frame(&fh, s.pending[9:n])
+		s.pending = s.pending[n:]
+	}
+	// Don't let the buffer keep every frame ever sent alive.
+	if len(s.pending) == 0 {
+		s.pending = nil
+	}
+	return len(p), nil
+}
+
+// HeaderBlockFragment returns the part of a HEADERS payload that
+// carries the header block, without the pad length, priority and
+// padding its PADDED and PRIORITY flags announce (RFC 9113 §6.2). A
+// payload too short for those fields is a PayloadTooShort error, and
+// padding longer than what's left a PaddingTooLong one.
+func HeaderBlockFragment(fh *FrameHeader, data []byte) ([]byte, error) {
+	pad := 0
+	// Padded
+	if fh.Flag(3) {
+		if len(data) < 1 {
+			return nil, PayloadTooShort
+		}
+		pad = int(data[0])
+		data = data[1:]
+	}
+	// Priority; the dependency and weight are ignored.
+	if fh.Flag(5) {
+		if len(data) < 5 {
+			return nil, PayloadTooShort
+		}
+		data = data[5:]
+	}
+	if pad > len(data) {
+		return nil, PaddingTooLong
+	}
+	return data[:len(data)-pad], nil
+}
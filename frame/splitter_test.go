@@ -0,0 +1,58 @@
+package frame
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitter(t *testing.T) {
+	var types []FrameType
+	var payloads []string
+	s := NewSplitter(func(fh *FrameHeader, data []byte) {
+		types = append(types, fh.Type)
+		payloads = append(payloads, string(data))
+	})
+
+	// The server's side has no preface.
+	var wire bytes.Buffer
+	(&FrameHeader{Type: FrameSettings}).Marshal(&wire)
+	(&FrameHeader{Length: 5, Type: FrameData, Flags: 1, Sid: 1}).Marshal(&wire)
+	wire.WriteString("hello")
+	for _, b := range wire.Bytes() {
+		s.Write([]byte{b})
+	}
+	assert.Equal(t, []FrameType{FrameSettings, FrameData}, types)
+	assert.Equal(t, []string{"", "hello"}, payloads)
+}
+
+func TestHeaderBlockFragment(t *testing.T) {
+	const padded, priority = 0x8, 0x20
+	cases := []struct {
+		name  string
+		flags uint8
+		data  string
+		want  string
+		err   error
+	}{
+		{"plain", 0, "\x82\x84", "\x82\x84", nil},
+		{"padded", padded, "\x02\x82\x84\x00\x00", "\x82\x84", nil},
+		{"priority", priority, "\x00\x00\x00\x03\x10\x82", "\x82", nil},
+		{"both", padded | priority, "\x01\x00\x00\x00\x03\x10\x82\x00", "\x82", nil},
+		{"all padding", padded, "\x02\x00\x00", "", nil},
+		{"no pad length", padded, "", "", PayloadTooShort},
+		{"short priority", priority, "\x00\x00\x00\x03", "", PayloadTooShort},
+		{"short priority after pad length", padded | priority, "\x00\x00\x00\x00\x03", "", PayloadTooShort},
+		{"padding too long", padded, "\x03\x82\x00", "", PaddingTooLong},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := HeaderBlockFragment(&FrameHeader{Type: FrameHeaders, Flags: c.flags}, []byte(c.data))
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, c.want, string(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	LateTableSizeUpdate    = errors.New("dynamic table size update after a header field")
+	TableSizeLimitExceeded = errors.New("dynamic table size update exceeds the negotiated limit")
+)
+
+// DecodeBlock decodes a complete header block, calling cb with each
+// header field in the order it appears. Literals are inserted into
+// tbl as they're decoded and size updates are applied to it, so tbl
+// must be the decoding side's table for the whole connection.
+//
+// Every error returned is a *CompressionError.
+func DecodeBlock(tbl *HeaderLookupTable, data []uint8, cb func(k, v string)) error {
+	return DecodeBlockFunc(tbl, data, func(hdr Header, k, v string) {
+		if _, ok := hdr.(TableSizeUpdate); !ok {
+			cb(k, v)
+		}
+	})
+}
+
+// DecodeBlockFunc is like DecodeBlock, but passes cb the representation
+// each field was decoded from. Size updates are passed to cb (with an
+// empty key and value) after they've been applied to tbl.
+func DecodeBlockFunc(tbl *HeaderLookupTable, data []uint8, cb func(hdr Header, k, v string)) error {
+	sawField := false
+	for totRead := 0; totRead < len(data); {
+		hdr, numRead, err := NextHeader(data[totRead:])
+		if err != nil {
+			return asCompressionError(err)
+		}
+		totRead += numRead
+
+		// Size updates must come first in a block (RFC 7541 §4.2).
+		if tsu, ok := hdr.(TableSizeUpdate); ok {
+			if sawField {
+				return &CompressionError{LateTableSizeUpdate}
+			}
+			if int(tsu) > tbl.SizeLimit() {
+				return &CompressionError{fmt.Errorf("%w: %d > %d", TableSizeLimitExceeded, tsu, tbl.SizeLimit())}
+			}
+			tbl.SetMaxSize(int(tsu))
+			cb(tsu, "", "")
+			continue
+		}
+		sawField = true
+
+		k, v, err := hdr.Resolve(tbl)
+		if err != nil {
+			return asCompressionError(err)
+		}
+		switch h := hdr.(type) {
+		case IndexedHeader:
+			tbl.record(k, int(h), matchFull)
+		case *LiteralHeader:
+			if h.KeyLiteral == "" {
+				tbl.record(k, int(h.KeyIndex), matchName)
+			} else {
+				tbl.record(k, 0, matchNone)
+			}
+		}
+		cb(hdr, k, v)
+		if hdr.ShouldIndex() {
+			tbl.Insert(k, v)
+		}
+	}
+	return nil
+}
+
+func asCompressionError(err error) error {
+	var ce *CompressionError
+	if errors.As(err, &ce) {
+		return err
+	}
+	return &CompressionError{err}
+}
@@ -10,8 +10,24 @@ import (
 
 var (
 	LookupIndexOutOfBounds = errors.New("lookup table out-of-bounds")
+	StringTruncated        = errors.New("string literal is longer than the header block")
 )
 
+// A CompressionError indicates that a header block could not be
+// decoded. Connections that receive one must be torn down with
+// a COMPRESSION_ERROR (RFC 7540 §4.3).
+type CompressionError struct {
+	Err error
+}
+
+func (ce *CompressionError) Error() string {
+	return fmt.Sprintf("compression error: %s", ce.Err)
+}
+
+func (ce *CompressionError) Unwrap() error {
+	return ce.Err
+}
+
 // A Header represents the encoded form of an HPACK header block.
 type Header interface {
 	Resolve(*HeaderLookupTable) (string, string, error)
@@ -49,6 +65,29 @@ func (ih IndexedHeader) String() string {
 	return fmt.Sprintf("Header.Indexed[%d]", ih)
 }
 
+// A TableSizeUpdate signals a change to the maximum size of the
+// decoder's dynamic table. It carries no header field, so it
+// resolves to an empty pair.
+type TableSizeUpdate uint32
+
+func (tsu TableSizeUpdate) ShouldIndex() bool {
+	return false
+}
+
+func (tsu TableSizeUpdate) Encode() []uint8 {
+	data := EncodeInteger(uint32(tsu), 5)
+	data[0] |= 0x20
+	return data
+}
+
+func (tsu TableSizeUpdate) Resolve(table *HeaderLookupTable) (string, string, error) {
+	return "", "", nil
+}
+
+func (tsu TableSizeUpdate) String() string {
+	return fmt.Sprintf("Header.TableSizeUpdate(%d)", tsu)
+}
+
 //go:generate stringer -type=LiteralIndexType
 type LiteralIndexType uint8
 
@@ -144,6 +183,9 @@ func (lh *LiteralHeader) String() string {
 // NextHeader tries to extract a header from the start
 // of the given octet buffer.
 func NextHeader(data []uint8) (Header, int, error) {
+	if len(data) == 0 {
+		return nil, 0, &CompressionError{StringTruncated}
+	}
 	c := data[0]
 
 	if c&0b10000000 != 0 {
@@ -157,8 +199,11 @@ func NextHeader(data []uint8) (Header, int, error) {
 	if c&0b01000000 != 0 {
 		return literalHeader(data, IncrementalIndex, 6)
 	} else if c&0b00100000 != 0 {
-		fmt.Println("this is a data increase directive....")
-		return nil, 1, nil
+		n, numRead, err := DecodeInteger(data, 5)
+		if err != nil {
+			return nil, 0, err
+		}
+		return TableSizeUpdate(n), numRead, nil
 	} else if c&0b00010000 != 0 {
 		return literalHeader(data, NeverIndex, 4)
 	} else if c&0b11110000 == 0 {
@@ -9,33 +9,56 @@ import (
 type HeaderList struct {
 	data bytes.Buffer
 	tbl  *HeaderLookupTable
+
+	// Policy chooses how literals are indexed. If nil,
+	// DefaultIndexPolicy is used.
+	Policy func(k, v string) LiteralIndexType
 }
 
+// DefaultIndexPolicy never indexes credentials, skips indexing paths
+// (which are rarely repeated) and incrementally indexes everything else.
+func DefaultIndexPolicy(k, v string) LiteralIndexType {
+	switch k {
+	case "authorization":
+		return NeverIndex
+	case ":path":
+		return NoIndex
+	default:
+		return IncrementalIndex
+	}
+}
+
+// NewHeaderList starts a header block encoded with table. The block
+// begins with any size updates SetPeerLimit left owing.
 func NewHeaderList(table *HeaderLookupTable) *HeaderList {
-	return &HeaderList{
+	hl := &HeaderList{
 		tbl: table,
 	}
+	hl.data.Write(table.sizeUpdates())
+	return hl
 }
 
 func (hl *HeaderList) Put(k, v string) {
 	k = strings.ToLower(k)
-	v = strings.ToLower(v)
 
 	ind, justKey := hl.tbl.Find(k, v)
 	if ind > 0 && !justKey {
+		hl.tbl.record(k, ind, matchFull)
 		hl.data.Write(IndexedHeader(ind).Encode())
 		return
 	}
+	if ind > 0 {
+		hl.tbl.record(k, ind, matchName)
+	} else {
+		hl.tbl.record(k, ind, matchNone)
+	}
 
-	hdr := new(LiteralHeader)
-	switch k {
-	case "authorization":
-		hdr.Type = NeverIndex
-	case ":path":
-		hdr.Type = NoIndex
-	default:
-		hdr.Type = IncrementalIndex
+	policy := hl.Policy
+	if policy == nil {
+		policy = DefaultIndexPolicy
 	}
+	hdr := new(LiteralHeader)
+	hdr.Type = policy(k, v)
 	if ind > 0 {
 		hdr.KeyIndex = uint32(ind)
 	} else {
@@ -43,6 +66,9 @@ func (hl *HeaderList) Put(k, v string) {
 	}
 	hdr.ValueLiteral = v
 	hl.data.Write(hdr.Encode())
+	if hdr.ShouldIndex() {
+		hl.tbl.Insert(k, v)
+	}
 }
 
 func (hl *HeaderList) Dump() []byte {
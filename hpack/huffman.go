@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	_ "embed"
-	"fmt"
+	"errors"
 	"io"
 )
 
@@ -23,6 +23,14 @@ func init() {
 
 const EOS uint16 = 256
 
+// Errors returned by HuffmanTree.Decode when the input violates
+// the padding and EOS rules in RFC 7541 §5.2.
+var (
+	HuffmanEOSDecoded     = errors.New("huffman: EOS symbol in string literal")
+	HuffmanPaddingTooLong = errors.New("huffman: padding longer than 7 bits")
+	HuffmanInvalidPadding = errors.New("huffman: padding is not a prefix of EOS")
+)
+
 type HuffmanTree struct {
 	root    *TrieNode
 	jumpMap [257]*TrieNode
@@ -38,25 +46,46 @@ func (ht *HuffmanTree) Insert(seq []bool, sym uint16) {
 	ht.jumpMap[sym] = ht.root.Insert(seq, sym)
 }
 
-func (ht *HuffmanTree) Decode(input []uint8) []uint8 {
-	var ret []uint8
+// Decode decodes a huffman-coded string literal. Per RFC 7541 §5.2,
+// the string must not contain the EOS symbol, and any trailing bits
+// that don't complete a code must be at most 7 bits long and consist
+// entirely of ones (the most-significant bits of EOS).
+func (ht *HuffmanTree) Decode(input []uint8) ([]uint8, error) {
+	var (
+		ret []uint8
+
+		// Number of bits read since the last complete symbol, and
+		// whether all of them were ones.
+		padBits int
+		padOnes = true
+	)
 	curr := ht.root
 	for byteNo := 0; byteNo < len(input); byteNo++ {
 		for i := 7; i >= 0; i-- {
 			left := (input[byteNo]>>i)&0x1 != 0
+			padBits += 1
+			padOnes = padOnes && left
 			next := curr.Child(left)
 			if next.Left == nil && next.Right == nil {
 				if next.Sym == EOS {
-					fmt.Println("FOUND EOS")
+					return nil, HuffmanEOSDecoded
 				}
 				ret = append(ret, uint8(next.Sym))
 				curr = ht.root
+				padBits = 0
+				padOnes = true
 			} else {
 				curr = next
 			}
 		}
 	}
-	return ret
+	if padBits > 7 {
+		return nil, HuffmanPaddingTooLong
+	}
+	if !padOnes {
+		return nil, HuffmanInvalidPadding
+	}
+	return ret, nil
 }
 
 func (ht *HuffmanTree) Encode(data []uint8) []uint8 {
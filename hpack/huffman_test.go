@@ -0,0 +1,90 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Huffman-coded string literals from RFC 7541 Appendix C.4 and C.6.
+var huffmanGolden = []struct {
+	S   string
+	Hex string
+}{
+	{"www.example.com", "f1e3c2e5f23a6ba0ab90f4ff"},
+	{"no-cache", "a8eb10649cbf"},
+	{"custom-key", "25a849e95ba97d7f"},
+	{"custom-value", "25a849e95bb8e8b4bf"},
+	{"302", "6402"},
+	{"private", "aec3771a4b"},
+	{"Mon, 21 Oct 2013 20:13:21 GMT", "d07abe941054d444a8200595040b8166e082a62d1bff"},
+	{"https://www.example.com", "9d29ad171863c78f0b97c8e9ae82ae43d3"},
+	{"307", "640eff"},
+	{"Mon, 21 Oct 2013 20:13:22 GMT", "d07abe941054d444a8200595040b8166e084a62d1bff"},
+	{"gzip", "9bd9ab"},
+	{
+		"foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1",
+		"94e7821dd7f2e6c7b335dfdfcd5b3960d5af27087f3672c1ab270fb5291f9587316065c003ed4ee5b1063d5007",
+	},
+}
+
+func TestHuffmanEncodeGolden(t *testing.T) {
+	for _, c := range huffmanGolden {
+		t.Run(c.S, func(t *testing.T) {
+			assert.Equal(t, c.Hex, hex.EncodeToString(HpackHuffmanTree.Encode([]byte(c.S))))
+		})
+	}
+}
+
+func TestHuffmanDecodeGolden(t *testing.T) {
+	for _, c := range huffmanGolden {
+		t.Run(c.S, func(t *testing.T) {
+			data, err := hex.DecodeString(c.Hex)
+			assert.NoError(t, err)
+			decoded, err := HpackHuffmanTree.Decode(data)
+			assert.NoError(t, err)
+			assert.Equal(t, c.S, string(decoded))
+		})
+	}
+}
+
+func TestHuffmanDecode_Error(t *testing.T) {
+	cases := []struct {
+		Name string
+		D    string
+		E    error
+	}{
+		// 30 bits of EOS followed by 2 bits of padding
+		{"EOS", "\xff\xff\xff\xff", HuffmanEOSDecoded},
+		// 'a' (00011) followed by 11 bits of ones
+		{"PaddingTooLong", "\x1f\xff", HuffmanPaddingTooLong},
+		// 'a' (00011) followed by 000
+		{"PaddingNotOnes", "\x18", HuffmanInvalidPadding},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := HpackHuffmanTree.Decode([]byte(c.D))
+			assert.ErrorIs(t, err, c.E)
+		})
+	}
+}
+
+func TestDecodeString_CompressionError(t *testing.T) {
+	cases := []struct {
+		Name string
+		D    string
+		E    error
+	}{
+		{"BadPadding", "\x81\x18", HuffmanInvalidPadding},
+		{"Truncated", "\x05ab", StringTruncated},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, _, err := DecodeString([]byte(c.D))
+			var ce *CompressionError
+			assert.ErrorAs(t, err, &ce)
+			assert.ErrorIs(t, err, c.E)
+		})
+	}
+}
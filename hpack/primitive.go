@@ -2,6 +2,12 @@ package hpack
 
 import (
 	"errors"
+	"math"
+)
+
+var (
+	IntegerTruncated = errors.New("integer is longer than the available data")
+	IntegerOverflow  = errors.New("integer does not fit in 32 bits")
 )
 
 func oneMask(n int) uint8 {
@@ -26,6 +32,9 @@ func oneMask(n int) uint8 {
 // start midway through an octet, leaving room for any flags or prefixes
 // that
 func DecodeInteger(data []uint8, prefixLength int) (uint32, int, error) {
+	if len(data) == 0 {
+		return 0, 0, IntegerTruncated
+	}
 	prefixMask := oneMask(prefixLength)
 	prefix := data[0] & prefixMask
 
@@ -33,11 +42,14 @@ func DecodeInteger(data []uint8, prefixLength int) (uint32, int, error) {
 		return uint32(prefix), 1, nil
 	}
 
-	var ret uint32
+	var ret uint64
 	var shift int
 	i := 1
 	for i < len(data) {
-		ret |= uint32(data[i]&0x7f) << shift
+		if shift > 28 {
+			return 0, 0, IntegerOverflow
+		}
+		ret |= uint64(data[i]&0x7f) << shift
 		if data[i]&0x80 == 0 {
 			break
 		}
@@ -45,22 +57,31 @@ func DecodeInteger(data []uint8, prefixLength int) (uint32, int, error) {
 		i++
 	}
 	if i == len(data) {
-		return 0, 0, errors.New("invalid hpack integer")
+		return 0, 0, IntegerTruncated
 	}
-	return ret + uint32(prefix), i + 1, nil
+	ret += uint64(prefix)
+	if ret > math.MaxUint32 {
+		return 0, 0, IntegerOverflow
+	}
+	return uint32(ret), i + 1, nil
 }
 
 func EncodeInteger(n uint32, prefixLength int) []byte {
 	prefixMask := oneMask(prefixLength)
-	if n <= uint32(prefixMask) {
+	// A value that fills the prefix still needs a continuation octet,
+	// or it reads as the start of a longer integer.
+	if n < uint32(prefixMask) {
 		return []byte{uint8(n)}
 	}
 
 	ret := append(make([]byte, 0, 5), prefixMask)
 	rest := n - uint32(prefixMask)
-	for rest > 0 {
+	for {
 		ret = append(ret, (uint8(rest)&0x7f)|0x80)
 		rest >>= 7
+		if rest == 0 {
+			break
+		}
 	}
 	// Set the last bit to signify the end of the integer
 	ret[len(ret)-1] &= 0x7F
@@ -73,21 +94,46 @@ func EncodeInteger(n uint32, prefixLength int) []byte {
 // The string data follows right after the HPACK integer.
 // If the MSB of the first octet is 1, the string is huffman
 // coded with the canonical huffman code given in RFC 7541.
+//
+// Malformed huffman data is reported as a *CompressionError.
 func DecodeString(data []uint8) ([]byte, int, error) {
-	isHuffmanEncoded := data[0]&0x80 != 0
+	return DecodeStringPrefix(data, 7)
+}
+
+// DecodeStringPrefix decodes a string whose length is an integer with
+// the given prefix length, with the huffman flag in the bit just above
+// the prefix. QPACK uses prefixes shorter than 7 for string literals
+// that share an octet with other flags.
+func DecodeStringPrefix(data []uint8, prefixLength int) ([]byte, int, error) {
+	if len(data) == 0 {
+		return nil, 0, &CompressionError{StringTruncated}
+	}
+	isHuffmanEncoded := data[0]&(1<<prefixLength) != 0
 
-	dataLength, numRead, err := DecodeInteger(data, 7)
+	dataLength, numRead, err := DecodeInteger(data, prefixLength)
 	if err != nil {
 		return nil, 0, err
 	}
+	if uint64(numRead)+uint64(dataLength) > uint64(len(data)) {
+		return nil, 0, &CompressionError{StringTruncated}
+	}
 	stringData := data[numRead : numRead+int(dataLength)]
 	if isHuffmanEncoded {
-		stringData = HpackHuffmanTree.Decode(stringData)
+		stringData, err = HpackHuffmanTree.Decode(stringData)
+		if err != nil {
+			return nil, 0, &CompressionError{err}
+		}
 	}
 	return stringData, int(dataLength) + numRead, nil
 }
 
 func EncodeString(data []byte) []byte {
+	return EncodeStringPrefix(data, 7)
+}
+
+// EncodeStringPrefix is the inverse of DecodeStringPrefix. Bits of the
+// first octet above the huffman flag are left unset for the caller.
+func EncodeStringPrefix(data []byte, prefixLength int) []byte {
 	huffEncoded := HpackHuffmanTree.Encode(data)
 	shouldUseHuffman := len(huffEncoded) < len(data)
 
@@ -96,9 +142,9 @@ func EncodeString(data []byte) []byte {
 		payloadToEncode = huffEncoded
 	}
 
-	lenEncoded := EncodeInteger(uint32(len(payloadToEncode)), 7)
+	lenEncoded := EncodeInteger(uint32(len(payloadToEncode)), prefixLength)
 	if shouldUseHuffman {
-		lenEncoded[0] |= 0x80
+		lenEncoded[0] |= 1 << prefixLength
 	}
 	ret := make([]uint8, len(lenEncoded)+len(payloadToEncode))
 	n := copy(ret, lenEncoded)
@@ -34,7 +34,10 @@ func TestDecodeInteger(t *testing.T) {
 
 func TestDecodeInteger_Error(t *testing.T) {
 	_, _, err := DecodeInteger([]byte("\x07\x83"), 3)
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, IntegerTruncated)
+
+	_, _, err = DecodeInteger([]byte("\x7f\xff\xff\xff\xff\x7f"), 7)
+	assert.ErrorIs(t, err, IntegerOverflow)
 }
 
 func TestEncodeInteger(t *testing.T) {
@@ -43,10 +46,12 @@ func TestEncodeInteger(t *testing.T) {
 		P int
 		E string
 	}{
-		{127, 7, "\x7f"},
+		{126, 7, "\x7e"},
+		{127, 7, "\x7f\x00"},
+		{63, 6, "\x3f\x00"},
 		{127, 5, "\x1f\x60"},
 		{0xffffffff, 7, "\x7f\x80\xff\xff\xff\x0f"},
-		{255, 8, "\xff"},
+		{255, 8, "\xff\x00"},
 	}
 	for _, c := range cases {
 		t.Run(fmt.Sprintf("%d,%d", c.N, c.P), func(t *testing.T) {
@@ -72,6 +77,18 @@ func TestEncodeStringHuffman(t *testing.T) {
 	assert.Equal(t, exp, string(encoded))
 }
 
+func TestStringPrefixRoundTrip(t *testing.T) {
+	for _, p := range []int{3, 5, 7} {
+		for _, s := range []string{"", "custom-key", "\x00\x01binary"} {
+			encoded := EncodeStringPrefix([]byte(s), p)
+			decoded, n, err := DecodeStringPrefix(encoded, p)
+			assert.NoError(t, err)
+			assert.Equal(t, len(encoded), n)
+			assert.Equal(t, s, string(decoded))
+		}
+	}
+}
+
 func TestDecodeStringNoHuffman(t *testing.T) {
 	cases := []struct {
 		Name     string
@@ -0,0 +1,115 @@
+package hpack
+
+type fieldMatch uint8
+
+const (
+	matchNone fieldMatch = iota
+	matchName
+	matchFull
+)
+
+// The peer picks the names a decoding table sees, so only the first
+// MaxTrackedNames get stats of their own. The rest are counted
+// together under OtherNames, which isn't a valid field name.
+const (
+	MaxTrackedNames = 256
+	OtherNames      = "(other)"
+)
+
+// NameStats counts how often fields with a given name were found
+// in a lookup table when they were encoded or decoded.
+type NameStats struct {
+	// Number of fields coded with this name.
+	Fields int
+
+	// Fields coded as an index into the table, and the subset of
+	// those that referenced the dynamic table.
+	Hits        int
+	DynamicHits int
+
+	// Fields coded as a literal value with an indexed name.
+	NameHits int
+}
+
+// HitRate is the fraction of fields that were fully indexed.
+func (ns NameStats) HitRate() float64 {
+	if ns.Fields == 0 {
+		return 0
+	}
+	return float64(ns.Hits) / float64(ns.Fields)
+}
+
+type EntrySnapshot struct {
+	// The HPACK index of the entry. Dynamic entries start
+	// right after the static table.
+	Index int
+	Key   string
+	Value string
+	Size  int
+}
+
+// A TableSnapshot is a point-in-time copy of a lookup table's
+// dynamic entries and usage counters.
+type TableSnapshot struct {
+	// Dynamic table entries, most recently inserted first.
+	Entries []EntrySnapshot
+
+	Size      int
+	MaxSize   int
+	SizeLimit int
+
+	Insertions int
+	Evictions  int
+
+	Names map[string]NameStats
+}
+
+// Snapshot copies the table's dynamic entries and counters.
+func (dt *HeaderLookupTable) Snapshot() TableSnapshot {
+	ret := TableSnapshot{
+		Entries:    make([]EntrySnapshot, 0, dt.numEntries),
+		Size:       dt.size,
+		MaxSize:    dt.maxSize,
+		SizeLimit:  dt.sizeLimit,
+		Insertions: dt.insertions,
+		Evictions:  dt.evictions,
+		Names:      make(map[string]NameStats, len(dt.names)),
+	}
+	for i := 0; i < dt.numEntries; i++ {
+		ind := len(StaticTable) + 1 + i
+		k, v, _ := dt.Lookup(ind)
+		ret.Entries = append(ret.Entries, EntrySnapshot{
+			Index: ind,
+			Key:   k,
+			Value: v,
+			Size:  TableEntry{k, v}.Size(),
+		})
+	}
+	for k, ns := range dt.names {
+		ret.Names[k] = ns
+	}
+	return ret
+}
+
+// record counts a field coded against this table. idx is the
+// table index used to code it, if any.
+func (dt *HeaderLookupTable) record(k string, idx int, match fieldMatch) {
+	if dt.names == nil {
+		dt.names = make(map[string]NameStats)
+	}
+	if _, ok := dt.names[k]; !ok && len(dt.names) >= MaxTrackedNames {
+		k = OtherNames
+	}
+	ns := dt.names[k]
+	ns.Fields += 1
+	switch match {
+	case matchFull:
+		ns.Hits += 1
+		if idx > len(StaticTable) {
+			ns.DynamicHits += 1
+		}
+	case matchName:
+		ns.NameHits += 1
+	}
+	dt.names[k] = ns
+}
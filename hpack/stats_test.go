@@ -0,0 +1,61 @@
+package hpack
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotEncoder(t *testing.T) {
+	tbl := NewHeaderLookupTable()
+	for range 2 {
+		hl := NewHeaderList(tbl)
+		hl.Put(":method", "GET")
+		hl.Put("user-agent", "test")
+		hl.Dump()
+	}
+
+	snap := tbl.Snapshot()
+	assert.Equal(t, 1, snap.Insertions)
+	assert.Equal(t, 0, snap.Evictions)
+	assert.Equal(t, []EntrySnapshot{{62, "user-agent", "test", 46}}, snap.Entries)
+	assert.Equal(t, 46, snap.Size)
+	assert.Equal(t, DefaultTableSize, snap.MaxSize)
+
+	assert.Equal(t, NameStats{Fields: 2, Hits: 2}, snap.Names[":method"])
+	assert.Equal(t, NameStats{Fields: 2, Hits: 1, DynamicHits: 1, NameHits: 1}, snap.Names["user-agent"])
+	assert.Equal(t, 0.5, snap.Names["user-agent"].HitRate())
+}
+
+func TestSnapshotDecoder(t *testing.T) {
+	tbl := NewHeaderLookupTable()
+	tbl.SetMaxSize(100)
+
+	// Two literals with new names; the second evicts the first.
+	hl := NewHeaderList(NewHeaderLookupTable())
+	hl.Put("x-first", "1234567890123456789012345")
+	hl.Put("x-second", "1234567890123456789012345")
+	assert.NoError(t, DecodeBlock(tbl, hl.Dump(), func(k, v string) {}))
+
+	snap := tbl.Snapshot()
+	assert.Equal(t, 2, snap.Insertions)
+	assert.Equal(t, 1, snap.Evictions)
+	assert.Len(t, snap.Entries, 1)
+	assert.Equal(t, "x-second", snap.Entries[0].Key)
+	assert.Equal(t, NameStats{Fields: 1}, snap.Names["x-first"])
+}
+
+func TestSnapshotNamesBounded(t *testing.T) {
+	tbl := NewHeaderLookupTable()
+	for i := range MaxTrackedNames + 10 {
+		hl := NewHeaderList(NewHeaderLookupTable())
+		hl.Put(fmt.Sprintf("x-name-%d", i), "v")
+		assert.NoError(t, DecodeBlock(tbl, hl.Dump(), func(k, v string) {}))
+	}
+
+	snap := tbl.Snapshot()
+	assert.Len(t, snap.Names, MaxTrackedNames+1)
+	assert.Equal(t, NameStats{Fields: 1}, snap.Names["x-name-0"])
+	assert.Equal(t, 10, snap.Names[OtherNames].Fields)
+}
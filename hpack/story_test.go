@@ -0,0 +1,188 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Stories use the hpack-test-case JSON format
+// (https://github.com/http2jp/hpack-test-case). Each directory under
+// testdata holds the stories produced by one encoder, and every one of
+// them is run. Stories without a "wire" field (like raw-data) are only
+// used for the encoder round-trip.
+//
+// rfc7541 holds the examples from RFC 7541 Appendix C. raw-data holds
+// browser-like request and response header sets, and the other
+// directories are those sets as encoded by nghttp2 (with and without
+// table size changes between blocks), Go's x/net hpack package and
+// python-hpack; each story's description names the version.
+var corpora = []string{
+	"rfc7541",
+	"raw-data",
+	"nghttp2",
+	"nghttp2-change-table-size",
+	"go-hpack",
+	"python-hpack",
+}
+
+type story struct {
+	Description string      `json:"description"`
+	Cases       []storyCase `json:"cases"`
+}
+
+type storyCase struct {
+	Seqno           int                 `json:"seqno"`
+	HeaderTableSize int                 `json:"header_table_size"`
+	Wire            string              `json:"wire"`
+	Headers         []map[string]string `json:"headers"`
+}
+
+type headerPair struct {
+	K, V string
+}
+
+func (sc *storyCase) pairs() []headerPair {
+	var ret []headerPair
+	for _, h := range sc.Headers {
+		for k, v := range h {
+			ret = append(ret, headerPair{k, v})
+		}
+	}
+	return ret
+}
+
+// loadStories reads the stories of every directory under testdata, by
+// directory and then by file.
+func loadStories(t *testing.T) map[string]map[string]*story {
+	dirs, err := os.ReadDir("testdata")
+	require.NoError(t, err)
+
+	ret := make(map[string]map[string]*story)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		paths, err := filepath.Glob(filepath.Join("testdata", d.Name(), "story_*.json"))
+		require.NoError(t, err)
+		require.NotEmpty(t, paths, d.Name())
+		ret[d.Name()] = make(map[string]*story)
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			require.NoError(t, err)
+			var st story
+			require.NoError(t, json.Unmarshal(data, &st), p)
+			ret[d.Name()][filepath.Base(p)] = &st
+		}
+	}
+	for _, c := range corpora {
+		require.Contains(t, ret, c, "missing corpus")
+	}
+	return ret
+}
+
+func decodeAll(tbl *HeaderLookupTable, data []byte) ([]headerPair, error) {
+	var ret []headerPair
+	err := DecodeBlock(tbl, data, func(k, v string) {
+		ret = append(ret, headerPair{k, v})
+	})
+	return ret, err
+}
+
+func TestStoriesDecode(t *testing.T) {
+	for dir, stories := range loadStories(t) {
+		t.Run(dir, func(t *testing.T) {
+			for name, st := range stories {
+				t.Run(name, func(t *testing.T) {
+					testStoryDecode(t, st)
+				})
+			}
+		})
+	}
+}
+
+func testStoryDecode(t *testing.T, st *story) {
+	tbl := NewHeaderLookupTable()
+	for _, c := range st.Cases {
+		if c.Wire == "" {
+			t.Skip("story has no wire data")
+		}
+		if c.HeaderTableSize > 0 {
+			tbl.SetSizeLimit(c.HeaderTableSize)
+			tbl.SetMaxSize(c.HeaderTableSize)
+		}
+		wire, err := hex.DecodeString(c.Wire)
+		require.NoError(t, err)
+
+		decoded, err := decodeAll(tbl, wire)
+		require.NoError(t, err, "seqno %d", c.Seqno)
+		assert.Equal(t, c.pairs(), decoded, "seqno %d", c.Seqno)
+	}
+}
+
+func TestStoriesRoundTrip(t *testing.T) {
+	for dir, stories := range loadStories(t) {
+		t.Run(dir, func(t *testing.T) {
+			for name, st := range stories {
+				t.Run(name, func(t *testing.T) {
+					testStoryRoundTrip(t, st)
+				})
+			}
+		})
+	}
+}
+
+func testStoryRoundTrip(t *testing.T, st *story) {
+	encTbl := NewHeaderLookupTable()
+	decTbl := NewHeaderLookupTable()
+	for _, c := range st.Cases {
+		hl := NewHeaderList(encTbl)
+		for _, p := range c.pairs() {
+			hl.Put(p.K, p.V)
+		}
+		decoded, err := decodeAll(decTbl, hl.Dump())
+		require.NoError(t, err, "seqno %d", c.Seqno)
+		assert.Equal(t, c.pairs(), decoded, "seqno %d", c.Seqno)
+	}
+}
+
+func TestDecodeBlock_TableSizeUpdate(t *testing.T) {
+	tbl := NewHeaderLookupTable()
+	tbl.Insert("custom-key", "custom-value")
+
+	// Shrinking the table to zero evicts everything.
+	_, err := decodeAll(tbl, []byte("\x20\x82"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tbl.MaxSize())
+	assert.Equal(t, len(StaticTable), tbl.NumEntries())
+
+	_, err = decodeAll(tbl, []byte("\x82\x20"))
+	assert.ErrorIs(t, err, LateTableSizeUpdate)
+
+	// 4097 is larger than the default limit.
+	_, err = decodeAll(tbl, TableSizeUpdate(4097).Encode())
+	assert.ErrorIs(t, err, TableSizeLimitExceeded)
+}
+
+func TestDecodeBlock_CompressionError(t *testing.T) {
+	cases := []struct {
+		Name string
+		D    string
+	}{
+		{"IndexZero", "\x80"},
+		{"IndexOutOfBounds", "\xbf"},
+		{"TruncatedLiteral", "\x40\x05ab"},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := decodeAll(NewHeaderLookupTable(), []byte(c.D))
+			var ce *CompressionError
+			assert.ErrorAs(t, err, &ce)
+		})
+	}
+}
@@ -14,12 +14,30 @@ func (te TableEntry) Size() int {
 	return 32 + len(te.Key) + len(te.Value)
 }
 
+// DefaultTableSize is the initial value of SETTINGS_HEADER_TABLE_SIZE,
+// and the maximum size a table starts with.
+const DefaultTableSize = 4096
+
 type HeaderLookupTable struct {
 	entries    []TableEntry
 	lo         int
 	numEntries int
 	size       int
 	maxSize    int
+
+	// The largest maxSize a peer may request with a
+	// TableSizeUpdate, i.e. our SETTINGS_HEADER_TABLE_SIZE.
+	sizeLimit int
+
+	// For encoding: whether the peer's decoder is owed a size
+	// update, and the smallest maxSize since the last one.
+	updatePending bool
+	updateMin     int
+
+	// Usage counters reported by Snapshot.
+	insertions int
+	evictions  int
+	names      map[string]NameStats
 }
 
 func NewHeaderLookupTable() *HeaderLookupTable {
@@ -31,8 +49,9 @@ func NewHeaderLookupTable() *HeaderLookupTable {
 		numEntries: 0,
 
 		// Size in octets of this table
-		size:    0,
-		maxSize: 16536,
+		size:      0,
+		maxSize:   DefaultTableSize,
+		sizeLimit: DefaultTableSize,
 	}
 }
 
@@ -40,15 +59,55 @@ func (dt *HeaderLookupTable) NumEntries() int {
 	return len(StaticTable) + dt.numEntries
 }
 
+func (dt *HeaderLookupTable) MaxSize() int {
+	return dt.maxSize
+}
+
+func (dt *HeaderLookupTable) SizeLimit() int {
+	return dt.sizeLimit
+}
+
+func (dt *HeaderLookupTable) SetSizeLimit(limit int) {
+	dt.sizeLimit = limit
+}
+
 func (dt *HeaderLookupTable) SetMaxSize(ms int) {
-	if ms > dt.maxSize {
-		dt.maxSize = ms
-		return
-	}
+	dt.maxSize = ms
 	for dt.size > dt.maxSize {
 		dt.Evict()
 	}
-	dt.maxSize = ms
+}
+
+// SetPeerLimit applies the peer's SETTINGS_HEADER_TABLE_SIZE to a
+// table used for encoding. The table never grows past
+// DefaultTableSize, but shrinks to fit a smaller limit, and the next
+// HeaderList made on it starts with the size updates the peer's
+// decoder needs (RFC 7541 §4.2).
+func (dt *HeaderLookupTable) SetPeerLimit(limit int) {
+	size := min(limit, DefaultTableSize)
+	if size == dt.maxSize {
+		return
+	}
+	if !dt.updatePending || size < dt.updateMin {
+		dt.updateMin = size
+	}
+	dt.updatePending = true
+	dt.SetMaxSize(size)
+}
+
+// sizeUpdates returns the size updates owed to the peer's decoder,
+// if any: the smallest size the table had, if it grew back since,
+// then its current size.
+func (dt *HeaderLookupTable) sizeUpdates() []byte {
+	if !dt.updatePending {
+		return nil
+	}
+	dt.updatePending = false
+	var ret []byte
+	if dt.updateMin < dt.maxSize {
+		ret = append(ret, TableSizeUpdate(dt.updateMin).Encode()...)
+	}
+	return append(ret, TableSizeUpdate(dt.maxSize).Encode()...)
 }
 
 func (dt *HeaderLookupTable) ForEach(f func(TableEntry)) {
@@ -75,6 +134,7 @@ func (dt *HeaderLookupTable) Evict() (string, string, bool) {
 	ret := dt.entries[dt.lo]
 	dt.size -= ret.Size()
 	dt.numEntries -= 1
+	dt.evictions += 1
 	dt.lo = dt.Nth(1)
 	return ret.Key, ret.Value, true
 }
@@ -82,7 +142,11 @@ func (dt *HeaderLookupTable) Evict() (string, string, bool) {
 func (dt *HeaderLookupTable) Insert(key, value string) bool {
 	te := TableEntry{key, value}
 	s := te.Size()
+	// An entry larger than the table empties it (RFC 7541 §4.4).
 	if s > dt.maxSize {
+		for dt.numEntries > 0 {
+			dt.Evict()
+		}
 		return false
 	}
 	for dt.size+s > dt.maxSize {
@@ -94,6 +158,7 @@ func (dt *HeaderLookupTable) Insert(key, value string) bool {
 	dt.entries[dt.NextOpen()] = te
 	dt.size += s
 	dt.numEntries += 1
+	dt.insertions += 1
 	return true
 }
 
@@ -121,14 +186,19 @@ func (dt *HeaderLookupTable) NextOpen() int {
 
 func (dt *HeaderLookupTable) Lookup(ind int) (string, string, bool) {
 	ind -= 1
+	if ind < 0 {
+		return "", "", false
+	}
 	if ind < len(StaticTable) {
 		te := StaticTable[ind]
 		return te.Key, te.Value, true
 	}
 	ind -= len(StaticTable)
 
+	// Dynamic table indices count from the most recently
+	// inserted entry.
 	if ind < dt.numEntries {
-		te := dt.entries[dt.Nth(ind)]
+		te := dt.entries[dt.Nth(dt.numEntries-1-ind)]
 		return te.Key, te.Value, true
 	}
 	return "", "", false
@@ -138,10 +208,12 @@ func (dt *HeaderLookupTable) String() string {
 	var sb strings.Builder
 
 	fmt.Fprintln(&sb, "----- Dynamic Table -----")
-	dt.ForEach(func(te TableEntry) {
-		fmt.Fprintf(&sb, "[s = %4d] %s: %v\n", te.Size(), te.Key, te.Value)
-	})
-	fmt.Fprintf(&sb, "Table Size: %d\n", dt.size)
+	for i := 0; i < dt.numEntries; i++ {
+		ind := len(StaticTable) + 1 + i
+		te := dt.entries[dt.Nth(dt.numEntries-1-i)]
+		fmt.Fprintf(&sb, "[%3d] [s = %4d] %s: %v\n", ind, te.Size(), te.Key, te.Value)
+	}
+	fmt.Fprintf(&sb, "Table Size: %d/%d\n", dt.size, dt.maxSize)
 	return sb.String()
 }
 
@@ -0,0 +1,45 @@
+package hpack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetPeerLimit(t *testing.T) {
+	enc, dec := NewHeaderLookupTable(), NewHeaderLookupTable()
+	roundTrip := func(k, v string) []byte {
+		hl := NewHeaderList(enc)
+		hl.Put(k, v)
+		block := hl.Dump()
+		var got []string
+		require.NoError(t, DecodeBlock(dec, block, func(k, v string) {
+			got = append(got, k+": "+v)
+		}))
+		assert.Equal(t, []string{k + ": " + v}, got)
+		return block
+	}
+
+	roundTrip("x-a", "1")
+	assert.Equal(t, 1, enc.NumEntries()-len(StaticTable))
+
+	// A peer with no room empties the table, and hears about it.
+	enc.SetPeerLimit(0)
+	assert.Equal(t, 0, enc.MaxSize())
+	block := roundTrip("x-a", "1")
+	assert.Equal(t, byte(0x20), block[0])
+	assert.Equal(t, 0, dec.MaxSize())
+	// Only once.
+	assert.NotEqual(t, byte(0x20), roundTrip("x-b", "2")[0])
+
+	// Shrinking then growing between blocks sends both sizes.
+	enc.SetPeerLimit(100)
+	enc.SetPeerLimit(50)
+	enc.SetPeerLimit(8192)
+	block = roundTrip("x-c", "3")
+	want := append(TableSizeUpdate(50).Encode(), TableSizeUpdate(DefaultTableSize).Encode()...)
+	assert.Equal(t, want, block[:len(want)])
+	assert.Equal(t, DefaultTableSize, enc.MaxSize())
+	assert.Equal(t, DefaultTableSize, dec.MaxSize())
+}
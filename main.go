@@ -2,60 +2,105 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"http2/frame"
+	"http2/fileserver"
+	"http2/middleware"
+	"http2/pkg/pcapng"
+	"http2/router"
 	"http2/session"
-	"net"
+	"http2/sse"
+	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
 )
 
-func Must[T any](v T, err error) T {
+func serverMain(bindAddr string, useTLS bool, staticDir string, tracer session.Tracer, pcapFile string) {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	handler := middleware.Chain(
+		middleware.Recover(logger),
+		middleware.RequestID(),
+		middleware.Logger(logger),
+		middleware.CORS(middleware.CORSOptions{
+			AllowedOrigins: []string{"*"},
+			ExposedHeaders: []string{"Content-Type"},
+		}),
+		middleware.Compress(middleware.CompressOptions{}),
+	)(Routes(staticDir))
+
+	srv := &session.Server{
+		Addr:             bindAddr,
+		Handler:          handler,
+		HandshakeTimeout: 10 * time.Second,
+		IdleTimeout:      5 * time.Minute,
+		ErrorLog:         logger,
+		Tracer:           tracer,
+	}
+	if pcapFile != "" {
+		f, err := os.Create(pcapFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		srv.Pcap = pcapng.NewWriter(f)
+		defer srv.Pcap.Close()
+	}
+	// Let Wireshark decrypt a capture of the real traffic too.
+	keyLog, err := pcapng.OpenKeyLog()
 	if err != nil {
-		panic(err)
+		logger.Fatal(err)
+	}
+	if keyLog != nil {
+		defer keyLog.Close()
+		srv.TLSConfig = &tls.Config{KeyLogWriter: keyLog}
 	}
-	return v
-}
 
-func TLSListener(bindAddr string) net.Listener {
-	cert := Must(tls.LoadX509KeyPair("certs/cert.pem", "certs/key.pem"))
-	var cfg tls.Config
-	cfg.Certificates = append(cfg.Certificates, cert)
-	cfg.NextProtos = append(cfg.NextProtos, "h2")
-	return Must(tls.Listen("tcp", bindAddr, &cfg))
-}
+	// Finish the requests in flight on ^C. The listener closes
+	// first, so serving returns before Shutdown does; stopped says
+	// when it's safe to exit.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}()
 
-func serverMain(bindAddr string, tls bool) {
-	var listener net.Listener
-	if tls {
-		listener = TLSListener(bindAddr)
+	if useTLS {
 		fmt.Printf("server available at https://%s\n", bindAddr)
+		err = srv.ListenAndServeTLS("certs/cert.pem", "certs/key.pem")
 	} else {
-		panic("non-tls not implemented")
+		fmt.Printf("server available at http://%s (h2c prior knowledge)\n", bindAddr)
+		err = srv.ListenAndServe()
 	}
-
-	for {
-		conn := Must(listener.Accept())
-		fmt.Println("\x1b[31mNEW CONNECTION\x1b[0m")
-		ctx := session.NewConnectionContext(conn, conn, session.FuncHandler(Handle))
-		ctx.Handler = session.FuncHandler(Handle)
-		srv := session.NewDispatcher(ctx, frame.NewFramer(conn))
-		go srv.Serve()
+	if !errors.Is(err, session.ServerClosed) {
+		logger.Fatal(err)
 	}
+	<-stopped
 }
 
-func Handle(req *session.Request, resp *session.Response) {
-	pth := req.GetHeader(":path")
-	switch pth {
-	case "/":
-		Index(resp)
-	case "/events":
-		Events(resp)
-	default:
-		resp.SetResponseCode(session.NotFound)
+func Routes(staticDir string) *router.Router {
+	r := router.New()
+	if staticDir != "" {
+		fsrv := fileserver.New(os.DirFS(staticDir))
+		fsrv.StripPrefix = "/static"
+		r.Mount("/static", fsrv)
 	}
+	r.Get("/", func(req *session.Request, resp *session.Response) {
+		Index(resp)
+	})
+	r.Get("/events", Events)
+	return r
 }
 
 func Index(resp *session.Response) {
@@ -83,32 +128,54 @@ func Index(resp *session.Response) {
 	wr.Flush()
 }
 
-func Events(resp *session.Response) {
-	resp.SetHeader("Access-Control-Allow-Origin", "*")
-	resp.SetHeader("Access-Control-Expose-Headers", "Content-Type")
-
-	resp.SetHeader("Content-Type", "text/event-stream")
-	resp.SetHeader("Cache-Control", "no-cache")
-	resp.SetHeader("Connection", "keep-alive")
-
-	sc := bufio.NewScanner(os.Stdin)
+func Events(req *session.Request, resp *session.Response) {
+	w, err := sse.NewEventWriter(req, resp)
+	if err != nil {
+		return
+	}
 
-	fmt.Print("> ")
-	for sc.Scan() {
-		t := strings.TrimSpace(sc.Text())
-		if t == "q" {
-			break
-		}
-		fmt.Fprintf(resp, "data: %s\n\n", t)
-		resp.Flush()
+	// Each line typed on stdin is sent as an event.
+	events := make(chan sse.Event)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(os.Stdin)
 		fmt.Print("> ")
-	}
+		for sc.Scan() {
+			t := strings.TrimSpace(sc.Text())
+			if t == "q" {
+				return
+			}
+			select {
+			case events <- sse.Event{Data: t}:
+			case <-w.Done():
+				return
+			}
+			fmt.Print("> ")
+		}
+	}()
+	w.Run(events, 15*time.Second)
 }
 
 func main() {
 	useTLS := flag.Bool("tls", true, "whether or not to use tls")
 	bind := flag.String("bind", ":8000", "host:port authority to listen on")
+	static := flag.String("static", "", "directory to serve under /static/")
+	trace := flag.String("trace", "", "trace connections: \"console\" for coloured frame dumps, \"slog\" for debug logs")
+	pcapFile := flag.String("pcap", "", "write the plaintext of every connection to this pcapng file")
 	flag.Parse()
 
-	serverMain(*bind, *useTLS)
+	var tracer session.Tracer
+	switch *trace {
+	case "":
+	case "console":
+		tracer = session.NewConsoleTracer(os.Stdout)
+	case "slog":
+		h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		tracer = session.NewSlogTracer(slog.New(h))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -trace %q\n", *trace)
+		os.Exit(2)
+	}
+
+	serverMain(*bind, *useTLS, *static, tracer, *pcapFile)
 }
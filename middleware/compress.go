@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"compress/flate"
+	"strconv"
+	"strings"
+
+	"http2/session"
+)
+
+// NoCompression is the Level that stores bodies in the chosen
+// content-coding without compressing them. flate.NoCompression
+// can't be used, being the zero value that selects the default.
+const NoCompression = -100
+
+type CompressOptions struct {
+	// compress/flate level, or NoCompression. Defaults to
+	// flate.DefaultCompression.
+	Level int
+
+	// Bodies shorter than this are sent uncompressed.
+	// Defaults to 1024 octets.
+	MinSize int
+}
+
+// Encodings we can produce, in order of preference when the
+// client likes several equally.
+var supportedEncodings = []string{"gzip", "deflate"}
+
+// Compress compresses response bodies with gzip or deflate, whichever
+// the client's accept-encoding prefers. Clients that only accept
+// encodings we can't produce, like br, fall back to gzip if they
+// accept it at all.
+func Compress(opts CompressOptions) Middleware {
+	switch opts.Level {
+	case 0:
+		opts.Level = flate.DefaultCompression
+	case NoCompression:
+		opts.Level = flate.NoCompression
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = 1024
+	}
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			res.SetHeader("vary", "accept-encoding")
+			if enc := negotiateEncoding(req.Header().Values("accept-encoding")); enc != "" {
+				res.EnableCompression(enc, opts.Level, opts.MinSize)
+			}
+			next.Handle(req, res)
+		})
+	}
+}
+
+// negotiateEncoding picks the supported content-coding with the
+// highest q-value (RFC 9110 §12.5.3), or "" to send the body as is.
+func negotiateEncoding(values []string) string {
+	q := make(map[string]float64)
+	wildcard := -1.0
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "x-gzip" {
+				name = "gzip"
+			}
+			weight := 1.0
+			for _, p := range strings.Split(params, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(p), "=")
+				if ok && strings.EqualFold(k, "q") {
+					if f, err := strconv.ParseFloat(val, 64); err == nil && f >= 0 && f <= 1 {
+						weight = f
+					} else {
+						weight = 0
+					}
+				}
+			}
+			if name == "*" {
+				wildcard = weight
+			} else if name != "" {
+				q[name] = weight
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supportedEncodings {
+		w, ok := q[enc]
+		if !ok {
+			w = wildcard
+		}
+		if w > bestQ {
+			best, bestQ = enc, w
+		}
+	}
+	return best
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"http2/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := []struct {
+		Accept string
+		Want   string
+	}{
+		{"", ""},
+		{"gzip, deflate", "gzip"},
+		{"deflate, gzip;q=0.5", "deflate"},
+		{"br, gzip;q=0.8", "gzip"},
+		{"br", ""},
+		{"identity", ""},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"gzip;q=0", ""},
+		{"GZIP;Q=0.3", "gzip"},
+		{"x-gzip", "gzip"},
+		{"gzip;q=bogus, deflate", "deflate"},
+	}
+	for _, c := range cases {
+		t.Run(c.Accept, func(t *testing.T) {
+			var values []string
+			if c.Accept != "" {
+				values = []string{c.Accept}
+			}
+			assert.Equal(t, c.Want, negotiateEncoding(values))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("compress me please ", 200)
+	h := Compress(CompressOptions{})(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		res.SetHeader("content-type", "text/plain")
+		io.WriteString(res, body)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	rec := do(h, r)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "accept-encoding", rec.Header().Get("Vary"))
+	assert.Less(t, rec.Body.Len(), len(body))
+
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+
+	// Without accept-encoding the body is untouched.
+	rec = do(h, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, rec.Body.String())
+}
+
+func TestCompressDeflate(t *testing.T) {
+	body := strings.Repeat("deflate me please ", 200)
+	h := Compress(CompressOptions{})(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		io.WriteString(res, body)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "deflate")
+	rec := do(h, r)
+	assert.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+
+	// "deflate" is the zlib format, not a raw deflate stream.
+	zr, err := zlib.NewReader(rec.Body)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+}
+
+func TestCompressNoCompression(t *testing.T) {
+	body := strings.Repeat("store me please ", 200)
+	h := Compress(CompressOptions{Level: NoCompression})(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		io.WriteString(res, body)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := do(h, r)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Greater(t, rec.Body.Len(), len(body))
+
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+}
+
+func TestCompressHead(t *testing.T) {
+	h := Compress(CompressOptions{})(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		res.SetHeader("content-type", "text/plain")
+		io.WriteString(res, strings.Repeat("x", 4096))
+	}))
+
+	for _, method := range []string{"GET", "HEAD"} {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := do(h, r)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"), method)
+	}
+
+	// Too short for a GET to be compressed, so a HEAD isn't either.
+	small := Compress(CompressOptions{})(hello)
+	r := httptest.NewRequest("HEAD", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec := do(small, r)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Equal(t, "5", rec.Header().Get("Content-Length"))
+}
+
+func TestCompressSkipped(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Handler session.FuncHandler
+	}{
+		{"Small", func(req *session.Request, res *session.Response) {
+			io.WriteString(res, "tiny")
+		}},
+		{"Image", func(req *session.Request, res *session.Response) {
+			res.SetHeader("content-type", "image/png")
+			io.WriteString(res, strings.Repeat("x", 4096))
+		}},
+		{"ContentLength", func(req *session.Request, res *session.Response) {
+			res.SetHeader("content-length", "4096")
+			io.WriteString(res, strings.Repeat("x", 4096))
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := do(Compress(CompressOptions{})(c.Handler), r)
+			assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"http2/session"
+)
+
+type CORSOptions struct {
+	// Origins allowed to make requests. "*" allows any origin.
+	AllowedOrigins []string
+
+	// Methods and request headers allowed in preflight requests.
+	// Without AllowedMethods, GET, HEAD and POST are allowed;
+	// without AllowedHeaders, whatever the client asks for is.
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// Response headers scripts may read.
+	ExposedHeaders []string
+
+	AllowCredentials bool
+
+	// How long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORS adds cross-origin resource sharing headers to responses for
+// allowed origins, and answers preflight requests itself. Wrap the
+// whole router with it, so OPTIONS requests reach it.
+func CORS(opts CORSOptions) Middleware {
+	methods := opts.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST"}
+	}
+	anyOrigin := slices.Contains(opts.AllowedOrigins, "*")
+
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			origin := req.Header().Get("origin")
+			if origin == "" || !(anyOrigin || slices.Contains(opts.AllowedOrigins, origin)) {
+				next.Handle(req, res)
+				return
+			}
+
+			if anyOrigin && !opts.AllowCredentials {
+				res.SetHeader("access-control-allow-origin", "*")
+			} else {
+				res.SetHeader("access-control-allow-origin", origin)
+				res.SetHeader("vary", "origin")
+			}
+			if opts.AllowCredentials {
+				res.SetHeader("access-control-allow-credentials", "true")
+			}
+
+			reqMethod := req.Header().Get("access-control-request-method")
+			if req.Method() != "OPTIONS" || reqMethod == "" {
+				if len(opts.ExposedHeaders) > 0 {
+					res.SetHeader("access-control-expose-headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+				next.Handle(req, res)
+				return
+			}
+
+			// Preflight
+			if !slices.Contains(methods, reqMethod) {
+				res.WriteHeader(session.Forbidden)
+				return
+			}
+			res.SetHeader("access-control-allow-methods", strings.Join(methods, ", "))
+			if len(opts.AllowedHeaders) > 0 {
+				res.SetHeader("access-control-allow-headers", strings.Join(opts.AllowedHeaders, ", "))
+			} else if h := req.Header().Get("access-control-request-headers"); h != "" {
+				res.SetHeader("access-control-allow-headers", h)
+			}
+			if opts.MaxAge > 0 {
+				res.SetHeader("access-control-max-age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			res.WriteHeader(session.NoContent)
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"log"
+	"time"
+
+	"http2/session"
+)
+
+// Logger logs the method, path, status, body size and handling time
+// of each request, with its request ID if RequestID ran first.
+func Logger(l *log.Logger) Middleware {
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			start := time.Now()
+			next.Handle(req, res)
+			elapsed := time.Since(start)
+
+			if id := RequestIDFrom(req.Context()); id != "" {
+				l.Printf("%s %s %s %d %dB %s", id, req.Method(), req.RequestURI(), res.Status(), res.Written(), elapsed)
+			} else {
+				l.Printf("%s %s %d %dB %s", req.Method(), req.RequestURI(), res.Status(), res.Written(), elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Package middleware has composable wrappers for session Handlers.
+package middleware
+
+import "http2/session"
+
+// A Middleware wraps a Handler with extra behaviour.
+type Middleware func(session.Handler) session.Handler
+
+// Chain composes middlewares into one. The first wraps all the
+// others, so it sees the request first and the response last.
+func Chain(mws ...Middleware) Middleware {
+	return func(h session.Handler) session.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"http2/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do serves a request through h, mounted in net/http for convenience.
+func do(h session.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	session.ToHTTPHandler(h).ServeHTTP(rec, r)
+	return rec
+}
+
+var hello = session.FuncHandler(func(req *session.Request, res *session.Response) {
+	io.WriteString(res, "hello")
+})
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next session.Handler) session.Handler {
+			return session.FuncHandler(func(req *session.Request, res *session.Response) {
+				order = append(order, name)
+				next.Handle(req, res)
+			})
+		}
+	}
+	h := Chain(mark("a"), mark("b"), mark("c"))(hello)
+	do(h, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{"a", "b", "c"}, order)
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := Chain(RequestID(), Logger(log.New(&buf, "", 0)))(hello)
+	r := httptest.NewRequest("GET", "/x?y=1", nil)
+	r.Header.Set("X-Request-Id", "abc")
+	do(h, r)
+	assert.Regexp(t, `^abc GET /x\?y=1 200 5B \S+\n$`, buf.String())
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	h := Recover(log.New(&buf, "", 0))(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		panic("boom")
+	}))
+	srv := httptest.NewServer(session.ToHTTPHandler(h))
+	_, err := srv.Client().Get(srv.URL)
+	assert.Error(t, err)
+	srv.Close()
+	assert.Contains(t, buf.String(), "panic serving GET /: boom")
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID()(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		seen = RequestIDFrom(req.Context())
+	}))
+
+	rec := do(h, httptest.NewRequest("GET", "/", nil))
+	assert.Len(t, seen, 32)
+	assert.Equal(t, seen, rec.Header().Get("X-Request-Id"))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-Id", "from-upstream")
+	rec = do(h, r)
+	assert.Equal(t, "from-upstream", seen)
+	assert.Equal(t, "from-upstream", rec.Header().Get("X-Request-Id"))
+
+	r.Header.Set("X-Request-Id", strings.Repeat("x", 200))
+	do(h, r)
+	assert.Len(t, seen, 32)
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSOptions{
+		AllowedOrigins: []string{"https://app.example"},
+		AllowedMethods: []string{"GET", "PUT"},
+		ExposedHeaders: []string{"Content-Type"},
+		MaxAge:         time.Hour,
+	})(hello)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://app.example")
+	rec := do(h, r)
+	assert.Equal(t, "https://app.example", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Content-Type", rec.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "hello", rec.Body.String())
+
+	r.Header.Set("Origin", "https://evil.example")
+	rec = do(h, r)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "hello", rec.Body.String())
+
+	r = httptest.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://app.example")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	r.Header.Set("Access-Control-Request-Headers", "x-token")
+	rec = do(h, r)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, PUT", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "x-token", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", rec.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, rec.Body.String())
+
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	assert.Equal(t, http.StatusForbidden, do(h, r).Code)
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := CORS(CORSOptions{AllowedOrigins: []string{"*"}})(hello)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://anywhere.example")
+	assert.Equal(t, "*", do(h, r).Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestTimeout(t *testing.T) {
+	h := Timeout(10 * time.Millisecond)(session.FuncHandler(func(req *session.Request, res *session.Response) {
+		select {
+		case <-req.Context().Done():
+			res.WriteHeader(session.ServerError)
+		case <-time.After(time.Second):
+		}
+	}))
+	rec := do(h, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"log"
+	"runtime/debug"
+
+	"http2/session"
+)
+
+// Recover stops a panicking handler from taking down the connection.
+// The panic is logged and the stream is reset with INTERNAL_ERROR,
+// since the response may already be partly sent.
+func Recover(l *log.Logger) Middleware {
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			defer func() {
+				if err := recover(); err != nil {
+					l.Printf("panic serving %s %s: %v\n%s", req.Method(), req.RequestURI(), err, debug.Stack())
+					res.Reset(session.ErrorCodeInternal)
+				}
+			}()
+			next.Handle(req, res)
+		})
+	}
+}
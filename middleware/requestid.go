@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"http2/session"
+)
+
+const RequestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+// RequestID gives each request an ID, echoed in the x-request-id
+// response header. A well-formed ID sent by the client is kept, so
+// IDs can be followed across services.
+func RequestID() Middleware {
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			id := req.Header().Get(RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			res.SetHeader(RequestIDHeader, id)
+			ctx := context.WithValue(req.Context(), requestIDKey{}, id)
+			next.Handle(req.WithContext(ctx), res)
+		})
+	}
+}
+
+// RequestIDFrom returns the ID RequestID stored in ctx, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID accepts short IDs of printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= 0x20 || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"http2/session"
+)
+
+// Timeout cancels the request's context after d. Handlers have to
+// watch the context to stop early; the response isn't touched, so a
+// handler that ignores it runs to completion.
+func Timeout(d time.Duration) Middleware {
+	return func(next session.Handler) session.Handler {
+		return session.FuncHandler(func(req *session.Request, res *session.Response) {
+			ctx, cancel := context.WithTimeout(req.Context(), d)
+			defer cancel()
+			next.Handle(req.WithContext(ctx), res)
+		})
+	}
+}
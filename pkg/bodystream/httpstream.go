@@ -13,6 +13,18 @@ type BodyStream struct {
 
 	isClosed bool
 
+	// Once set, writes are dropped rather than buffered.
+	discarding bool
+
+	// Told how many octets leave the buffer on each read or
+	// discard.
+	onConsume func(n int)
+
+	// For a stream made by NewReaderStream, where reads come from
+	// and what to call once it runs out.
+	src   io.Reader
+	onEOF func()
+
 	mu *sync.Mutex
 	cv *sync.Cond
 }
@@ -25,6 +37,42 @@ func NewBodyStream() *BodyStream {
 	return &bs
 }
 
+// NewReaderStream returns a BodyStream whose reads come straight from
+// r, on the reading goroutine, rather than from writes. onEOF, if not
+// nil, is called once r runs out, before Read returns io.EOF.
+func NewReaderStream(r io.Reader, onEOF func()) *BodyStream {
+	bs := NewBodyStream()
+	bs.src = r
+	bs.onEOF = onEOF
+	return bs
+}
+
+// SetOnConsume makes f be called, outside the stream's lock, with the
+// number of buffered octets each Read takes, and each Discard, or
+// Write after it, drops. It must be set before the stream is used.
+func (st *BodyStream) SetOnConsume(f func(n int)) {
+	st.onConsume = f
+}
+
+// Discard drops the buffered data, and everything written from now
+// on, for a stream nobody will read. Reads see io.EOF.
+func (st *BodyStream) Discard() {
+	st.mu.Lock()
+	n := st.buf.Len()
+	st.buf.Reset()
+	st.discarding = true
+	st.isClosed = true
+	st.mu.Unlock()
+	st.cv.Signal()
+	st.consumed(n)
+}
+
+func (st *BodyStream) consumed(n int) {
+	if n > 0 && st.onConsume != nil {
+		st.onConsume(n)
+	}
+}
+
 func (st *BodyStream) Close() error {
 	st.mu.Lock()
 	st.isClosed = true
@@ -34,21 +82,47 @@ func (st *BodyStream) Close() error {
 }
 
 func (st *BodyStream) Read(data []byte) (int, error) {
+	if st.src != nil {
+		return st.readSource(data)
+	}
 	st.mu.Lock()
 	for !st.isClosed && st.buf.Len() <= 0 {
 		st.cv.Wait()
 	}
-	defer st.mu.Unlock()
 	if st.buf.Len() > 0 {
-		return st.buf.Read(data)
+		n, err := st.buf.Read(data)
+		st.mu.Unlock()
+		st.consumed(n)
+		return n, err
 	}
+	st.mu.Unlock()
 	return 0, io.EOF
 }
 
 func (st *BodyStream) Write(data []byte) (int, error) {
 	st.mu.Lock()
+	if st.discarding {
+		st.mu.Unlock()
+		st.consumed(len(data))
+		return len(data), nil
+	}
 	ret, err := st.buf.Write(data)
 	st.mu.Unlock()
 	st.cv.Signal()
 	return ret, err
 }
+
+func (st *BodyStream) readSource(data []byte) (int, error) {
+	st.mu.Lock()
+	closed := st.isClosed
+	st.mu.Unlock()
+	if closed {
+		return 0, io.EOF
+	}
+	n, err := st.src.Read(data)
+	if err == io.EOF && st.onEOF != nil {
+		st.onEOF()
+		st.onEOF = nil
+	}
+	return n, err
+}
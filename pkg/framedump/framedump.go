@@ -0,0 +1,246 @@
+// Package framedump prints HTTP/2 frames in a readable form, decoding
+// header blocks, SETTINGS, WINDOW_UPDATE, RST_STREAM and GOAWAY.
+//
+// A Decoder follows one direction of a connection. It has its own
+// HPACK table, so two of them can follow both sides of a connection
+// from the outside, without access to the peers' state. Pair them so
+// that each side's SETTINGS_HEADER_TABLE_SIZE reaches the table of
+// the other.
+package framedump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+)
+
+// A Decoder describes the frames one side of a connection sends.
+type Decoder struct {
+	// Written at the start of every line, e.g. "> " or "< ".
+	Prefix string
+	// Whether to colour frame types with ANSI escapes.
+	Color bool
+	// How many octets of DATA payload to show. Zero shows none.
+	MaxData int
+
+	mu    *sync.Mutex
+	w     io.Writer
+	table *hpack.HeaderLookupTable
+	// How far table may grow, as the other side advertised. Set
+	// from its Decoder's goroutine.
+	sizeLimit atomic.Int64
+	peer      *Decoder
+
+	// A header block waiting for its CONTINUATION frames.
+	block []byte
+
+	split *frame.Splitter
+}
+
+// NewDecoder returns a Decoder writing to w. Decoders for the two
+// sides of a connection can share mu so their lines don't interleave;
+// if mu is nil the Decoder gets its own.
+func NewDecoder(w io.Writer, prefix string, mu *sync.Mutex) *Decoder {
+	if mu == nil {
+		mu = new(sync.Mutex)
+	}
+	d := &Decoder{Prefix: prefix, mu: mu, w: w, table: hpack.NewHeaderLookupTable()}
+	d.sizeLimit.Store(hpack.DefaultTableSize)
+	d.split = frame.NewSplitter(func(fh *frame.FrameHeader, data []byte) {
+		d.Frame(&frame.Frame{FrameHeader: fh, Data: append([]byte{}, data...)})
+	})
+	return d
+}
+
+// Pair links the Decoders of the two directions of a connection, so
+// the SETTINGS_HEADER_TABLE_SIZE either side sends lets the other
+// side's encoder grow its table.
+func Pair(a, b *Decoder) {
+	a.peer, b.peer = b, a
+}
+
+// Write parses a byte stream into frames, skipping the client
+// connection preface if it starts with one, and describes each frame
+// as it's completed. It never fails, so a Decoder can be used as the
+// tap in io.TeeReader or io.MultiWriter.
+func (d *Decoder) Write(p []byte) (int, error) {
+	return d.split.Write(p)
+}
+
+var colors = map[frame.FrameType]string{
+	frame.FrameData:         "\x1b[37m",
+	frame.FrameHeaders:      "\x1b[32m",
+	frame.FrameContinuation: "\x1b[32m",
+	frame.FrameSettings:     "\x1b[36m",
+	frame.FrameWindowUpdate: "\x1b[34m",
+	frame.FramePing:         "\x1b[35m",
+	frame.FrameResetStream:  "\x1b[31m",
+	frame.FrameGoaway:       "\x1b[31m",
+}
+
+// Frame describes one frame.
+func (d *Decoder) Frame(f *frame.Frame) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fh := f.FrameHeader
+
+	name := strings.TrimPrefix(fh.Type.String(), "Frame")
+	if d.Color {
+		if c, ok := colors[fh.Type]; ok {
+			name = c + name + "\x1b[0m"
+		}
+	}
+	fmt.Fprintf(d.w, "%s%s stream=%d len=%d%s\n", d.Prefix, name, fh.Sid, fh.Length, flagNames(fh))
+	for _, line := range d.describe(f) {
+		fmt.Fprintf(d.w, "%s    %s\n", d.Prefix, line)
+	}
+}
+
+func flagNames(fh *frame.FrameHeader) string {
+	var names []string
+	switch fh.Type {
+	case frame.FrameSettings, frame.FramePing:
+		if fh.Flag(0) {
+			names = append(names, "ACK")
+		}
+	case frame.FrameData, frame.FrameHeaders, frame.FrameContinuation:
+		if fh.Type != frame.FrameContinuation && fh.Flag(0) {
+			names = append(names, "END_STREAM")
+		}
+		if fh.Type != frame.FrameData && fh.Flag(2) {
+			names = append(names, "END_HEADERS")
+		}
+		if fh.Type != frame.FrameContinuation && fh.Flag(3) {
+			names = append(names, "PADDED")
+		}
+		if fh.Type == frame.FrameHeaders && fh.Flag(5) {
+			names = append(names, "PRIORITY")
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	return " " + strings.Join(names, "|")
+}
+
+// describe returns the lines detailing a frame's payload.
+func (d *Decoder) describe(f *frame.Frame) []string {
+	fh, data := f.FrameHeader, f.Data
+	switch fh.Type {
+	case frame.FrameData:
+		if d.MaxData <= 0 || len(data) == 0 {
+			return nil
+		}
+		shown := data[:min(len(data), d.MaxData)]
+		line := fmt.Sprintf("%q", shown)
+		if len(shown) < len(data) {
+			line += "..."
+		}
+		return []string{line}
+
+	case frame.FrameHeaders:
+		block, err := frame.HeaderBlockFragment(fh, data)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		if !fh.Flag(2) {
+			d.block = append([]byte{}, block...)
+			return nil
+		}
+		return d.decode(block)
+
+	case frame.FrameContinuation:
+		d.block = append(d.block, data...)
+		if !fh.Flag(2) {
+			return nil
+		}
+		block := d.block
+		d.block = nil
+		return d.decode(block)
+
+	case frame.FrameSettings:
+		if fh.Flag(0) || len(data)%6 != 0 {
+			return nil
+		}
+		var lines []string
+		for _, s := range settings.SettingsListFromFramePayload(data).Settings {
+			lines = append(lines, fmt.Sprintf("%s = %d", s.Type, s.Value))
+			if s.Type == settings.HeaderTableSize && d.peer != nil {
+				d.peer.sizeLimit.Store(int64(s.Value))
+			}
+		}
+		return lines
+
+	case frame.FrameWindowUpdate:
+		if len(data) != 4 {
+			return nil
+		}
+		return []string{fmt.Sprintf("increment = %d", binary.BigEndian.Uint32(data)&0x7fffffff)}
+
+	case frame.FrameResetStream:
+		if len(data) != 4 {
+			return nil
+		}
+		return []string{session.ErrorCode(binary.BigEndian.Uint32(data)).String()}
+
+	case frame.FrameGoaway:
+		if len(data) < 8 {
+			return nil
+		}
+		gf := session.GoawayFrameFromPayload(data)
+		lines := []string{fmt.Sprintf("last stream = %d, %s", gf.LastStreamId, gf.ErrorCode)}
+		if len(gf.DebugInfo) > 0 {
+			lines = append(lines, fmt.Sprintf("debug = %q", gf.DebugInfo))
+		}
+		return lines
+
+	case frame.FramePing:
+		return []string{fmt.Sprintf("% x", data)}
+	}
+	return nil
+}
+
+func (d *Decoder) decode(block []byte) []string {
+	d.table.SetSizeLimit(int(d.sizeLimit.Load()))
+	var lines []string
+	err := hpack.DecodeBlock(d.table, block, func(k, v string) {
+		lines = append(lines, k+": "+v)
+	})
+	if err != nil {
+		lines = append(lines, "hpack: "+err.Error())
+	}
+	return lines
+}
+
+// A tapConn copies a connection's traffic to two writers.
+type tapConn struct {
+	net.Conn
+	out, in io.Writer
+}
+
+// Tap returns conn with everything written to it also written to out,
+// and everything read from it to in.
+func Tap(conn net.Conn, out, in io.Writer) net.Conn {
+	return &tapConn{Conn: conn, out: out, in: in}
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Write(p[:n])
+	return n, err
+}
+
+func (c *tapConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Write(p[:n])
+	return n, err
+}
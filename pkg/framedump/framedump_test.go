@@ -0,0 +1,89 @@
+package framedump
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFrame(buf *bytes.Buffer, typ frame.FrameType, flags uint8, sid frame.Sid, data []byte) {
+	fh := frame.FrameHeader{Length: uint32(len(data)), Type: typ, Flags: flags, Sid: sid}
+	fh.Marshal(buf)
+	buf.Write(data)
+}
+
+func TestDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(frame.ClientPreface)
+	var sl settings.SettingsList
+	sl.Put(settings.MaxFrameSize, 32768)
+	writeFrame(&stream, frame.FrameSettings, 0, 0, sl.ToPayload())
+
+	hl := hpack.NewHeaderList(hpack.NewHeaderLookupTable())
+	hl.Put(":method", "GET")
+	hl.Put("x-test", "yes")
+	block := hl.Dump()
+	writeFrame(&stream, frame.FrameHeaders, session.FLAG_END_STREAM, 1, block[:2])
+	writeFrame(&stream, frame.FrameContinuation, session.FLAG_END_HEADERS, 1, block[2:])
+	writeFrame(&stream, frame.FrameWindowUpdate, 0, 0, []byte{0, 0, 1, 0})
+	writeFrame(&stream, frame.FrameData, session.FLAG_END_STREAM, 3, []byte("hello"))
+	gf := session.GoawayFrame{LastStreamId: 3, ErrorCode: session.ErrorCodeProtocol}
+	writeFrame(&stream, frame.FrameGoaway, 0, 0, gf.Marshal())
+
+	var out bytes.Buffer
+	d := NewDecoder(&out, "> ", nil)
+	d.MaxData = 3
+	// Split the stream so frames straddle writes.
+	data := stream.Bytes()
+	for len(data) > 0 {
+		n := min(len(data), 7)
+		d.Write(data[:n])
+		data = data[n:]
+	}
+
+	assert.Equal(t, `> Settings stream=0 len=6
+>     MaxFrameSize = 32768
+> Headers stream=1 len=2 END_STREAM
+> Continuation stream=1 len=`+strconv.Itoa(len(block)-2)+` END_HEADERS
+>     :method: GET
+>     x-test: yes
+> WindowUpdate stream=0 len=4
+>     increment = 256
+> Data stream=3 len=5 END_STREAM
+>     "hel"...
+> Goaway stream=0 len=8
+>     last stream = 3, ErrorCodeProtocol
+`, out.String())
+}
+
+func TestPairedTableSize(t *testing.T) {
+	var out bytes.Buffer
+	client := NewDecoder(&out, "> ", nil)
+	server := NewDecoder(&out, "< ", nil)
+	Pair(client, server)
+
+	// The client lets the server's table grow past the default.
+	var stream bytes.Buffer
+	var sl settings.SettingsList
+	sl.Put(settings.HeaderTableSize, 8192)
+	writeFrame(&stream, frame.FrameSettings, 0, 0, sl.ToPayload())
+	client.Write(stream.Bytes())
+
+	stream.Reset()
+	block := append(hpack.TableSizeUpdate(8192).Encode(), 0x88)
+	writeFrame(&stream, frame.FrameHeaders, session.FLAG_END_HEADERS, 1, block)
+	server.Write(stream.Bytes())
+
+	assert.Equal(t, `> Settings stream=0 len=6
+>     HeaderTableSize = 8192
+< Headers stream=1 len=`+strconv.Itoa(len(block))+` END_HEADERS
+<     :status: 200
+`, out.String())
+}
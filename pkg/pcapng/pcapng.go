@@ -0,0 +1,301 @@
+// Package pcapng writes the plaintext of HTTP/2 connections as pcapng
+// files, so Wireshark's HTTP/2 dissector can be used on traffic that
+// went over TLS.
+//
+// Nothing is captured off the network. A Flow is fed the bytes each
+// side of a connection sent, as a program sees them once TLS is out
+// of the way, and wraps them in made-up IPv4 and TCP headers: a
+// handshake when the Flow is made, a segment for every write, and
+// FINs when it's closed.
+//
+// For a raw capture of the encrypted traffic instead, OpenKeyLog gives
+// Wireshark the TLS secrets to decrypt it with.
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// Block types and the link type of the one interface, whose packets
+// start with an IPv4 header.
+const (
+	blockSectionHeader  = 0x0a0d0d0a
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+	byteOrderMagic      = 0x1a2b3c4d
+	linkTypeIPv4        = 228
+)
+
+// The most payload one segment can carry, so that the IPv4 total
+// length fits in 16 bits.
+const maxSegment = 65535 - 20 - 20
+
+// A Writer writes packets to a pcapng file. It's safe for concurrent
+// use, so the flows of every connection of a server can share one.
+type Writer struct {
+	// Timestamps the packets. Defaults to time.Now.
+	Now func() time.Time
+
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	err     error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WritePacket writes an IPv4 packet, headers and all, with the current
+// time. The file's header is written before the first packet. Once a
+// write fails, every later one returns the same error.
+func (pw *Writer) WritePacket(pkt []byte) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.err != nil {
+		return pw.err
+	}
+	now := time.Now()
+	if pw.Now != nil {
+		now = pw.Now()
+	}
+
+	var buf bytes.Buffer
+	if !pw.started {
+		// A section header with no options and an unknown length.
+		writeBlock(&buf, blockSectionHeader, func(b []byte) []byte {
+			b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+			b = binary.LittleEndian.AppendUint16(b, 1)
+			b = binary.LittleEndian.AppendUint16(b, 0)
+			return binary.LittleEndian.AppendUint64(b, ^uint64(0))
+		})
+		// The interface, with the default resolution of a
+		// microsecond and no snapshot limit.
+		writeBlock(&buf, blockInterface, func(b []byte) []byte {
+			b = binary.LittleEndian.AppendUint16(b, linkTypeIPv4)
+			b = binary.LittleEndian.AppendUint16(b, 0)
+			return binary.LittleEndian.AppendUint32(b, 0)
+		})
+		pw.started = true
+	}
+	ts := uint64(now.UnixMicro())
+	writeBlock(&buf, blockEnhancedPacket, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(pkt)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(pkt)))
+		b = append(b, pkt...)
+		return append(b, make([]byte, pad(len(pkt)))...)
+	})
+
+	_, pw.err = pw.w.Write(buf.Bytes())
+	return pw.err
+}
+
+// Close closes the underlying writer, if it's an io.Closer.
+func (pw *Writer) Close() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if c, ok := pw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// writeBlock writes a block whose body body appends, between the type
+// and length at the start and the length again at the end.
+func writeBlock(buf *bytes.Buffer, typ uint32, body func([]byte) []byte) {
+	b := body(nil)
+	n := uint32(12 + len(b))
+	var head [8]byte
+	binary.LittleEndian.PutUint32(head[0:], typ)
+	binary.LittleEndian.PutUint32(head[4:], n)
+	buf.Write(head[:])
+	buf.Write(b)
+	buf.Write(head[4:])
+}
+
+// pad is how many zero octets bring n up to a multiple of four.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// A Flow is one TCP connection in a pcapng file.
+type Flow struct {
+	w              *Writer
+	client, server netip.AddrPort
+
+	mu sync.Mutex
+	// The next sequence number each side sends, which is also what
+	// the other side acknowledges.
+	clientSeq, serverSeq uint32
+	closed               bool
+}
+
+// TCP flags.
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// NewFlow starts a connection between two IPv4 addresses and writes
+// its handshake. Wireshark picks a dissector by port, so the server's
+// should be one it decodes HTTP/2 on.
+func (pw *Writer) NewFlow(client, server netip.AddrPort) *Flow {
+	f := &Flow{
+		w:      pw,
+		client: client,
+		server: server,
+		// Fixed initial sequence numbers keep files reproducible.
+		clientSeq: 1000,
+		serverSeq: 2000,
+	}
+	f.segment(true, flagSYN, nil)
+	f.segment(false, flagSYN|flagACK, nil)
+	f.segment(true, flagACK, nil)
+	return f
+}
+
+// Client returns a writer for the bytes the client sent. Like Server,
+// it never fails, so it can be the tap of an io.TeeReader or
+// io.MultiWriter without breaking the connection it's watching.
+func (f *Flow) Client() io.Writer {
+	return flowWriter{f, true}
+}
+
+// Server returns a writer for the bytes the server sent.
+func (f *Flow) Server() io.Writer {
+	return flowWriter{f, false}
+}
+
+// Close ends the connection with a FIN from each side.
+func (f *Flow) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	f.segment(false, flagFIN|flagACK, nil)
+	f.segment(true, flagFIN|flagACK, nil)
+	return f.segment(false, flagACK, nil)
+}
+
+type flowWriter struct {
+	f          *Flow
+	fromClient bool
+}
+
+func (fw flowWriter) Write(p []byte) (int, error) {
+	fw.f.mu.Lock()
+	defer fw.f.mu.Unlock()
+	if fw.f.closed {
+		return len(p), nil
+	}
+	for rest := p; len(rest) > 0; {
+		n := min(len(rest), maxSegment)
+		fw.f.segment(fw.fromClient, flagPSH|flagACK, rest[:n])
+		rest = rest[n:]
+	}
+	return len(p), nil
+}
+
+// segment writes a TCP segment from one side and advances that side's
+// sequence number. Callers must hold mu, except NewFlow, which has the
+// Flow to itself.
+func (f *Flow) segment(fromClient bool, flags uint8, payload []byte) error {
+	src, dst := f.client, f.server
+	seq, ack := &f.clientSeq, f.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		seq, ack = &f.serverSeq, f.clientSeq
+	}
+	if flags&flagACK == 0 {
+		ack = 0
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.Port())
+	binary.BigEndian.PutUint16(tcp[2:], dst.Port())
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+	pseudo := make([]byte, 0, 12)
+	pseudo = append(pseudo, srcIP[:]...)
+	pseudo = append(pseudo, dstIP[:]...)
+	pseudo = append(pseudo, 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(pseudo, tcp))
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 4<<4 | 5
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	// Don't fragment.
+	ip[6] = 0x40
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], srcIP[:])
+	copy(ip[16:], dstIP[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	ip = append(ip, tcp...)
+
+	// SYN and FIN take up a sequence number, like a payload octet.
+	*seq += uint32(len(payload))
+	if flags&(flagSYN|flagFIN) != 0 {
+		*seq++
+	}
+	return f.w.WritePacket(ip)
+}
+
+// checksum is the Internet checksum of the concatenated parts.
+func checksum(parts ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var last byte
+	for _, p := range parts {
+		for _, b := range p {
+			if odd {
+				sum += uint32(last)<<8 | uint32(b)
+			} else {
+				last = b
+			}
+			odd = !odd
+		}
+	}
+	if odd {
+		sum += uint32(last) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// OpenKeyLog opens the file named by the SSLKEYLOGFILE environment
+// variable for appending, to be a tls.Config's KeyLogWriter. Wireshark
+// can then decrypt a capture of the real traffic with it. It returns
+// nil if the variable isn't set.
+//
+// Anyone with the file can read the connections it covers, so it's
+// for debugging only.
+func OpenKeyLog() (*os.File, error) {
+	name := os.Getenv("SSLKEYLOGFILE")
+	if name == "" {
+		return nil, nil
+	}
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+}
@@ -0,0 +1,147 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []block {
+	var ret []block
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 12)
+		typ := binary.LittleEndian.Uint32(data)
+		n := int(binary.LittleEndian.Uint32(data[4:]))
+		require.Zero(t, n%4)
+		require.LessOrEqual(t, n, len(data))
+		require.Equal(t, uint32(n), binary.LittleEndian.Uint32(data[n-4:]))
+		ret = append(ret, block{typ, data[8 : n-4]})
+		data = data[n:]
+	}
+	return ret
+}
+
+// segment is what a test cares about in a packet.
+type segment struct {
+	src, dst netip.AddrPort
+	seq, ack uint32
+	flags    uint8
+	payload  string
+}
+
+func parsePacket(t *testing.T, b block) (time.Time, segment) {
+	require.Equal(t, uint32(blockEnhancedPacket), b.typ)
+	ts := uint64(binary.LittleEndian.Uint32(b.body[4:]))<<32 | uint64(binary.LittleEndian.Uint32(b.body[8:]))
+	n := binary.LittleEndian.Uint32(b.body[12:])
+	pkt := b.body[20 : 20+n]
+
+	ip, tcp := pkt[:20], pkt[20:]
+	require.Equal(t, byte(0x45), ip[0])
+	require.Equal(t, int(n), int(binary.BigEndian.Uint16(ip[2:])))
+	assert.Zero(t, checksum(ip), "IPv4 checksum")
+	pseudo := append(append([]byte{}, ip[12:20]...), 0, 6)
+	pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(tcp)))
+	assert.Zero(t, checksum(pseudo, tcp), "TCP checksum")
+
+	src := netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip[12:16])), binary.BigEndian.Uint16(tcp[0:]))
+	dst := netip.AddrPortFrom(netip.AddrFrom4([4]byte(ip[16:20])), binary.BigEndian.Uint16(tcp[2:]))
+	return time.UnixMicro(int64(ts)), segment{
+		src:     src,
+		dst:     dst,
+		seq:     binary.BigEndian.Uint32(tcp[4:]),
+		ack:     binary.BigEndian.Uint32(tcp[8:]),
+		flags:   tcp[13],
+		payload: string(tcp[20:]),
+	}
+}
+
+func TestFlow(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewWriter(&buf)
+	now := time.Unix(1700000000, 0)
+	pw.Now = func() time.Time { return now }
+
+	c := netip.MustParseAddrPort("192.0.2.1:50000")
+	s := netip.MustParseAddrPort("192.0.2.2:443")
+	flow := pw.NewFlow(c, s)
+	io.WriteString(flow.Client(), "hello")
+	now = now.Add(time.Millisecond)
+	io.WriteString(flow.Server(), "odd")
+	require.NoError(t, flow.Close())
+	// Writes after Close are dropped.
+	io.WriteString(flow.Client(), "late")
+
+	blocks := readBlocks(t, buf.Bytes())
+	require.Len(t, blocks, 2+3+2+3)
+	assert.Equal(t, uint32(blockSectionHeader), blocks[0].typ)
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body))
+	assert.Equal(t, uint32(blockInterface), blocks[1].typ)
+	assert.Equal(t, uint16(linkTypeIPv4), binary.LittleEndian.Uint16(blocks[1].body))
+
+	var segs []segment
+	for _, b := range blocks[2:] {
+		_, seg := parsePacket(t, b)
+		segs = append(segs, seg)
+	}
+	assert.Equal(t, []segment{
+		{c, s, 1000, 0, flagSYN, ""},
+		{s, c, 2000, 1001, flagSYN | flagACK, ""},
+		{c, s, 1001, 2001, flagACK, ""},
+		{c, s, 1001, 2001, flagPSH | flagACK, "hello"},
+		{s, c, 2001, 1006, flagPSH | flagACK, "odd"},
+		{s, c, 2004, 1006, flagFIN | flagACK, ""},
+		{c, s, 1006, 2005, flagFIN | flagACK, ""},
+		{s, c, 2005, 1007, flagACK, ""},
+	}, segs)
+
+	ts, _ := parsePacket(t, blocks[6])
+	assert.Equal(t, now, ts)
+}
+
+func TestFlowSplitsLargeWrites(t *testing.T) {
+	var buf bytes.Buffer
+	flow := NewWriter(&buf).NewFlow(
+		netip.MustParseAddrPort("10.0.0.2:1234"),
+		netip.MustParseAddrPort("10.0.0.1:80"),
+	)
+	data := bytes.Repeat([]byte("x"), maxSegment+7)
+	flow.Server().Write(data)
+
+	blocks := readBlocks(t, buf.Bytes())
+	require.Len(t, blocks, 2+3+2)
+	_, first := parsePacket(t, blocks[5])
+	_, second := parsePacket(t, blocks[6])
+	assert.Len(t, first.payload, maxSegment)
+	assert.Len(t, second.payload, 7)
+	assert.Equal(t, first.seq+maxSegment, second.seq)
+}
+
+func TestOpenKeyLog(t *testing.T) {
+	t.Setenv("SSLKEYLOGFILE", "")
+	f, err := OpenKeyLog()
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+
+	name := filepath.Join(t.TempDir(), "keys.log")
+	require.NoError(t, os.WriteFile(name, []byte("first\n"), 0600))
+	t.Setenv("SSLKEYLOGFILE", name)
+	f, err = OpenKeyLog()
+	require.NoError(t, err)
+	io.WriteString(f, "second\n")
+	f.Close()
+	data, _ := os.ReadFile(name)
+	assert.Equal(t, "first\nsecond\n", string(data))
+}
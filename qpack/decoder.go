@@ -0,0 +1,406 @@
+package qpack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"http2/hpack"
+	"io"
+	"math"
+	"sync"
+)
+
+// A Decoder decompresses field sections for one connection. The peer's
+// encoder stream must be fed to ReadEncoderStream, and acknowledgements
+// are written to the decoder stream.
+type Decoder struct {
+	// mu guards table state and cond signals inserts. wlock serializes
+	// writes to the decoder stream, handed off from mu like Encoder's.
+	mu     *sync.Mutex
+	cond   *sync.Cond
+	wlock  *sync.Mutex
+	stream io.Writer
+
+	tbl dynamicTable
+
+	// Our SETTINGS_QPACK_MAX_TABLE_CAPACITY and
+	// SETTINGS_QPACK_BLOCKED_STREAMS.
+	maxTableCapacity  uint64
+	maxBlockedStreams int
+
+	blocked int
+
+	// Number of inserts the encoder knows we've received.
+	acked uint64
+
+	// Set once the encoder stream ends.
+	closed error
+}
+
+func NewDecoder(stream io.Writer, maxTableCapacity uint64, maxBlockedStreams int) *Decoder {
+	d := &Decoder{
+		mu:                new(sync.Mutex),
+		wlock:             new(sync.Mutex),
+		stream:            stream,
+		maxTableCapacity:  maxTableCapacity,
+		maxBlockedStreams: maxBlockedStreams,
+	}
+	d.cond = sync.NewCond(d.mu)
+	return d
+}
+
+// write sends decoder stream instructions, releasing mu once the
+// write lock is held.
+func (d *Decoder) write(inst []byte) error {
+	d.wlock.Lock()
+	d.mu.Unlock()
+	defer d.wlock.Unlock()
+	if len(inst) == 0 {
+		return nil
+	}
+	_, err := d.stream.Write(inst)
+	return err
+}
+
+// ReadEncoderStream processes the peer's encoder stream until it ends
+// or carries an invalid instruction. Inserts are acknowledged with an
+// Insert Count Increment after each read. Once it returns, field
+// sections still waiting for inserts fail.
+func (d *Decoder) ReadEncoderStream(r io.Reader) error {
+	err := readInstructions(r, d.handleEncoderInstruction, d.acknowledgeInserts)
+	if err != nil {
+		err = &Error{ErrorCodeEncoderStream, err}
+	}
+
+	d.mu.Lock()
+	d.closed = DecoderClosed
+	d.cond.Broadcast()
+	d.mu.Unlock()
+	return err
+}
+
+func (d *Decoder) acknowledgeInserts() error {
+	d.mu.Lock()
+	var inst []byte
+	if n := d.tbl.insertCount(); n > d.acked {
+		inst = appendInteger(inst, 0x00, n-d.acked, 6)
+		d.acked = n
+	}
+	return d.write(inst)
+}
+
+func (d *Decoder) handleEncoderInstruction(data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := data[0]
+	switch {
+	// Insert With Name Reference
+	case c&0x80 != 0:
+		idx, n, err := hpack.DecodeInteger(data, 6)
+		if err != nil {
+			return 0, err
+		}
+		var name string
+		if c&0x40 != 0 {
+			if int(idx) >= len(StaticTable) {
+				return 0, IndexOutOfBounds
+			}
+			name = StaticTable[idx].Key
+		} else {
+			te, ok := d.relative(uint64(idx))
+			if !ok {
+				return 0, IndexOutOfBounds
+			}
+			name = te.Key
+		}
+		value, m, err := hpack.DecodeStringPrefix(data[n:], 7)
+		if err != nil {
+			return 0, err
+		}
+		return n + m, d.insert(name, string(value))
+
+	// Insert With Literal Name
+	case c&0x40 != 0:
+		name, n, err := hpack.DecodeStringPrefix(data, 5)
+		if err != nil {
+			return 0, err
+		}
+		value, m, err := hpack.DecodeStringPrefix(data[n:], 7)
+		if err != nil {
+			return 0, err
+		}
+		return n + m, d.insert(string(name), string(value))
+
+	// Set Dynamic Table Capacity
+	case c&0x20 != 0:
+		capacity, n, err := hpack.DecodeInteger(data, 5)
+		if err != nil {
+			return 0, err
+		}
+		if uint64(capacity) > d.maxTableCapacity {
+			return 0, CapacityExceeded
+		}
+		d.tbl.setCapacity(int(capacity))
+		return n, nil
+
+	// Duplicate
+	default:
+		idx, n, err := hpack.DecodeInteger(data, 5)
+		if err != nil {
+			return 0, err
+		}
+		te, ok := d.relative(uint64(idx))
+		if !ok {
+			return 0, IndexOutOfBounds
+		}
+		return n, d.insert(te.Key, te.Value)
+	}
+}
+
+// relative looks up an encoder stream relative index, which counts
+// back from the most recent insert.
+func (d *Decoder) relative(idx uint64) (hpack.TableEntry, bool) {
+	n := d.tbl.insertCount()
+	if idx >= n {
+		return hpack.TableEntry{}, false
+	}
+	return d.tbl.get(n - 1 - idx)
+}
+
+func (d *Decoder) insert(k, v string) error {
+	if _, err := d.tbl.insert(hpack.TableEntry{Key: k, Value: v}); err != nil {
+		return err
+	}
+	d.cond.Broadcast()
+	return nil
+}
+
+// DecodeFieldSection decodes a field section received on the given
+// stream. If the section references inserts that haven't arrived on
+// the encoder stream yet, it blocks until they do or ctx is done.
+func (d *Decoder) DecodeFieldSection(ctx context.Context, streamID uint64, data []byte) ([]Field, error) {
+	if streamID > math.MaxUint32 {
+		return nil, fmt.Errorf("stream ID %d is too large", streamID)
+	}
+	d.mu.Lock()
+
+	fields, ric, err := d.decodeSection(ctx, data)
+	if err != nil {
+		d.mu.Unlock()
+		var qe *Error
+		if errors.As(err, &qe) || errors.Is(err, ctx.Err()) {
+			return nil, err
+		}
+		return nil, &Error{ErrorCodeDecompressionFailed, err}
+	}
+
+	// Sections that depend on the dynamic table must be acknowledged.
+	var inst []byte
+	if ric > 0 {
+		inst = appendInteger(inst, 0x80, streamID, 7)
+		d.acked = max(d.acked, ric)
+	}
+	if err := d.write(inst); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// decodeSection decodes a field section. Callers must hold mu.
+func (d *Decoder) decodeSection(ctx context.Context, data []byte) ([]Field, uint64, error) {
+	encRIC, n, err := hpack.DecodeInteger(data, 8)
+	if err != nil {
+		return nil, 0, err
+	}
+	ric, err := decodeRequiredInsertCount(uint64(encRIC), d.maxTableCapacity/32, d.tbl.insertCount())
+	if err != nil {
+		return nil, 0, err
+	}
+	data = data[n:]
+	if len(data) == 0 {
+		return nil, 0, hpack.IntegerTruncated
+	}
+	signed := data[0]&0x80 != 0
+	deltaBase, n, err := hpack.DecodeInteger(data, 7)
+	if err != nil {
+		return nil, 0, err
+	}
+	data = data[n:]
+	base := ric + uint64(deltaBase)
+	if signed {
+		if uint64(deltaBase) >= ric {
+			return nil, 0, InvalidRequiredCount
+		}
+		base = ric - uint64(deltaBase) - 1
+	}
+
+	if err := d.waitForInserts(ctx, ric); err != nil {
+		return nil, 0, err
+	}
+
+	sd := sectionDecoder{Decoder: d, ric: ric, base: base}
+	var fields []Field
+	for len(data) > 0 {
+		f, n, err := sd.next(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		fields = append(fields, f)
+		data = data[n:]
+	}
+	return fields, ric, nil
+}
+
+// waitForInserts blocks until ric entries have been inserted.
+// Callers must hold mu.
+func (d *Decoder) waitForInserts(ctx context.Context, ric uint64) error {
+	if ric <= d.tbl.insertCount() {
+		return nil
+	}
+	if d.blocked >= d.maxBlockedStreams {
+		return &Error{ErrorCodeDecompressionFailed, TooManyBlockedStreams}
+	}
+	d.blocked += 1
+	defer func() { d.blocked -= 1 }()
+
+	stop := context.AfterFunc(ctx, func() {
+		d.mu.Lock()
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	})
+	defer stop()
+
+	for ric > d.tbl.insertCount() {
+		if d.closed != nil {
+			return d.closed
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		d.cond.Wait()
+	}
+	return nil
+}
+
+// CancelStream tells the encoder that a stream was reset or abandoned
+// before its field sections were decoded, so it can release entries
+// the stream referenced.
+func (d *Decoder) CancelStream(streamID uint64) error {
+	if streamID > math.MaxUint32 {
+		return fmt.Errorf("stream ID %d is too large", streamID)
+	}
+	d.mu.Lock()
+	return d.write(appendInteger(nil, 0x40, streamID, 6))
+}
+
+// sectionDecoder resolves the field line representations of a single
+// field section against its base.
+type sectionDecoder struct {
+	*Decoder
+	ric  uint64
+	base uint64
+}
+
+func (sd *sectionDecoder) dynamic(abs uint64) (hpack.TableEntry, error) {
+	if abs >= sd.ric {
+		return hpack.TableEntry{}, IndexOutOfBounds
+	}
+	te, ok := sd.tbl.get(abs)
+	if !ok {
+		return hpack.TableEntry{}, IndexOutOfBounds
+	}
+	return te, nil
+}
+
+func (sd *sectionDecoder) relative(idx uint32) (hpack.TableEntry, error) {
+	if uint64(idx) >= sd.base {
+		return hpack.TableEntry{}, IndexOutOfBounds
+	}
+	return sd.dynamic(sd.base - 1 - uint64(idx))
+}
+
+func static(idx uint32) (hpack.TableEntry, error) {
+	if int(idx) >= len(StaticTable) {
+		return hpack.TableEntry{}, IndexOutOfBounds
+	}
+	return StaticTable[idx], nil
+}
+
+// next decodes the field line at the start of data.
+func (sd *sectionDecoder) next(data []byte) (Field, int, error) {
+	var (
+		te  hpack.TableEntry
+		n   int
+		err error
+		idx uint32
+	)
+	c := data[0]
+	switch {
+	// Indexed Field Line
+	case c&0x80 != 0:
+		idx, n, err = hpack.DecodeInteger(data, 6)
+		if err != nil {
+			return Field{}, 0, err
+		}
+		if c&0x40 != 0 {
+			te, err = static(idx)
+		} else {
+			te, err = sd.relative(idx)
+		}
+		return Field{te.Key, te.Value}, n, err
+
+	// Literal Field Line with Name Reference
+	case c&0x40 != 0:
+		idx, n, err = hpack.DecodeInteger(data, 4)
+		if err != nil {
+			return Field{}, 0, err
+		}
+		if c&0x10 != 0 {
+			te, err = static(idx)
+		} else {
+			te, err = sd.relative(idx)
+		}
+		if err != nil {
+			return Field{}, 0, err
+		}
+		return sd.literalValue(te.Key, data, n)
+
+	// Literal Field Line with Literal Name
+	case c&0x20 != 0:
+		name, n, err := hpack.DecodeStringPrefix(data, 3)
+		if err != nil {
+			return Field{}, 0, err
+		}
+		return sd.literalValue(string(name), data, n)
+
+	// Indexed Field Line with Post-Base Index
+	case c&0x10 != 0:
+		idx, n, err = hpack.DecodeInteger(data, 4)
+		if err != nil {
+			return Field{}, 0, err
+		}
+		te, err = sd.dynamic(sd.base + uint64(idx))
+		return Field{te.Key, te.Value}, n, err
+
+	// Literal Field Line with Post-Base Name Reference
+	default:
+		idx, n, err = hpack.DecodeInteger(data, 3)
+		if err != nil {
+			return Field{}, 0, err
+		}
+		te, err = sd.dynamic(sd.base + uint64(idx))
+		if err != nil {
+			return Field{}, 0, err
+		}
+		return sd.literalValue(te.Key, data, n)
+	}
+}
+
+func (sd *sectionDecoder) literalValue(name string, data []byte, n int) (Field, int, error) {
+	value, m, err := hpack.DecodeStringPrefix(data[n:], 7)
+	if err != nil {
+		return Field{}, 0, err
+	}
+	return Field{name, string(value)}, n + m, nil
+}
@@ -0,0 +1,139 @@
+package qpack
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return data
+}
+
+// feedEncoderStream processes encoder instructions without ending
+// the encoder stream.
+func feedEncoderStream(t *testing.T, d *Decoder, s string) {
+	require.NoError(t, readInstructions(bytes.NewReader(unhex(t, s)), d.handleEncoderInstruction, d.acknowledgeInserts))
+}
+
+// The examples from RFC 9204 Appendix B, in order. Our decoder
+// acknowledges inserts as soon as it reads them, so its decoder stream
+// carries Insert Count Increments the RFC's decoder leaves out.
+func TestDecoderRFCExamples(t *testing.T) {
+	var decStream bytes.Buffer
+	d := NewDecoder(&decStream, 220, 100)
+	ctx := context.Background()
+
+	takeDecoderStream := func() string {
+		s := hex.EncodeToString(decStream.Bytes())
+		decStream.Reset()
+		return s
+	}
+
+	// B.1 Literal Field Line with Name Reference
+	fields, err := d.DecodeFieldSection(ctx, 0, unhex(t, "0000510b2f696e6465782e68746d6c"))
+	require.NoError(t, err)
+	assert.Equal(t, []Field{{":path", "/index.html"}}, fields)
+	assert.Equal(t, "", takeDecoderStream())
+
+	// B.2 Dynamic Table
+	feedEncoderStream(t, d, "3fbd01c00f7777772e6578616d706c652e636f6dc10c2f73616d706c652f70617468")
+	assert.Equal(t, "02", takeDecoderStream())
+	fields, err = d.DecodeFieldSection(ctx, 4, unhex(t, "03811011"))
+	require.NoError(t, err)
+	assert.Equal(t, []Field{{":authority", "www.example.com"}, {":path", "/sample/path"}}, fields)
+	assert.Equal(t, "84", takeDecoderStream())
+	assert.Equal(t, 106, d.tbl.size)
+
+	// B.3 Speculative Insert
+	feedEncoderStream(t, d, "4a637573746f6d2d6b65790c637573746f6d2d76616c7565")
+	assert.Equal(t, "01", takeDecoderStream())
+	assert.Equal(t, 160, d.tbl.size)
+
+	// B.4 Duplicate Instruction, Stream Cancellation
+	feedEncoderStream(t, d, "02")
+	assert.Equal(t, "01", takeDecoderStream())
+	fields, err = d.DecodeFieldSection(ctx, 8, unhex(t, "050080c181"))
+	require.NoError(t, err)
+	assert.Equal(t, []Field{
+		{":authority", "www.example.com"},
+		{":path", "/"},
+		{"custom-key", "custom-value"},
+	}, fields)
+	assert.Equal(t, "88", takeDecoderStream())
+	assert.NoError(t, d.CancelStream(8))
+	assert.Equal(t, "48", takeDecoderStream())
+	assert.Equal(t, 217, d.tbl.size)
+
+	// B.5 Dynamic Table Insert, Eviction
+	feedEncoderStream(t, d, "810d637573746f6d2d76616c756532")
+	assert.Equal(t, "01", takeDecoderStream())
+	assert.Equal(t, 215, d.tbl.size)
+	assert.EqualValues(t, 1, d.tbl.dropped)
+	te, _ := d.tbl.get(4)
+	assert.Equal(t, "custom-value2", te.Value)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Section string
+		E       error
+	}{
+		{"StaticOutOfBounds", "0000ff24", IndexOutOfBounds},
+		{"DynamicWithoutInserts", "000080", IndexOutOfBounds},
+		{"RequiredCountTooLarge", "ff0a00", InvalidRequiredCount},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d := NewDecoder(&bytes.Buffer{}, 220, 0)
+			_, err := d.DecodeFieldSection(context.Background(), 0, unhex(t, c.Section))
+			var qe *Error
+			require.ErrorAs(t, err, &qe)
+			assert.Equal(t, ErrorCodeDecompressionFailed, qe.Code)
+			assert.ErrorIs(t, err, c.E)
+		})
+	}
+}
+
+func TestDecoderEncoderStreamErrors(t *testing.T) {
+	cases := []struct {
+		Name string
+		Inst string
+		E    error
+	}{
+		{"CapacityExceeded", "3fbe01", CapacityExceeded},
+		{"EntryTooLarge", "3f0140" + "0161" + "7f00" + hex.EncodeToString(make([]byte, 127)), EntryTooLarge},
+		{"DuplicateEmptyTable", "00", IndexOutOfBounds},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d := NewDecoder(&bytes.Buffer{}, 220, 0)
+			err := d.ReadEncoderStream(bytes.NewReader(unhex(t, c.Inst)))
+			var qe *Error
+			require.ErrorAs(t, err, &qe)
+			assert.Equal(t, ErrorCodeEncoderStream, qe.Code)
+			assert.ErrorIs(t, err, c.E)
+		})
+	}
+}
+
+func TestRequiredInsertCountWraps(t *testing.T) {
+	const maxEntries = 6
+	for total := uint64(0); total < 40; total++ {
+		// A section can require any insert up to the decoder's
+		// current count plus the entries it hasn't received.
+		for ric := max(total, maxEntries) - maxEntries + 1; ric <= total+maxEntries; ric++ {
+			enc := encodeRequiredInsertCount(ric, maxEntries)
+			dec, err := decodeRequiredInsertCount(enc, maxEntries, total)
+			assert.NoError(t, err)
+			assert.Equal(t, ric, dec, "total %d", total)
+		}
+	}
+}
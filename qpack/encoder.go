@@ -0,0 +1,354 @@
+package qpack
+
+import (
+	"fmt"
+	"http2/hpack"
+	"io"
+	"math"
+	"strings"
+	"sync"
+)
+
+// A section is a field section the decoder hasn't acknowledged yet.
+type section struct {
+	requiredInsertCount uint64
+
+	// The smallest absolute index the section references. Entries
+	// at or above it can't be evicted until the section is acked.
+	minRef uint64
+}
+
+// An Encoder compresses field sections for one connection. Dynamic
+// table instructions are written to the encoder stream, and the peer's
+// decoder stream must be fed to ReadDecoderStream so the encoder learns
+// which entries are safe to reference and evict.
+type Encoder struct {
+	// mu guards table state. wlock serializes writes to the encoder
+	// stream; it's acquired before mu is released, so instructions
+	// are written in the order they changed the table.
+	mu     *sync.Mutex
+	wlock  *sync.Mutex
+	stream io.Writer
+
+	tbl dynamicTable
+
+	// The peer decoder's SETTINGS_QPACK_MAX_TABLE_CAPACITY and
+	// SETTINGS_QPACK_BLOCKED_STREAMS.
+	maxTableCapacity  uint64
+	maxBlockedStreams int
+
+	// Number of inserts the decoder is known to have received.
+	knownReceived uint64
+
+	// Unacknowledged sections by stream, oldest first.
+	pending map[uint64][]section
+
+	// Policy chooses whether a field is inserted into the dynamic
+	// table (hpack.IncrementalIndex), only referenced from it
+	// (hpack.NoIndex), or always sent as a literal that
+	// intermediaries must not index (hpack.NeverIndex). If nil,
+	// hpack.DefaultIndexPolicy is used.
+	Policy func(k, v string) hpack.LiteralIndexType
+}
+
+func NewEncoder(stream io.Writer, maxTableCapacity uint64, maxBlockedStreams int) *Encoder {
+	return &Encoder{
+		mu:                new(sync.Mutex),
+		wlock:             new(sync.Mutex),
+		stream:            stream,
+		maxTableCapacity:  maxTableCapacity,
+		maxBlockedStreams: maxBlockedStreams,
+		pending:           make(map[uint64][]section),
+	}
+}
+
+// write sends encoder stream instructions, releasing mu once the
+// write lock is held.
+func (e *Encoder) write(inst []byte) error {
+	e.wlock.Lock()
+	e.mu.Unlock()
+	defer e.wlock.Unlock()
+	if len(inst) == 0 {
+		return nil
+	}
+	_, err := e.stream.Write(inst)
+	return err
+}
+
+// evictLimit is the smallest absolute index that may not be evicted:
+// entries referenced by unacknowledged sections, and entries the
+// decoder may not have received yet.
+func (e *Encoder) evictLimit() uint64 {
+	limit := e.knownReceived
+	for _, sections := range e.pending {
+		for _, s := range sections {
+			limit = min(limit, s.minRef)
+		}
+	}
+	return limit
+}
+
+// blockedStreams counts the streams with sections that reference
+// entries the decoder might not have yet.
+func (e *Encoder) blockedStreams() (n int, blocked map[uint64]bool) {
+	blocked = make(map[uint64]bool)
+	for sid, sections := range e.pending {
+		for _, s := range sections {
+			if s.requiredInsertCount > e.knownReceived {
+				blocked[sid] = true
+				n += 1
+				break
+			}
+		}
+	}
+	return
+}
+
+// SetCapacity changes the dynamic table capacity, which starts at 0.
+// It fails if the capacity exceeds the decoder's maximum or if
+// shrinking would evict entries that are still in use.
+func (e *Encoder) SetCapacity(c uint64) error {
+	e.mu.Lock()
+	if c > e.maxTableCapacity {
+		e.mu.Unlock()
+		return CapacityExceeded
+	}
+	if !e.tbl.shrinkable(int(c), e.evictLimit()) {
+		e.mu.Unlock()
+		return fmt.Errorf("entries in use prevent shrinking the table to %d", c)
+	}
+	e.tbl.setCapacity(int(c))
+	return e.write(appendInteger(nil, 0x20, c, 5))
+}
+
+// sectionEncoder accumulates the representations and encoder stream
+// instructions for a single field section.
+type sectionEncoder struct {
+	*Encoder
+	base     uint64
+	canBlock bool
+
+	ric    uint64
+	minRef uint64
+
+	inst []byte
+	body []byte
+}
+
+func (se *sectionEncoder) limit() uint64 {
+	return min(se.evictLimit(), se.minRef)
+}
+
+// referenceable reports whether abs can be referenced without
+// blocking, or blocking is allowed.
+func (se *sectionEncoder) referenceable(abs uint64) bool {
+	return abs < se.knownReceived || se.canBlock
+}
+
+func (se *sectionEncoder) reference(abs uint64) {
+	se.ric = max(se.ric, abs+1)
+	se.minRef = min(se.minRef, abs)
+}
+
+// insert emits an insert instruction for the field, referencing a
+// name in the static or dynamic table when possible.
+func (se *sectionEncoder) insert(f Field, staticIdx int, nameAbs uint64, haveName bool) (uint64, bool) {
+	limit := se.limit()
+	// The entry supplying the name can't be evicted to make room,
+	// but the name can be sent as a literal instead.
+	if haveName && !se.tbl.evictable(f.size(), min(limit, nameAbs)) {
+		haveName = false
+	}
+	if !se.tbl.evictable(f.size(), limit) {
+		return 0, false
+	}
+	switch {
+	case staticIdx >= 0:
+		se.inst = appendInteger(se.inst, 0xc0, uint64(staticIdx), 6)
+	case haveName:
+		se.inst = appendInteger(se.inst, 0x80, se.tbl.insertCount()-1-nameAbs, 6)
+	default:
+		se.inst = appendString(se.inst, 0x40, f.Name, 5)
+	}
+	se.inst = appendString(se.inst, 0, f.Value, 7)
+	abs, _ := se.tbl.insert(hpack.TableEntry{Key: f.Name, Value: f.Value})
+	return abs, true
+}
+
+// duplicate re-inserts an existing entry so it won't be evicted soon.
+func (se *sectionEncoder) duplicate(abs uint64) (uint64, bool) {
+	te, _ := se.tbl.get(abs)
+	if !se.tbl.evictable(te.Size(), min(se.limit(), abs)) {
+		return 0, false
+	}
+	se.inst = appendInteger(se.inst, 0x00, se.tbl.insertCount()-1-abs, 5)
+	newAbs, _ := se.tbl.insert(te)
+	return newAbs, true
+}
+
+func (se *sectionEncoder) indexed(abs uint64) {
+	se.reference(abs)
+	if abs < se.base {
+		se.body = appendInteger(se.body, 0x80, se.base-1-abs, 6)
+	} else {
+		se.body = appendInteger(se.body, 0x10, abs-se.base, 4)
+	}
+}
+
+func (se *sectionEncoder) literalDynamicName(f Field, abs uint64, never uint8) {
+	se.reference(abs)
+	if abs < se.base {
+		se.body = appendInteger(se.body, 0x40|never<<5, se.base-1-abs, 4)
+	} else {
+		se.body = appendInteger(se.body, never<<3, abs-se.base, 3)
+	}
+	se.body = appendString(se.body, 0, f.Value, 7)
+}
+
+func (se *sectionEncoder) field(f Field) {
+	policy := se.Policy
+	if policy == nil {
+		policy = hpack.DefaultIndexPolicy
+	}
+	typ := policy(f.Name, f.Value)
+
+	staticIdx, staticJustKey := findStatic(f.Name, f.Value)
+	if staticIdx >= 0 && !staticJustKey {
+		se.body = appendInteger(se.body, 0xc0, uint64(staticIdx), 6)
+		return
+	}
+
+	var never uint8
+	if typ == hpack.NeverIndex {
+		never = 1
+	}
+	abs, justKey, ok := se.tbl.find(f.Name, f.Value)
+	if typ != hpack.NeverIndex {
+		if ok && !justKey && se.referenceable(abs) {
+			if se.tbl.draining(abs) && se.tbl.size > se.tbl.capacity*3/4 {
+				if dup, ok := se.duplicate(abs); ok && se.referenceable(dup) {
+					abs = dup
+				}
+			}
+			se.indexed(abs)
+			return
+		}
+		if typ == hpack.IncrementalIndex && !(ok && !justKey) {
+			nameAbs, haveName := abs, ok && staticIdx < 0
+			if newAbs, ok := se.insert(f, staticIdx, nameAbs, haveName); ok {
+				if se.referenceable(newAbs) {
+					se.indexed(newAbs)
+					return
+				}
+			}
+		}
+	}
+
+	// Send a literal, with as much of the name indexed as possible.
+	switch {
+	case staticIdx >= 0:
+		se.body = appendInteger(se.body, 0x50|never<<5, uint64(staticIdx), 4)
+		se.body = appendString(se.body, 0, f.Value, 7)
+	case ok && abs >= se.tbl.dropped && se.referenceable(abs):
+		se.literalDynamicName(f, abs, never)
+	default:
+		se.body = appendString(se.body, 0x20|never<<4, f.Name, 3)
+		se.body = appendString(se.body, 0, f.Value, 7)
+	}
+}
+
+// EncodeFieldSection encodes fields for the given stream, writing any
+// dynamic table instructions to the encoder stream before returning.
+// Names are lowercased.
+func (e *Encoder) EncodeFieldSection(streamID uint64, fields []Field) ([]byte, error) {
+	e.mu.Lock()
+
+	nBlocked, blocked := e.blockedStreams()
+	se := sectionEncoder{
+		Encoder:  e,
+		base:     e.tbl.insertCount(),
+		canBlock: blocked[streamID] || nBlocked < e.maxBlockedStreams,
+		minRef:   math.MaxUint64,
+	}
+	for _, f := range fields {
+		f.Name = strings.ToLower(f.Name)
+		se.field(f)
+	}
+
+	// Field section prefix (RFC 9204 §4.5.1)
+	var prefix []byte
+	if se.ric == 0 {
+		prefix = []byte{0, 0}
+	} else {
+		maxEntries := e.maxTableCapacity / 32
+		prefix = appendInteger(prefix, 0, encodeRequiredInsertCount(se.ric, maxEntries), 8)
+		if se.base >= se.ric {
+			prefix = appendInteger(prefix, 0, se.base-se.ric, 7)
+		} else {
+			prefix = appendInteger(prefix, 0x80, se.ric-se.base-1, 7)
+		}
+		e.pending[streamID] = append(e.pending[streamID], section{se.ric, se.minRef})
+	}
+
+	if err := e.write(se.inst); err != nil {
+		return nil, err
+	}
+	return append(prefix, se.body...), nil
+}
+
+// ReadDecoderStream processes the peer's decoder stream until it
+// ends or carries an invalid instruction.
+func (e *Encoder) ReadDecoderStream(r io.Reader) error {
+	err := readInstructions(r, e.handleDecoderInstruction, nil)
+	if err != nil {
+		return &Error{ErrorCodeDecoderStream, err}
+	}
+	return nil
+}
+
+func (e *Encoder) handleDecoderInstruction(data []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := data[0]
+	switch {
+	// Section Acknowledgment
+	case c&0x80 != 0:
+		sid, n, err := hpack.DecodeInteger(data, 7)
+		if err != nil {
+			return 0, err
+		}
+		sections := e.pending[uint64(sid)]
+		if len(sections) == 0 {
+			return 0, UnexpectedAcknowledged
+		}
+		e.knownReceived = max(e.knownReceived, sections[0].requiredInsertCount)
+		if len(sections) == 1 {
+			delete(e.pending, uint64(sid))
+		} else {
+			e.pending[uint64(sid)] = sections[1:]
+		}
+		return n, nil
+
+	// Stream Cancellation
+	case c&0x40 != 0:
+		sid, n, err := hpack.DecodeInteger(data, 6)
+		if err != nil {
+			return 0, err
+		}
+		delete(e.pending, uint64(sid))
+		return n, nil
+
+	// Insert Count Increment
+	default:
+		inc, n, err := hpack.DecodeInteger(data, 6)
+		if err != nil {
+			return 0, err
+		}
+		if inc == 0 || e.knownReceived+uint64(inc) > e.tbl.insertCount() {
+			return 0, InsertCountExceeded
+		}
+		e.knownReceived += uint64(inc)
+		return n, nil
+	}
+}
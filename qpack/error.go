@@ -0,0 +1,54 @@
+package qpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is an HTTP/3 application error code used to close the
+// connection when QPACK state can no longer be trusted.
+type ErrorCode uint64
+
+const (
+	ErrorCodeDecompressionFailed ErrorCode = 0x200
+	ErrorCodeEncoderStream       ErrorCode = 0x201
+	ErrorCodeDecoderStream       ErrorCode = 0x202
+)
+
+func (ec ErrorCode) String() string {
+	switch ec {
+	case ErrorCodeDecompressionFailed:
+		return "QPACK_DECOMPRESSION_FAILED"
+	case ErrorCodeEncoderStream:
+		return "QPACK_ENCODER_STREAM_ERROR"
+	case ErrorCodeDecoderStream:
+		return "QPACK_DECODER_STREAM_ERROR"
+	}
+	return fmt.Sprintf("ErrorCode(%#x)", uint64(ec))
+}
+
+var (
+	IndexOutOfBounds       = errors.New("reference to an entry outside the table")
+	CapacityExceeded       = errors.New("table capacity exceeds the negotiated maximum")
+	EntryTooLarge          = errors.New("entry is larger than the table capacity")
+	InvalidRequiredCount   = errors.New("invalid required insert count")
+	TooManyBlockedStreams  = errors.New("too many blocked streams")
+	UnexpectedAcknowledged = errors.New("acknowledgement for a section that wasn't sent")
+	InsertCountExceeded    = errors.New("insert count increment past the number of inserts")
+	DecoderClosed          = errors.New("decoder closed")
+)
+
+// An Error reports a QPACK failure that must be treated as a
+// connection error with the given code.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,192 @@
+package qpack
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connect joins an encoder and decoder with in-memory encoder and
+// decoder streams, as a pair of unidirectional QUIC streams would.
+func connect(t *testing.T, capacity uint64, blocked int) (*Encoder, *Decoder) {
+	encR, encW := io.Pipe()
+	decR, decW := io.Pipe()
+	enc := NewEncoder(encW, capacity, blocked)
+	dec := NewDecoder(decW, capacity, blocked)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		dec.ReadEncoderStream(encR)
+		done <- struct{}{}
+	}()
+	go func() {
+		enc.ReadDecoderStream(decR)
+		done <- struct{}{}
+	}()
+	t.Cleanup(func() {
+		encW.Close()
+		decW.Close()
+		<-done
+		<-done
+	})
+	require.NoError(t, enc.SetCapacity(capacity))
+	return enc, dec
+}
+
+// waitForAcks waits until the encoder has learned about every insert.
+func waitForAcks(t *testing.T, enc *Encoder) {
+	assert.Eventually(t, func() bool {
+		enc.mu.Lock()
+		defer enc.mu.Unlock()
+		return enc.knownReceived == enc.tbl.insertCount()
+	}, time.Second, time.Millisecond)
+}
+
+var requestFields = []Field{
+	{":method", "GET"},
+	{":scheme", "https"},
+	{":authority", "example.com"},
+	{":path", "/users/1"},
+	{"user-agent", "qpack-test/1.0"},
+	{"authorization", "Bearer secret"},
+	{"x-custom", "one"},
+	{"x-custom", "two"},
+}
+
+func TestRoundTrip(t *testing.T) {
+	enc, dec := connect(t, 4096, 16)
+	ctx := context.Background()
+
+	var inserts []uint64
+	for i := range 3 {
+		sid := uint64(4 * i)
+		section, err := enc.EncodeFieldSection(sid, requestFields)
+		require.NoError(t, err)
+
+		fields, err := dec.DecodeFieldSection(ctx, sid, section)
+		require.NoError(t, err)
+		assert.Equal(t, requestFields, fields)
+		waitForAcks(t, enc)
+		inserts = append(inserts, enc.tbl.insertCount())
+	}
+	// Later sections reference the entries the first one inserted.
+	assert.Equal(t, []uint64{4, 4, 4}, inserts)
+
+	// Never-indexed fields stay out of the dynamic table.
+	_, _, ok := enc.tbl.find("authorization", "Bearer secret")
+	assert.False(t, ok)
+}
+
+func TestRoundTripEviction(t *testing.T) {
+	// Room for only a couple of entries, so the encoder has to evict
+	// (and wait for acknowledgements before it may).
+	enc, dec := connect(t, 128, 16)
+	ctx := context.Background()
+
+	for i := range 20 {
+		fields := []Field{
+			{":method", "GET"},
+			{"x-counter", string(rune('a' + i))},
+			{"x-stable", "value"},
+		}
+		sid := uint64(4 * i)
+		section, err := enc.EncodeFieldSection(sid, fields)
+		require.NoError(t, err)
+		decoded, err := dec.DecodeFieldSection(ctx, sid, section)
+		require.NoError(t, err)
+		assert.Equal(t, fields, decoded)
+		if i%3 == 0 {
+			waitForAcks(t, enc)
+		}
+	}
+	assert.LessOrEqual(t, enc.tbl.size, 128)
+	assert.Greater(t, enc.tbl.dropped, uint64(0))
+}
+
+func TestBlockedStream(t *testing.T) {
+	// Hold the encoder stream back so the section arrives first.
+	var encStream bytes.Buffer
+	var decStream bytes.Buffer
+	enc := NewEncoder(&encStream, 4096, 1)
+	dec := NewDecoder(&decStream, 4096, 1)
+	require.NoError(t, enc.SetCapacity(4096))
+
+	fields := []Field{{"x-blocked", "yes"}}
+	section, err := enc.EncodeFieldSection(0, fields)
+	require.NoError(t, err)
+
+	type result struct {
+		fields []Field
+		err    error
+	}
+	resC := make(chan result)
+	go func() {
+		f, err := dec.DecodeFieldSection(context.Background(), 0, section)
+		resC <- result{f, err}
+	}()
+
+	assert.Eventually(t, func() bool {
+		dec.mu.Lock()
+		defer dec.mu.Unlock()
+		return dec.blocked == 1
+	}, time.Second, time.Millisecond)
+
+	// A second blocked stream exceeds SETTINGS_QPACK_BLOCKED_STREAMS.
+	_, err = dec.DecodeFieldSection(context.Background(), 4, section)
+	assert.ErrorIs(t, err, TooManyBlockedStreams)
+
+	require.NoError(t, readInstructions(&encStream, dec.handleEncoderInstruction, dec.acknowledgeInserts))
+	res := <-resC
+	require.NoError(t, res.err)
+	assert.Equal(t, fields, res.fields)
+
+	// Insert Count Increment, then Section Acknowledgment
+	assert.Equal(t, []byte{0x01, 0x80}, decStream.Bytes())
+}
+
+func TestBlockedStreamCancelled(t *testing.T) {
+	var encStream bytes.Buffer
+	enc := NewEncoder(&encStream, 4096, 1)
+	dec := NewDecoder(io.Discard, 4096, 1)
+	require.NoError(t, enc.SetCapacity(4096))
+
+	section, err := enc.EncodeFieldSection(0, []Field{{"x-blocked", "yes"}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = dec.DecodeFieldSection(ctx, 0, section)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestEncoderNoBlocking(t *testing.T) {
+	// With no blocked streams allowed, unacknowledged entries can't be
+	// referenced, so the section must decode without the encoder stream.
+	var encStream bytes.Buffer
+	enc := NewEncoder(&encStream, 4096, 0)
+	dec := NewDecoder(io.Discard, 4096, 0)
+	require.NoError(t, enc.SetCapacity(4096))
+
+	fields := []Field{{"x-first", "1"}, {"x-first", "1"}}
+	section, err := enc.EncodeFieldSection(0, fields)
+	require.NoError(t, err)
+	assert.Positive(t, encStream.Len())
+
+	decoded, err := dec.DecodeFieldSection(context.Background(), 0, section)
+	require.NoError(t, err)
+	assert.Equal(t, fields, decoded)
+}
+
+func TestEncoderDecoderStreamErrors(t *testing.T) {
+	enc := NewEncoder(io.Discard, 4096, 0)
+	err := enc.ReadDecoderStream(bytes.NewReader([]byte{0x84}))
+	assert.ErrorIs(t, err, UnexpectedAcknowledged)
+
+	err = enc.ReadDecoderStream(bytes.NewReader([]byte{0x01}))
+	assert.ErrorIs(t, err, InsertCountExceeded)
+}
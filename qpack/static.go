@@ -0,0 +1,122 @@
+package qpack
+
+import "http2/hpack"
+
+// StaticTable is the QPACK static table from RFC 9204 Appendix A.
+// Unlike HPACK, QPACK static indices start at 0.
+var StaticTable = []hpack.TableEntry{
+	{Key: ":authority", Value: ""},
+	{Key: ":path", Value: "/"},
+	{Key: "age", Value: "0"},
+	{Key: "content-disposition", Value: ""},
+	{Key: "content-length", Value: "0"},
+	{Key: "cookie", Value: ""},
+	{Key: "date", Value: ""},
+	{Key: "etag", Value: ""},
+	{Key: "if-modified-since", Value: ""},
+	{Key: "if-none-match", Value: ""},
+	{Key: "last-modified", Value: ""},
+	{Key: "link", Value: ""},
+	{Key: "location", Value: ""},
+	{Key: "referer", Value: ""},
+	{Key: "set-cookie", Value: ""},
+	{Key: ":method", Value: "CONNECT"},
+	{Key: ":method", Value: "DELETE"},
+	{Key: ":method", Value: "GET"},
+	{Key: ":method", Value: "HEAD"},
+	{Key: ":method", Value: "OPTIONS"},
+	{Key: ":method", Value: "POST"},
+	{Key: ":method", Value: "PUT"},
+	{Key: ":scheme", Value: "http"},
+	{Key: ":scheme", Value: "https"},
+	{Key: ":status", Value: "103"},
+	{Key: ":status", Value: "200"},
+	{Key: ":status", Value: "304"},
+	{Key: ":status", Value: "404"},
+	{Key: ":status", Value: "503"},
+	{Key: "accept", Value: "*/*"},
+	{Key: "accept", Value: "application/dns-message"},
+	{Key: "accept-encoding", Value: "gzip, deflate, br"},
+	{Key: "accept-ranges", Value: "bytes"},
+	{Key: "access-control-allow-headers", Value: "cache-control"},
+	{Key: "access-control-allow-headers", Value: "content-type"},
+	{Key: "access-control-allow-origin", Value: "*"},
+	{Key: "cache-control", Value: "max-age=0"},
+	{Key: "cache-control", Value: "max-age=2592000"},
+	{Key: "cache-control", Value: "max-age=604800"},
+	{Key: "cache-control", Value: "no-cache"},
+	{Key: "cache-control", Value: "no-store"},
+	{Key: "cache-control", Value: "public, max-age=31536000"},
+	{Key: "content-encoding", Value: "br"},
+	{Key: "content-encoding", Value: "gzip"},
+	{Key: "content-type", Value: "application/dns-message"},
+	{Key: "content-type", Value: "application/javascript"},
+	{Key: "content-type", Value: "application/json"},
+	{Key: "content-type", Value: "application/x-www-form-urlencoded"},
+	{Key: "content-type", Value: "image/gif"},
+	{Key: "content-type", Value: "image/jpeg"},
+	{Key: "content-type", Value: "image/png"},
+	{Key: "content-type", Value: "text/css"},
+	{Key: "content-type", Value: "text/html; charset=utf-8"},
+	{Key: "content-type", Value: "text/plain"},
+	{Key: "content-type", Value: "text/plain;charset=utf-8"},
+	{Key: "range", Value: "bytes=0-"},
+	{Key: "strict-transport-security", Value: "max-age=31536000"},
+	{Key: "strict-transport-security", Value: "max-age=31536000; includesubdomains"},
+	{Key: "strict-transport-security", Value: "max-age=31536000; includesubdomains; preload"},
+	{Key: "vary", Value: "accept-encoding"},
+	{Key: "vary", Value: "origin"},
+	{Key: "x-content-type-options", Value: "nosniff"},
+	{Key: "x-xss-protection", Value: "1; mode=block"},
+	{Key: ":status", Value: "100"},
+	{Key: ":status", Value: "204"},
+	{Key: ":status", Value: "206"},
+	{Key: ":status", Value: "302"},
+	{Key: ":status", Value: "400"},
+	{Key: ":status", Value: "403"},
+	{Key: ":status", Value: "421"},
+	{Key: ":status", Value: "425"},
+	{Key: ":status", Value: "500"},
+	{Key: "accept-language", Value: ""},
+	{Key: "access-control-allow-credentials", Value: "FALSE"},
+	{Key: "access-control-allow-credentials", Value: "TRUE"},
+	{Key: "access-control-allow-headers", Value: "*"},
+	{Key: "access-control-allow-methods", Value: "get"},
+	{Key: "access-control-allow-methods", Value: "get, post, options"},
+	{Key: "access-control-allow-methods", Value: "options"},
+	{Key: "access-control-expose-headers", Value: "content-length"},
+	{Key: "access-control-request-headers", Value: "content-type"},
+	{Key: "access-control-request-method", Value: "get"},
+	{Key: "access-control-request-method", Value: "post"},
+	{Key: "alt-svc", Value: "clear"},
+	{Key: "authorization", Value: ""},
+	{Key: "content-security-policy", Value: "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{Key: "early-data", Value: "1"},
+	{Key: "expect-ct", Value: ""},
+	{Key: "forwarded", Value: ""},
+	{Key: "if-range", Value: ""},
+	{Key: "origin", Value: ""},
+	{Key: "purpose", Value: "prefetch"},
+	{Key: "server", Value: ""},
+	{Key: "timing-allow-origin", Value: "*"},
+	{Key: "upgrade-insecure-requests", Value: "1"},
+	{Key: "user-agent", Value: ""},
+	{Key: "x-forwarded-for", Value: ""},
+	{Key: "x-frame-options", Value: "deny"},
+	{Key: "x-frame-options", Value: "sameorigin"},
+}
+
+// findStatic returns the static index of an exact match, or else the
+// first entry with a matching name.
+func findStatic(k, v string) (idx int, justKey bool) {
+	idx = -1
+	for i, te := range StaticTable {
+		if te.Key == k && te.Value == v {
+			return i, false
+		} else if te.Key == k && idx == -1 {
+			idx = i
+			justKey = true
+		}
+	}
+	return
+}
@@ -0,0 +1,74 @@
+package qpack
+
+import (
+	"errors"
+	"http2/hpack"
+	"io"
+)
+
+// A Field is a single header or trailer field.
+type Field struct {
+	Name  string
+	Value string
+}
+
+func (f Field) size() int {
+	return hpack.TableEntry{Key: f.Name, Value: f.Value}.Size()
+}
+
+// truncated reports whether err means the instruction being parsed
+// continues past the end of the buffered data.
+func truncated(err error) bool {
+	return errors.Is(err, hpack.IntegerTruncated) || errors.Is(err, hpack.StringTruncated)
+}
+
+// readInstructions reads a unidirectional encoder or decoder stream,
+// calling parse on the buffered data until it reports that the next
+// instruction is incomplete. parse returns the number of octets it
+// consumed. After each read's worth of instructions has been handled,
+// idle is called (if non-nil), which is when a decoder acknowledges
+// inserts.
+func readInstructions(r io.Reader, parse func([]byte) (int, error), idle func() error) error {
+	var buf []byte
+	chunk := make([]byte, 4096)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		for len(buf) > 0 {
+			used, perr := parse(buf)
+			if truncated(perr) {
+				break
+			} else if perr != nil {
+				return perr
+			}
+			buf = buf[used:]
+		}
+		if n > 0 && idle != nil {
+			if ierr := idle(); ierr != nil {
+				return ierr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			if len(buf) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// appendInteger appends n with the given prefix length, ORing
+// flags into the first octet.
+func appendInteger(buf []byte, flags uint8, n uint64, prefixLength int) []byte {
+	data := hpack.EncodeInteger(uint32(n), prefixLength)
+	data[0] |= flags
+	return append(buf, data...)
+}
+
+func appendString(buf []byte, flags uint8, s string, prefixLength int) []byte {
+	data := hpack.EncodeStringPrefix([]byte(s), prefixLength)
+	data[0] |= flags
+	return append(buf, data...)
+}
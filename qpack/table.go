@@ -0,0 +1,151 @@
+package qpack
+
+import (
+	"http2/hpack"
+)
+
+// A dynamicTable holds QPACK dynamic table entries. Unlike HPACK's
+// table, entries are addressed by an absolute index: the first entry
+// ever inserted is 0, and indices don't shift as entries are evicted.
+type dynamicTable struct {
+	// Entries currently in the table, oldest first.
+	entries []hpack.TableEntry
+
+	// Number of entries evicted so far, which is the absolute
+	// index of entries[0].
+	dropped uint64
+
+	size     int
+	capacity int
+}
+
+// insertCount is the total number of entries ever inserted.
+func (dt *dynamicTable) insertCount() uint64 {
+	return dt.dropped + uint64(len(dt.entries))
+}
+
+func (dt *dynamicTable) get(abs uint64) (hpack.TableEntry, bool) {
+	if abs < dt.dropped || abs >= dt.insertCount() {
+		return hpack.TableEntry{}, false
+	}
+	return dt.entries[abs-dt.dropped], true
+}
+
+func (dt *dynamicTable) evict() {
+	dt.size -= dt.entries[0].Size()
+	dt.entries = dt.entries[1:]
+	dt.dropped += 1
+}
+
+// evictable reports whether room for an entry of size s can be made
+// by evicting only entries with absolute indices below limit.
+func (dt *dynamicTable) evictable(s int, limit uint64) bool {
+	if s > dt.capacity {
+		return false
+	}
+	free := dt.capacity - dt.size
+	for i := 0; free < s; i++ {
+		if i >= len(dt.entries) || dt.dropped+uint64(i) >= limit {
+			return false
+		}
+		free += dt.entries[i].Size()
+	}
+	return true
+}
+
+// shrinkable reports whether the table can shrink to capacity c by
+// evicting only entries with absolute indices below limit.
+func (dt *dynamicTable) shrinkable(c int, limit uint64) bool {
+	size := dt.size
+	for i := 0; size > c; i++ {
+		if dt.dropped+uint64(i) >= limit {
+			return false
+		}
+		size -= dt.entries[i].Size()
+	}
+	return true
+}
+
+// insert adds an entry, evicting the oldest entries to make room.
+// It returns the new entry's absolute index.
+func (dt *dynamicTable) insert(te hpack.TableEntry) (uint64, error) {
+	s := te.Size()
+	if s > dt.capacity {
+		return 0, EntryTooLarge
+	}
+	for dt.size+s > dt.capacity {
+		dt.evict()
+	}
+	dt.entries = append(dt.entries, te)
+	dt.size += s
+	return dt.insertCount() - 1, nil
+}
+
+func (dt *dynamicTable) setCapacity(c int) {
+	dt.capacity = c
+	for dt.size > dt.capacity {
+		dt.evict()
+	}
+}
+
+// find returns the absolute index of the newest entry matching both
+// k and v, or failing that, the newest entry matching k.
+func (dt *dynamicTable) find(k, v string) (abs uint64, justKey bool, ok bool) {
+	for i := len(dt.entries) - 1; i >= 0; i-- {
+		te := dt.entries[i]
+		if te.Key != k {
+			continue
+		}
+		if te.Value == v {
+			return dt.dropped + uint64(i), false, true
+		} else if !ok {
+			abs, justKey, ok = dt.dropped+uint64(i), true, true
+		}
+	}
+	return
+}
+
+// draining reports whether abs is among the oldest entries filling
+// the first quarter of the table, which will be evicted soonest.
+func (dt *dynamicTable) draining(abs uint64) bool {
+	used := 0
+	for i, te := range dt.entries {
+		used += te.Size()
+		if dt.dropped+uint64(i) == abs {
+			return used <= dt.capacity/4
+		}
+	}
+	return false
+}
+
+// encodeRequiredInsertCount and decodeRequiredInsertCount implement
+// the modular encoding from RFC 9204 §4.5.1.1.
+func encodeRequiredInsertCount(ric, maxEntries uint64) uint64 {
+	if ric == 0 {
+		return 0
+	}
+	return ric%(2*maxEntries) + 1
+}
+
+func decodeRequiredInsertCount(enc, maxEntries, totalInserts uint64) (uint64, error) {
+	if enc == 0 {
+		return 0, nil
+	}
+	fullRange := 2 * maxEntries
+	if enc > fullRange {
+		return 0, InvalidRequiredCount
+	}
+	maxValue := totalInserts + maxEntries
+	maxWrapped := (maxValue / fullRange) * fullRange
+	ric := maxWrapped + enc - 1
+	if ric > maxValue {
+		if ric <= fullRange {
+			return 0, InvalidRequiredCount
+		}
+		ric -= fullRange
+	}
+	if ric == 0 {
+		return 0, InvalidRequiredCount
+	}
+	return ric, nil
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+)
+
+type segmentKind uint8
+
+// Kinds are ordered by precedence: when several patterns match
+// a path, the one with the most specific segments wins.
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentWildcard
+)
+
+type segment struct {
+	kind segmentKind
+	// The literal text, or the parameter name.
+	value string
+}
+
+// A pattern is a parsed route path such as /users/{id}/files/{path...}.
+type pattern struct {
+	raw      string
+	segments []segment
+}
+
+// parsePattern splits a route path into segments. {name} matches one
+// segment, and {name...} or a trailing * matches the rest of the path.
+func parsePattern(raw string) (*pattern, error) {
+	if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("pattern %q doesn't start with /", raw)
+	}
+	p := &pattern{raw: raw}
+	names := make(map[string]bool)
+	parts := strings.Split(raw[1:], "/")
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "*":
+			if !last {
+				return nil, fmt.Errorf("pattern %q: * must be the last segment", raw)
+			}
+			p.segments = append(p.segments, segment{segmentWildcard, "*"})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			kind := segmentParam
+			if n, ok := strings.CutSuffix(name, "..."); ok {
+				if !last {
+					return nil, fmt.Errorf("pattern %q: %s must be the last segment", raw, part)
+				}
+				name, kind = n, segmentWildcard
+			}
+			if name == "" || strings.ContainsAny(name, "{}") {
+				return nil, fmt.Errorf("pattern %q: bad parameter %s", raw, part)
+			}
+			if names[name] {
+				return nil, fmt.Errorf("pattern %q: duplicate parameter %q", raw, name)
+			}
+			names[name] = true
+			p.segments = append(p.segments, segment{kind, name})
+		case strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("pattern %q: bad segment %q", raw, part)
+		default:
+			p.segments = append(p.segments, segment{segmentLiteral, part})
+		}
+	}
+	return p, nil
+}
+
+// match reports whether path matches, and the parameter values if so.
+func (p *pattern) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	rest := path[1:]
+	var values map[string]string
+	set := func(k, v string) {
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[k] = v
+	}
+	for i, seg := range p.segments {
+		if seg.kind == segmentWildcard {
+			set(seg.value, rest)
+			return values, true
+		}
+		part, next, found := strings.Cut(rest, "/")
+		if !found && i != len(p.segments)-1 {
+			return nil, false
+		}
+		if found && i == len(p.segments)-1 {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentLiteral:
+			if part != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if part == "" {
+				return nil, false
+			}
+			set(seg.value, part)
+		}
+		rest = next
+	}
+	return values, true
+}
+
+// moreSpecific reports whether p should be preferred over q when
+// both match the same path.
+func (p *pattern) moreSpecific(q *pattern) bool {
+	for i := 0; i < len(p.segments) && i < len(q.segments); i++ {
+		if p.segments[i].kind != q.segments[i].kind {
+			return p.segments[i].kind < q.segments[i].kind
+		}
+	}
+	return len(p.segments) > len(q.segments)
+}
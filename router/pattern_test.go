@@ -0,0 +1,63 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		Pattern string
+		Path    string
+		Values  map[string]string
+		Match   bool
+	}{
+		{"/", "/", nil, true},
+		{"/", "/a", nil, false},
+		{"/users", "/users", nil, true},
+		{"/users", "/users/", nil, false},
+		{"/users/", "/users/", nil, true},
+		{"/users/{id}", "/users/7", map[string]string{"id": "7"}, true},
+		{"/users/{id}", "/users/", nil, false},
+		{"/users/{id}", "/users/7/posts", nil, false},
+		{"/users/{id}/posts/{post}", "/users/7/posts/9", map[string]string{"id": "7", "post": "9"}, true},
+		{"/static/{path...}", "/static/css/site.css", map[string]string{"path": "css/site.css"}, true},
+		{"/static/{path...}", "/static/", map[string]string{"path": ""}, true},
+		{"/static/{path...}", "/static", nil, false},
+		{"/files/*", "/files/a/b", map[string]string{"*": "a/b"}, true},
+	}
+	for _, c := range cases {
+		t.Run(c.Pattern+" "+c.Path, func(t *testing.T) {
+			p, err := parsePattern(c.Pattern)
+			require.NoError(t, err)
+			values, ok := p.match(c.Path)
+			assert.Equal(t, c.Match, ok)
+			assert.Equal(t, c.Values, values)
+		})
+	}
+}
+
+func TestParsePatternErrors(t *testing.T) {
+	for _, pat := range []string{
+		"users",
+		"/{path...}/x",
+		"/*/x",
+		"/{}",
+		"/{id}/{id}",
+		"/a{id}",
+	} {
+		_, err := parsePattern(pat)
+		assert.Error(t, err, pat)
+	}
+}
+
+func TestPatternSpecificity(t *testing.T) {
+	lit, _ := parsePattern("/users/me")
+	param, _ := parsePattern("/users/{id}")
+	wild, _ := parsePattern("/users/{rest...}")
+	assert.True(t, lit.moreSpecific(param))
+	assert.True(t, param.moreSpecific(wild))
+	assert.False(t, wild.moreSpecific(lit))
+}
@@ -0,0 +1,146 @@
+// Package router dispatches requests to session Handlers by method
+// and path pattern.
+package router
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"http2/session"
+)
+
+type route struct {
+	// "" matches any method.
+	method  string
+	pattern *pattern
+	handler session.Handler
+}
+
+// A Router is a session.Handler that picks a handler for each request
+// from its registered routes. Patterns are matched against the path
+// only, so query strings don't affect routing. Path parameters are
+// available from Request.PathValue.
+//
+// When several routes match, the one with the most specific pattern
+// wins, literal segments beating parameters beating wildcards.
+type Router struct {
+	routes []*route
+
+	// Called when no route matches the path.
+	// Defaults to an empty 404 response.
+	NotFound session.Handler
+
+	// Whether the router was mounted in another, so paths
+	// are relative to the mount point.
+	mounted bool
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Add registers a route. An empty method matches any method, and GET
+// routes also serve HEAD requests. Add panics if the pattern is
+// invalid, as routes are usually fixed when the program is written.
+func (r *Router) Add(method, pat string, h session.Handler) {
+	p, err := parsePattern(pat)
+	if err != nil {
+		panic(err)
+	}
+	r.routes = append(r.routes, &route{method: method, pattern: p, handler: h})
+}
+
+func (r *Router) Get(pat string, h session.FuncHandler) {
+	r.Add("GET", pat, h)
+}
+
+func (r *Router) Post(pat string, h session.FuncHandler) {
+	r.Add("POST", pat, h)
+}
+
+func (r *Router) Put(pat string, h session.FuncHandler) {
+	r.Add("PUT", pat, h)
+}
+
+func (r *Router) Patch(pat string, h session.FuncHandler) {
+	r.Add("PATCH", pat, h)
+}
+
+func (r *Router) Delete(pat string, h session.FuncHandler) {
+	r.Add("DELETE", pat, h)
+}
+
+// Mount serves every path under prefix with h, for any method. The
+// rest of the path is available as PathValue("*"), and if h is a
+// Router its patterns are matched against it.
+func (r *Router) Mount(prefix string, h session.Handler) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if sub, ok := h.(*Router); ok {
+		sub.mounted = true
+	}
+	r.Add("", prefix+"/*", h)
+	if prefix != "" {
+		r.Add("", prefix, session.FuncHandler(func(req *session.Request, res *session.Response) {
+			req.SetPathValue("*", "")
+			h.Handle(req, res)
+		}))
+	}
+}
+
+func (r *Router) path(req *session.Request) string {
+	if r.mounted {
+		return "/" + req.PathValue("*")
+	}
+	return req.URL().Path
+}
+
+func (r *Router) Handle(req *session.Request, res *session.Response) {
+	path := r.path(req)
+
+	var best *route
+	var bestValues map[string]string
+	var allowed []string
+	for _, rt := range r.routes {
+		values, ok := rt.pattern.match(path)
+		if !ok {
+			continue
+		}
+		if !rt.allows(req.Method()) {
+			allowed = append(allowed, rt.methods()...)
+			continue
+		}
+		if best == nil || rt.pattern.moreSpecific(best.pattern) {
+			best, bestValues = rt, values
+		}
+	}
+
+	switch {
+	case best != nil:
+		for k, v := range bestValues {
+			req.SetPathValue(k, v)
+		}
+		best.handler.Handle(req, res)
+	case len(allowed) > 0:
+		slices.Sort(allowed)
+		res.SetHeader("allow", strings.Join(slices.Compact(allowed), ", "))
+		res.WriteHeader(session.MethodNotAllowed)
+	case r.NotFound != nil:
+		r.NotFound.Handle(req, res)
+	default:
+		res.WriteHeader(session.NotFound)
+	}
+}
+
+func (rt *route) allows(method string) bool {
+	return rt.method == "" || rt.method == method ||
+		(rt.method == http.MethodGet && method == http.MethodHead)
+}
+
+// methods lists the methods the route serves, for the Allow header.
+func (rt *route) methods() []string {
+	if rt.method == http.MethodGet {
+		return []string{http.MethodGet, http.MethodHead}
+	}
+	return []string{rt.method}
+}
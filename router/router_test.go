@@ -0,0 +1,106 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"http2/session"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// do serves a request through r, mounted in net/http for convenience.
+func do(r *Router, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	session.ToHTTPHandler(r).ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func reply(s string) session.FuncHandler {
+	return func(req *session.Request, res *session.Response) {
+		io.WriteString(res, s)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := New()
+	r.Get("/", reply("index"))
+	r.Get("/users/{id}", func(req *session.Request, res *session.Response) {
+		io.WriteString(res, "user "+req.PathValue("id"))
+	})
+	r.Get("/users/me", reply("me"))
+	r.Post("/users", reply("created"))
+	r.Get("/static/{path...}", func(req *session.Request, res *session.Response) {
+		io.WriteString(res, "file "+req.PathValue("path"))
+	})
+
+	cases := []struct {
+		Method string
+		Target string
+		Code   int
+		Body   string
+	}{
+		{"GET", "/", 200, "index"},
+		{"GET", "/?q=1", 200, "index"},
+		{"GET", "/users/42?full=true", 200, "user 42"},
+		{"GET", "/users/me", 200, "me"},
+		{"POST", "/users", 200, "created"},
+		{"GET", "/static/css/site.css", 200, "file css/site.css"},
+		{"GET", "/missing", 404, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.Method+" "+c.Target, func(t *testing.T) {
+			rec := do(r, c.Method, c.Target)
+			assert.Equal(t, c.Code, rec.Code)
+			assert.Equal(t, c.Body, rec.Body.String())
+		})
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	r := New()
+	r.Get("/users/{id}", reply("get"))
+	r.Delete("/users/{id}", reply("delete"))
+
+	rec := do(r, "PUT", "/users/1")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "DELETE, GET, HEAD", rec.Header().Get("Allow"))
+
+	rec = do(r, "HEAD", "/users/1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("Content-Length"))
+	assert.Empty(t, rec.Body.String())
+}
+
+func TestRouterNotFound(t *testing.T) {
+	r := New()
+	r.NotFound = session.FuncHandler(func(req *session.Request, res *session.Response) {
+		res.WriteHeader(session.NotFound)
+		io.WriteString(res, "nothing at "+req.URL().Path)
+	})
+	rec := do(r, "GET", "/nope")
+	assert.Equal(t, 404, rec.Code)
+	assert.Equal(t, "nothing at /nope", rec.Body.String())
+}
+
+func TestRouterMount(t *testing.T) {
+	api := New()
+	api.Get("/", reply("api index"))
+	api.Get("/users/{id}", func(req *session.Request, res *session.Response) {
+		io.WriteString(res, "api user "+req.PathValue("id"))
+	})
+
+	r := New()
+	r.Mount("/api/", api)
+	r.Mount("/raw", session.FuncHandler(func(req *session.Request, res *session.Response) {
+		io.WriteString(res, "raw "+req.PathValue("*"))
+	}))
+
+	assert.Equal(t, "api user 3", do(r, "GET", "/api/users/3").Body.String())
+	assert.Equal(t, "api index", do(r, "GET", "/api").Body.String())
+	assert.Equal(t, "api index", do(r, "GET", "/api/").Body.String())
+	assert.Equal(t, "raw a/b", do(r, "POST", "/raw/a/b").Body.String())
+	assert.Equal(t, 404, do(r, "GET", "/api/nope").Code)
+}
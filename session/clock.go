@@ -0,0 +1,111 @@
+package session
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock is where a Dispatcher gets the time and its timers from.
+// Replays use a FakeClock so that timeouts fire at the same point in
+// the traffic as they did when it was recorded.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc runs f after d, like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A Timer is a timer made by a Clock. *time.Timer is one.
+type Timer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// A FakeClock only moves when it's told to. Timers fire during Set,
+// on the goroutine calling it, in the order they're due.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: make(map[*fakeTimer]struct{})}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), f: f}
+	c.timers[t] = struct{}{}
+	return t
+}
+
+// Set moves the clock to now, firing the timers due by then. The clock
+// never goes backwards.
+func (c *FakeClock) Set(now time.Time) {
+	for {
+		c.mu.Lock()
+		if now.After(c.now) {
+			c.now = now
+		}
+		var due []*fakeTimer
+		for t := range c.timers {
+			if !t.when.After(c.now) {
+				due = append(due, t)
+			}
+		}
+		if len(due) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		sort.Slice(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+		t := due[0]
+		delete(c.timers, t)
+		c.mu.Unlock()
+		// A timer can reset itself, so look again after each.
+		t.f()
+	}
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+type fakeTimer struct {
+	c    *FakeClock
+	when time.Time
+	f    func()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	_, active := t.c.timers[t]
+	t.when = t.c.now.Add(d)
+	t.c.timers[t] = struct{}{}
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	_, active := t.c.timers[t]
+	delete(t.c.timers, t)
+	return active
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An encodeWriter compresses into the response body. Flush pushes
+// out everything written so far, so streamed responses stay live.
+type encodeWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// A bodyEncoder holds back the start of the body until it's known
+// whether compressing it is worthwhile.
+type bodyEncoder struct {
+	name    string
+	minSize int
+	level   int
+
+	decided bool
+	encoded bool         // content-encoding was added
+	w       encodeWriter // nil if the body is sent as is, or not at all
+}
+
+// EnableCompression compresses the response body with encoding,
+// "gzip" or "deflate" (the zlib format, RFC 9110 §8.4.1.2), at the
+// given compress/flate level. Nothing is
+// compressed if the handler declares a content-length or its own
+// content-encoding, the content type is already compressed, or the
+// whole body is shorter than minSize. It must be called before the
+// headers are written.
+func (res *Response) EnableCompression(encoding string, level, minSize int) error {
+	if res.wroteHeader {
+		return HeadersAlreadyWritten
+	}
+	if encoding != "gzip" && encoding != "deflate" {
+		return fmt.Errorf("unsupported content-encoding %q", encoding)
+	}
+	res.encoder = &bodyEncoder{name: encoding, minSize: minSize, level: level}
+	return nil
+}
+
+// Content types that don't get smaller when compressed again.
+var compressedTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+}
+
+func isCompressedType(ctype string) bool {
+	ctype = strings.ToLower(ctype)
+	if strings.HasPrefix(ctype, "image/svg") {
+		return false
+	}
+	for _, t := range compressedTypes {
+		if strings.HasPrefix(ctype, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *Response) header(k string) (string, bool) {
+	for _, f := range res.headers {
+		if f.k == k {
+			return f.v, true
+		}
+	}
+	return "", false
+}
+
+// decideEncoding settles whether the body is compressed, once at
+// least minSize octets are buffered or it has to be decided now.
+// Bytes buffered so far are compressed in place. A HEAD response
+// buffers nothing, so it goes by how much the handler wrote, and gets
+// the content-encoding a GET would without compressing anything.
+func (res *Response) decideEncoding(now bool, minSize int) error {
+	enc := res.encoder
+	if enc == nil || enc.decided {
+		return nil
+	}
+	size := int64(res.body.Len())
+	if res.isHead() {
+		size = res.written
+	}
+	if !now && size < int64(minSize) {
+		return nil
+	}
+	enc.decided = true
+
+	ctype, _ := res.header("content-type")
+	_, hasEncoding := res.header("content-encoding")
+	if hasEncoding || res.contentLength >= 0 || res.headersSent ||
+		!res.bodyAllowed() || isCompressedType(ctype) ||
+		size < int64(minSize) {
+		return nil
+	}
+	if res.isHead() {
+		enc.encoded = true
+		res.headers = append(res.headers, stringpair{"content-encoding", enc.name})
+		return nil
+	}
+
+	raw := bytes.NewBuffer(res.body.Bytes())
+	res.body = bytes.NewBuffer(nil)
+	var err error
+	switch enc.name {
+	case "gzip":
+		enc.w, err = gzip.NewWriterLevel(res.body, enc.level)
+	case "deflate":
+		enc.w, err = zlib.NewWriterLevel(res.body, enc.level)
+	}
+	if err != nil {
+		enc.w = nil
+		res.body = raw
+		return err
+	}
+	enc.encoded = true
+	res.headers = append(res.headers, stringpair{"content-encoding", enc.name})
+	_, err = raw.WriteTo(enc.w)
+	return err
+}
+
+// writeBody buffers body octets, through the compressor if there is one.
+func (res *Response) writeBody(data []byte) (int, error) {
+	if res.encoder == nil {
+		return res.body.Write(data)
+	}
+	if res.encoder.w != nil {
+		return res.encoder.w.Write(data)
+	}
+	n, err := res.body.Write(data)
+	if err != nil {
+		return n, err
+	}
+	return n, res.decideEncoding(false, res.encoder.minSize)
+}
+
+// flushEncoder moves everything compressed so far into the body.
+// A flush means the handler is streaming, so the body is compressed
+// even if it's still short.
+func (res *Response) flushEncoder() error {
+	enc := res.encoder
+	if enc == nil {
+		return nil
+	}
+	if err := res.decideEncoding(true, 0); err != nil {
+		return err
+	}
+	if enc.w != nil {
+		return enc.w.Flush()
+	}
+	return nil
+}
+
+// closeEncoder ends the compressed stream once the handler returns.
+func (res *Response) closeEncoder() error {
+	enc := res.encoder
+	if enc == nil {
+		return nil
+	}
+	if err := res.decideEncoding(true, enc.minSize); err != nil {
+		return err
+	}
+	if enc.w != nil {
+		return enc.w.Close()
+	}
+	return nil
+}
@@ -3,12 +3,13 @@ package session
 import (
 	"bytes"
 	"context"
-	"encoding/hex"
-	"fmt"
+	"crypto/tls"
+	"encoding/binary"
 	"http2/frame"
 	"http2/hpack"
 	"http2/session/settings"
 	"io"
+	"net"
 	"sync"
 )
 
@@ -16,6 +17,7 @@ import (
 // connection.
 type ConnectionContext struct {
 	context.Context
+	inlock              *sync.Mutex
 	incoming            io.Reader
 	incomingHeaderTable *hpack.HeaderLookupTable
 
@@ -25,15 +27,30 @@ type ConnectionContext struct {
 
 	cancel context.CancelFunc
 
+	// Header compression counters, guarded by inlock (incoming)
+	// and outlock (outgoing).
+	rawIn, encodedIn   uint64
+	rawOut, encodedOut uint64
+
 	Settings settings.SettingsList
 
 	Handler Handler
+
+	// Told about frames, header blocks, settings, stream states and
+	// errors. Nil traces nothing.
+	Tracer Tracer
+
+	// The client's address, and its TLS state once the
+	// handshake is done.
+	RemoteAddr string
+	TLS        *tls.ConnectionState
 }
 
 func NewConnectionContext(in io.Reader, out io.Writer, handler Handler) *ConnectionContext {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	ret := &ConnectionContext{
+		inlock:              new(sync.Mutex),
 		incoming:            in,
 		incomingHeaderTable: hpack.NewHeaderLookupTable(),
 
@@ -46,17 +63,60 @@ func NewConnectionContext(in io.Reader, out io.Writer, handler Handler) *Connect
 
 		Handler: handler,
 	}
+	if conn, ok := in.(net.Conn); ok {
+		ret.RemoteAddr = conn.RemoteAddr().String()
+	}
 	return ret
 }
 
+// recordTLSState saves the TLS connection state, which is only
+// complete after the handshake.
+func (this *ConnectionContext) recordTLSState() {
+	if conn, ok := this.incoming.(*tls.Conn); ok {
+		cs := conn.ConnectionState()
+		this.TLS = &cs
+	}
+}
+
+// applyPeerSettings applies the client's settings that change how
+// header blocks are encoded for it.
+func (this *ConnectionContext) applyPeerSettings(sl *settings.SettingsList) {
+	if size, ok := sl.Get(settings.HeaderTableSize); ok {
+		this.outlock.Lock()
+		this.outgoingHeadertable.SetPeerLimit(int(size))
+		this.outlock.Unlock()
+	}
+}
+
 func (this *ConnectionContext) SendFrame(fh *frame.FrameHeader, data []byte) error {
 	this.outlock.Lock()
 	defer this.outlock.Unlock()
+	return this.writeFrame(fh, data)
+}
+
+// windowUpdate lets the client send n more octets on a stream, or on
+// the connection if sid is 0. It's safe to call from any goroutine.
+func (this *ConnectionContext) windowUpdate(sid frame.Sid, n uint32) error {
+	var data [4]uint8
+	binary.BigEndian.PutUint32(data[:], n)
+	return this.SendFrame(&frame.FrameHeader{
+		Length: 4,
+		Type:   frame.FrameWindowUpdate,
+		Sid:    sid,
+	}, data[:])
+}
 
-	fmt.Printf("\x1b[31mSend Frame\x1b[0m %s\n", fh)
-	if data != nil && len(data) > 1 {
-		fmt.Printf(hex.Dump(data[:min(len(data), 1024)]))
+// trace returns the connection's Tracer, or a NopTracer.
+func (this *ConnectionContext) trace() Tracer {
+	if this == nil || this.Tracer == nil {
+		return NopTracer{}
 	}
+	return this.Tracer
+}
+
+// writeFrame sends a frame. Callers must hold outlock.
+func (this *ConnectionContext) writeFrame(fh *frame.FrameHeader, data []byte) error {
+	this.trace().FrameWritten(this, fh, data)
 	if err := fh.Marshal(this.outgoing); err != nil {
 		return err
 	}
@@ -66,3 +126,67 @@ func (this *ConnectionContext) SendFrame(fh *frame.FrameHeader, data []byte) err
 	_, err := io.Copy(this.outgoing, bytes.NewReader(data))
 	return err
 }
+
+// SendHeaderBlock encodes the header fields with the outgoing lookup
+// table and sends them in a HEADERS frame. Encoding and sending happen
+// under one lock, so the peer decodes blocks in the order they changed
+// the table.
+func (this *ConnectionContext) SendHeaderBlock(sid frame.Sid, flags uint8, fields []stringpair) error {
+	this.outlock.Lock()
+	defer this.outlock.Unlock()
+
+	hl := hpack.NewHeaderList(this.outgoingHeadertable)
+	for _, pair := range fields {
+		hl.Put(pair.k, pair.v)
+		this.rawOut += uint64(len(pair.k) + len(pair.v))
+	}
+	data := hl.Dump()
+	this.encodedOut += uint64(len(data))
+
+	fh := &frame.FrameHeader{
+		Length: uint32(len(data)),
+		Type:   frame.FrameHeaders,
+		Sid:    sid,
+		Flags:  flags,
+	}
+	return this.writeFrame(fh, data)
+}
+
+// HeaderStats reports how well header compression is doing
+// on a connection.
+type HeaderStats struct {
+	// Octets of header names and values before encoding,
+	// and the size of the header blocks they were coded into.
+	RawIn, EncodedIn   uint64
+	RawOut, EncodedOut uint64
+
+	Decoder hpack.TableSnapshot
+	Encoder hpack.TableSnapshot
+}
+
+// Ratio returns encoded/raw for each direction. Lower is better.
+func (hs HeaderStats) Ratio() (in, out float64) {
+	if hs.RawIn > 0 {
+		in = float64(hs.EncodedIn) / float64(hs.RawIn)
+	}
+	if hs.RawOut > 0 {
+		out = float64(hs.EncodedOut) / float64(hs.RawOut)
+	}
+	return
+}
+
+func (this *ConnectionContext) HeaderStats() HeaderStats {
+	var ret HeaderStats
+
+	this.inlock.Lock()
+	ret.RawIn, ret.EncodedIn = this.rawIn, this.encodedIn
+	ret.Decoder = this.incomingHeaderTable.Snapshot()
+	this.inlock.Unlock()
+
+	this.outlock.Lock()
+	ret.RawOut, ret.EncodedOut = this.rawOut, this.encodedOut
+	ret.Encoder = this.outgoingHeadertable.Snapshot()
+	this.outlock.Unlock()
+
+	return ret
+}
@@ -2,14 +2,29 @@ package session
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"http2/frame"
 	"http2/hpack"
 	"http2/session/settings"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// UnsupportedSetting is returned for a LocalSettings entry the
+// server can't honour.
+var UnsupportedSetting = errors.New("setting not supported by the server")
+
+// How long a graceful shutdown waits for the client to answer its
+// PING before sending the final GOAWAY anyway.
+const shutdownGrace = time.Second
+
+// The opaque data of the PING a graceful shutdown sends.
+var shutdownPing = []byte("shutdown")
+
 // A Dispatcher object represents an open connection
 // between this server and a client.
 type Dispatcher struct {
@@ -17,6 +32,37 @@ type Dispatcher struct {
 	Ctx        *ConnectionContext
 	lastStream frame.Sid
 	Streams    map[frame.Sid]*Stream
+
+	// Sent to the client in the server's first SETTINGS frame, and
+	// enforced from then on.
+	LocalSettings settings.SettingsList
+
+	// Limits on how long the client can take to send its preface
+	// and settings, and how long the connection can sit with no
+	// frames and no running handlers. Zero means no limit.
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+
+	// Runs the idle timer. Defaults to the real clock.
+	Clock Clock
+
+	// Handlers still running, and streams open or half-closed.
+	active atomic.Int32
+	open   atomic.Int32
+	// Set once Shutdown is called, and once the final GOAWAY has
+	// been written.
+	goingAway atomic.Bool
+	finalSent atomic.Bool
+
+	// Once the final GOAWAY is sent, streams after lastAccepted are
+	// refused. It can be sent from a timer, so both are guarded.
+	// Shutdown waits for the handshake before sending anything, so
+	// handshook and goingAway change under the lock too.
+	shutdownMu    sync.Mutex
+	handshook     bool
+	drained       bool
+	lastAccepted  frame.Sid
+	shutdownTimer Timer
 }
 
 func NewDispatcher(ctx *ConnectionContext, framer *frame.Framer) *Dispatcher {
@@ -33,13 +79,15 @@ func (sess *Dispatcher) initialHandshake() error {
 	if err != nil {
 		return err
 	}
+	sess.Ctx.recordTLSState()
+	if err := sess.applyLocalSettings(); err != nil {
+		return err
+	}
 	globalStream := sess.Stream(0)
 
 	// Server must initiate communications by sending
-	// a settings frame with initial settings. Leave it
-	// empty for now until we implement actual setting
-	// enforcement
-	if err := globalStream.SendFrame(frame.FrameSettings, 0, nil); err != nil {
+	// a settings frame with initial settings.
+	if err := globalStream.SendFrame(frame.FrameSettings, 0, sess.LocalSettings.ToPayload()); err != nil {
 		return err
 	}
 
@@ -51,10 +99,14 @@ func (sess *Dispatcher) initialHandshake() error {
 	} else if !ok {
 		return errors.New("Unexpected frame")
 	}
+	if len(fr.Data)%6 != 0 {
+		return sess.ConnError(ErrorCodeFrameSize, "SETTINGS payload must be a multiple of 6 octets")
+	}
 	sl := settings.SettingsListFromFramePayload(fr.Data)
-	fmt.Println("---(INITIAL CLIENT SETTINGS)---")
-	fmt.Print(sl)
-	fmt.Println("-------------------------------")
+	sess.Ctx.trace().SettingsChanged(sess.Ctx, sl)
+	// No handlers are running yet, so this doesn't race with them.
+	sess.Ctx.Settings = *sl
+	sess.Ctx.applyPeerSettings(sl)
 	globalStream.SendFrame(frame.FrameSettings, settings.STGS_ACK, nil)
 	return nil
 }
@@ -62,19 +114,63 @@ func (sess *Dispatcher) initialHandshake() error {
 // Continue accepting and dispatching packets on this session
 // until the connection closes or an error occurs.
 func (sess *Dispatcher) Serve() error {
+	// Stop handlers still running for this connection.
+	defer sess.Ctx.cancel()
+
+	conn, _ := sess.Ctx.incoming.(net.Conn)
+	if conn != nil && sess.HandshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(sess.HandshakeTimeout))
+	}
 	if err := sess.initialHandshake(); err != nil {
-		fmt.Println(err)
+		sess.Ctx.trace().Error(sess.Ctx, 0, err)
+		if ce, ok := err.(*ConnError); ok {
+			sess.SendGoaway(ce.LastSid, ce.ErrorCode, ce.Reason)
+		}
 		return err
 	}
+	if conn != nil && sess.HandshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	sess.shutdownMu.Lock()
+	sess.handshook = true
+	shutdown := sess.goingAway.Load()
+	sess.shutdownMu.Unlock()
+	if shutdown {
+		sess.sendShutdown()
+	}
+
+	// The idle timer keeps rescheduling itself while handlers
+	// run, and closes the connection once they're all done.
+	var idle Timer
+	if sess.IdleTimeout > 0 {
+		idle = sess.clock().AfterFunc(sess.IdleTimeout, func() {
+			if sess.active.Load() > 0 {
+				idle.Reset(sess.IdleTimeout)
+				return
+			}
+			// Nothing is in flight after this long, so the
+			// final GOAWAY can follow right away.
+			sess.Shutdown()
+			sess.finishShutdown()
+			if conn != nil {
+				conn.Close()
+			}
+		})
+		defer idle.Stop()
+	}
+
 	var (
 		fr  *frame.Frame
 		err error
 	)
 	for {
-		fr, err = sess.Framer.ReadFrame()
+		fr, err = sess.readFrame()
 		if err != nil {
 			break
 		}
+		if idle != nil {
+			idle.Reset(sess.IdleTimeout)
+		}
 		err = sess.Dispatch(fr)
 		if err != nil {
 			break
@@ -82,7 +178,7 @@ func (sess *Dispatcher) Serve() error {
 	}
 	if err != nil {
 		if ce, ok := err.(*ConnError); ok {
-			fmt.Printf("\x1b[32mERROR ERROR\x1b[0m %s\n", ce)
+			sess.Ctx.trace().Error(sess.Ctx, 0, ce)
 			sess.SendGoaway(ce.LastSid, ce.ErrorCode, ce.Reason)
 		}
 		return err
@@ -90,8 +186,28 @@ func (sess *Dispatcher) Serve() error {
 	return nil
 }
 
-func (sess *Dispatcher) ExpectFrame(typ frame.FrameType, sid frame.Sid) (*frame.Frame, bool, error) {
+func (sess *Dispatcher) clock() Clock {
+	if sess.Clock == nil {
+		return realClock{}
+	}
+	return sess.Clock
+}
+
+// readFrame reads the next frame and traces it.
+func (sess *Dispatcher) readFrame() (*frame.Frame, error) {
 	fr, err := sess.Framer.ReadFrame()
+	if errors.Is(err, frame.FrameTooLarge) {
+		return nil, sess.ConnError(ErrorCodeFrameSize, "frame larger than SETTINGS_MAX_FRAME_SIZE")
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess.Ctx.trace().FrameRead(sess.Ctx, fr)
+	return fr, nil
+}
+
+func (sess *Dispatcher) ExpectFrame(typ frame.FrameType, sid frame.Sid) (*frame.Frame, bool, error) {
+	fr, err := sess.readFrame()
 	if err != nil {
 		return nil, false, err
 	}
@@ -103,6 +219,9 @@ func (sess *Dispatcher) Stream(sid frame.Sid) *Stream {
 		return st
 	}
 	st := NewStream(sid, sess.Ctx)
+	if sid != 0 {
+		st.open = &sess.open
+	}
 	sess.Streams[sid] = st
 	return st
 }
@@ -119,6 +238,12 @@ func (sess *Dispatcher) Dispatch(fr *frame.Frame) error {
 		if fh.Flag(0) {
 			return nil
 		}
+		if len(data)%6 != 0 {
+			return sess.ConnError(ErrorCodeFrameSize, "SETTINGS payload must be a multiple of 6 octets")
+		}
+		sl := settings.SettingsListFromFramePayload(data)
+		sess.Ctx.trace().SettingsChanged(sess.Ctx, sl)
+		sess.Ctx.applyPeerSettings(sl)
 		// Must acknowledge new settings frame
 		sess.Stream(0).SendFrame(frame.FrameSettings, settings.STGS_ACK, nil)
 
@@ -140,18 +265,59 @@ func (sess *Dispatcher) Dispatch(fr *frame.Frame) error {
 		if fh.Flag(3) {
 			c, err := buf.ReadByte()
 			if err != nil {
-				return err
+				return sess.ConnError(ErrorCodeFrameSize, "PADDED DATA frame has no pad length")
+			}
+			if uint32(c)+1 > dataSize {
+				return sess.ConnError(ErrorCodeProtocol, "padding exceeds frame payload")
 			}
 			dataSize -= uint32(c) + 1
 		}
+		if st.State() == StreamStateIdle {
+			return sess.ConnError(ErrorCodeProtocol, "DATA frame on an idle stream")
+		}
+		// The client already ended or reset the stream
+		// (RFC 9113 §5.1). Either way the data is dropped, and its
+		// share of the connection's window given back.
+		if st.peerClosed {
+			if fh.Length > 0 {
+				sess.Ctx.windowUpdate(0, fh.Length)
+			}
+			return st.Reset(ErrorCodeStreamClosed)
+		}
+		// Discard frames that arrive on streams we've reset.
+		if st.State() == StreamStateClosed {
+			if fh.Length > 0 {
+				sess.Ctx.windowUpdate(0, fh.Length)
+			}
+			break
+		}
+		// Before the data is buffered, so that reading it doesn't
+		// open the window of a stream the client has ended.
+		if fh.Flag(0) {
+			st.peerClosed = true
+			st.transition(StreamState.ReceivedEndStream)
+		}
+		// Padding counts against flow control too, and is given
+		// back at once. The data is given back as the handler
+		// reads it; see Stream.consumed.
+		if padding := fh.Length - dataSize; padding > 0 {
+			sess.Ctx.windowUpdate(0, padding)
+			if !fh.Flag(0) {
+				sess.Ctx.windowUpdate(fh.Sid, padding)
+			}
+		}
 		newData := make([]uint8, dataSize)
-		_, err := buf.Read(newData)
+		_, err := io.ReadFull(buf, newData)
 		if err != nil {
 			return err
 		}
 		if _, err := st.Body.Write(newData); err != nil {
 			return err
 		}
+		if err := st.checkContentLength(len(newData), fh.Flag(0)); err != nil {
+			sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+			return st.Reset(ErrorCodeProtocol)
+		}
 
 		// Bit 0 is END_STREAM
 		if fh.Flag(0) {
@@ -159,12 +325,21 @@ func (sess *Dispatcher) Dispatch(fr *frame.Frame) error {
 			sess.lastStream = fh.Sid
 			return nil
 		}
-	case frame.FrameWindowUpdate:
-		d := binary.BigEndian.Uint32(data[:4])
-		d &= ^uint32(1 << 31)
-		fmt.Printf("Client can receive an extra \x1b[33m%d\x1b[0m octets\n", d)
-	default:
-		fmt.Println("(I don't know what to do with this frame)")
+	case frame.FrameResetStream:
+		if fh.Sid == 0 {
+			return sess.ConnError(ErrorCodeProtocol, "RST_STREAM on stream 0")
+		}
+		if len(data) != 4 {
+			return sess.ConnError(ErrorCodeFrameSize, "RST_STREAM payload must be 4 octets")
+		}
+		// The code is in the traced frame; the stream ends
+		// the same whatever it is.
+		st.receivedReset()
+
+	case frame.FramePing:
+		if fh.Flag(0) && bytes.Equal(data, shutdownPing) {
+			sess.finishShutdown()
+		}
 	}
 	sess.lastStream = fh.Sid
 	return nil
@@ -195,67 +370,278 @@ func (sess *Dispatcher) ConnError(code ErrorCode, reason string) error {
 }
 
 func (sess *Dispatcher) HandleHeader(fh *frame.FrameHeader, data []uint8) error {
-	totRead := 0
-	padLength := 0
-
 	st := sess.Stream(fh.Sid)
 
-	st.State = st.State.ReceivedHeader()
-
-	// Padded
-	if fh.Flag(3) {
-		padLength = int(data[0])
-		fmt.Printf("\x1b[32m(Flag)\x1b[0m Padding %d\n", padLength)
-		totRead += 1
-	}
-	// Priority
-	if fh.Flag(5) {
-		depSid := binary.BigEndian.Uint32(data[totRead:])
-		weight := data[totRead+4]
-		fmt.Printf("\x1b[32m(Flag)\x1b[0m STREAM DEPENDENCY: %d --> %d (weight %d)\n", fh.Sid, depSid, weight)
-		totRead += 5
-	}
-	tr, err := sess.ReadHeaders(func(k, v string) {
-		fmt.Printf("%s = %s\n", k, v)
-		st.InHeaders.Add(k, v)
-	}, data, totRead, padLength)
+	// A second header block on a stream carries trailers
+	// (RFC 9113 §8.1).
+	isTrailer := st.InHeaders.Closed
+	st.transition(StreamState.ReceivedHeader)
+
+	block, err := frame.HeaderBlockFragment(fh, data)
+	if errors.Is(err, frame.PaddingTooLong) {
+		return sess.ConnError(ErrorCodeProtocol, "padding exceeds frame payload")
+	} else if err != nil {
+		return sess.ConnError(ErrorCodeFrameSize, "HEADERS frame too short for its padding and priority")
+	}
+	// Trailers are still decoded when the stream is gone so that the
+	// header table stays in sync with the client's.
+	var trailers []stringpair
+	var traced []HeaderField
+	tracing := sess.Ctx.Tracer != nil
+	_, err = sess.ReadHeaders(func(k, v string) {
+		if tracing {
+			traced = append(traced, HeaderField{k, v})
+		}
+		if isTrailer {
+			trailers = append(trailers, stringpair{k, v})
+		} else {
+			st.InHeaders.Add(k, v)
+		}
+	}, block, 0, 0)
 	if err != nil {
 		return err
 	}
-	totRead += tr
+	if tracing {
+		sess.Ctx.Tracer.HeadersDecoded(sess.Ctx, fh.Sid, traced)
+	}
+	if st.peerClosed {
+		return st.Reset(ErrorCodeStreamClosed)
+	}
+	if isTrailer {
+		return sess.handleTrailers(fh, st, trailers)
+	}
 	// End Stream
 	if fh.Flag(0) {
-		fmt.Printf("\x1b[32m(Flag)\x1b[0m End Stream\n")
-		st.State = st.State.ReceivedEndStream()
+		st.peerClosed = true
+		st.transition(StreamState.ReceivedEndStream)
 		st.Body.Close()
 	}
 	// End of headers
 	if fh.Flag(2) {
-		fmt.Printf("\x1b[32m(Flag)\x1b[0m End Headers\n")
 		st.InHeaders.Closed = true
-		go st.Serve(sess.Ctx)
+		if err := validateRequestHeaders(st.InHeaders.Headers); err != nil {
+			sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+			return st.Reset(ErrorCodeProtocol)
+		}
+		req, err := newRequest(st, sess.Ctx)
+		if err != nil {
+			sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+			return st.Reset(ErrorCodeProtocol)
+		}
+		st.Request = req
+		if fh.Flag(0) {
+			if err := st.checkContentLength(0, true); err != nil {
+				sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+				return st.Reset(ErrorCodeProtocol)
+			}
+		}
+		if !sess.accept(fh.Sid) {
+			return st.Reset(ErrorCodeRefusedStream)
+		}
+		sess.active.Add(1)
+		go func() {
+			defer sess.active.Add(-1)
+			st.Serve(sess.Ctx)
+		}()
 	}
 	return nil
 }
 
-func (sess *Dispatcher) ReadHeaders(cb func(k, v string), data []byte, totRead int, padLength int) (int, error) {
-	for totRead < len(data)-padLength {
-		hdr, numRead, err := hpack.NextHeader(data[totRead:])
-		if err != nil {
-			return 0, err
-		}
-		totRead += numRead
-		k, v, err := hdr.Resolve(sess.Ctx.incomingHeaderTable)
-		if err != nil {
-			return 0, err
+// accept reports whether a new stream is served, and if so makes it
+// the last one the final GOAWAY admits to.
+func (sess *Dispatcher) accept(sid frame.Sid) bool {
+	sess.shutdownMu.Lock()
+	defer sess.shutdownMu.Unlock()
+	if sess.drained {
+		return false
+	}
+	if limit, ok := sess.LocalSettings.Get(settings.MaxConcurrentStreams); ok && int64(sess.open.Load()) > int64(limit) {
+		return false
+	}
+	sess.lastAccepted = sid
+	return true
+}
+
+// Shutdown starts a graceful shutdown (RFC 9113 §6.8). The client is
+// told with GOAWAY not to open new streams, and sent a PING. Streams
+// that cross the GOAWAY are still served, and once the PING comes
+// back, or shutdownGrace passes, a final GOAWAY names the last stream
+// the server accepted and later ones are refused. Streams already
+// open are served as usual. If the connection is still in its
+// handshake, the GOAWAY is sent once the handshake is done. It's safe
+// to call from any goroutine.
+func (sess *Dispatcher) Shutdown() error {
+	if !sess.beginShutdown() {
+		return nil
+	}
+	return sess.sendShutdown()
+}
+
+// beginShutdown marks the connection as going away, so Idle waits for
+// the final GOAWAY, and reports whether the caller should send the
+// first one now.
+func (sess *Dispatcher) beginShutdown() bool {
+	sess.shutdownMu.Lock()
+	defer sess.shutdownMu.Unlock()
+	if sess.goingAway.Load() {
+		return false
+	}
+	sess.goingAway.Store(true)
+	return sess.handshook
+}
+
+// sendShutdown sends the first GOAWAY and the PING of a graceful
+// shutdown.
+func (sess *Dispatcher) sendShutdown() error {
+	// The stream the client is opening right now may not have
+	// reached us yet, so don't promise a last stream ID.
+	gf := GoawayFrame{LastStreamId: 1<<31 - 1, ErrorCode: ErrorCodeNoError}
+	payload := gf.Marshal()
+	if err := sess.Ctx.SendFrame(&frame.FrameHeader{
+		Length: uint32(len(payload)),
+		Type:   frame.FrameGoaway,
+	}, payload); err != nil {
+		return err
+	}
+	timer := sess.clock().AfterFunc(shutdownGrace, sess.finishShutdown)
+	sess.shutdownMu.Lock()
+	sess.shutdownTimer = timer
+	sess.shutdownMu.Unlock()
+	return sess.Ctx.SendFrame(&frame.FrameHeader{
+		Length: uint32(len(shutdownPing)),
+		Type:   frame.FramePing,
+	}, shutdownPing)
+}
+
+// finishShutdown sends the final GOAWAY, once. The write happens
+// outside shutdownMu, since a client that stops reading can hold it
+// up for as long as the connection lasts.
+func (sess *Dispatcher) finishShutdown() {
+	sess.shutdownMu.Lock()
+	if sess.drained {
+		sess.shutdownMu.Unlock()
+		return
+	}
+	sess.drained = true
+	if sess.shutdownTimer != nil {
+		sess.shutdownTimer.Stop()
+	}
+	gf := GoawayFrame{LastStreamId: sess.lastAccepted, ErrorCode: ErrorCodeNoError}
+	sess.shutdownMu.Unlock()
+
+	payload := gf.Marshal()
+	sess.Ctx.SendFrame(&frame.FrameHeader{
+		Length: uint32(len(payload)),
+		Type:   frame.FrameGoaway,
+	}, payload)
+	sess.finalSent.Store(true)
+}
+
+// Idle reports whether no handlers are running, and a graceful
+// shutdown, if one started after the handshake, has sent its final
+// GOAWAY. A connection still in its handshake has nothing to finish.
+func (sess *Dispatcher) Idle() bool {
+	sess.shutdownMu.Lock()
+	pending := sess.goingAway.Load() && sess.handshook
+	sess.shutdownMu.Unlock()
+	if pending && !sess.finalSent.Load() {
+		return false
+	}
+	return sess.active.Load() == 0
+}
+
+// applyLocalSettings puts LocalSettings into effect before they're
+// sent. MAX_CONCURRENT_STREAMS is enforced as streams are accepted.
+func (sess *Dispatcher) applyLocalSettings() error {
+	if err := checkLocalSettings(sess.LocalSettings); err != nil {
+		return err
+	}
+	if size, ok := sess.LocalSettings.Get(settings.HeaderTableSize); ok {
+		ctx := sess.Ctx
+		ctx.inlock.Lock()
+		ctx.incomingHeaderTable.SetSizeLimit(int(size))
+		ctx.inlock.Unlock()
+	}
+	maxFrameSize, ok := sess.LocalSettings.Get(settings.MaxFrameSize)
+	if !ok {
+		maxFrameSize, _ = settings.Default(settings.MaxFrameSize)
+	}
+	sess.Framer.MaxFrameSize = maxFrameSize
+	return nil
+}
+
+// checkLocalSettings rejects settings the server can't honour: it
+// keeps no receive window or header list limit of its own, and never
+// pushes.
+func checkLocalSettings(sl settings.SettingsList) error {
+	for _, s := range sl.Settings {
+		switch s.Type {
+		case settings.HeaderTableSize, settings.MaxConcurrentStreams:
+		case settings.EnablePush:
+			// A server may only turn it off (RFC 9113 §6.5.2).
+			if s.Value != 0 {
+				return fmt.Errorf("%w: %v=%d", UnsupportedSetting, s.Type, s.Value)
+			}
+		case settings.MaxFrameSize:
+			if s.Value < 1<<14 || s.Value > 1<<24-1 {
+				return fmt.Errorf("%w: %v=%d", UnsupportedSetting, s.Type, s.Value)
+			}
+		default:
+			return fmt.Errorf("%w: %v", UnsupportedSetting, s.Type)
 		}
+	}
+	return nil
+}
+
+// handleTrailers finishes a request whose body is followed by a
+// trailer block.
+func (sess *Dispatcher) handleTrailers(fh *frame.FrameHeader, st *Stream, trailers []stringpair) error {
+	if st.State() == StreamStateClosed {
+		return nil
+	}
+	if !fh.Flag(0) {
+		sess.Ctx.trace().Error(sess.Ctx, fh.Sid, errors.New("trailers without END_STREAM"))
+		return st.Reset(ErrorCodeProtocol)
+	}
+	if err := validateTrailers(trailers); err != nil {
+		sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+		return st.Reset(ErrorCodeProtocol)
+	}
+	if err := st.checkContentLength(0, true); err != nil {
+		sess.Ctx.trace().Error(sess.Ctx, fh.Sid, err)
+		return st.Reset(ErrorCodeProtocol)
+	}
+	for _, f := range trailers {
+		st.Request.trailer.Add(f.k, f.v)
+	}
+	st.peerClosed = true
+	st.transition(StreamState.ReceivedEndStream)
+	st.Body.Close()
+	return nil
+}
+
+func (sess *Dispatcher) ReadHeaders(cb func(k, v string), data []byte, totRead int, padLength int) (int, error) {
+	if totRead+padLength > len(data) {
+		return 0, sess.ConnError(ErrorCodeProtocol, "padding exceeds frame payload")
+	}
+	block := data[totRead : len(data)-padLength]
+
+	ctx := sess.Ctx
+	ctx.inlock.Lock()
+	err := hpack.DecodeBlock(ctx.incomingHeaderTable, block, func(k, v string) {
+		ctx.rawIn += uint64(len(k) + len(v))
 		cb(k, v)
-		if hdr.ShouldIndex() {
-			sess.Ctx.incomingHeaderTable.Insert(k, v)
-		}
+	})
+	ctx.encodedIn += uint64(len(block))
+	ctx.inlock.Unlock()
+
+	var ce *hpack.CompressionError
+	if errors.As(err, &ce) {
+		return 0, sess.ConnError(ErrorCodeCompression, ce.Error())
+	} else if err != nil {
+		return 0, err
 	}
-	fmt.Println(sess.Ctx.incomingHeaderTable)
-	return totRead, nil
+	return len(block), nil
 }
 
 func (sess *Dispatcher) DoRequest(sid frame.Sid) error {
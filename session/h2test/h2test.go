@@ -0,0 +1,436 @@
+// Package h2test drives a session.Dispatcher frame by frame, for
+// testing how the server behaves at the protocol level without a TLS
+// client in the way.
+//
+// A Conn is the client's end of an in-memory connection. Tests write
+// whatever frames they like to it, well-formed or not, and then expect
+// the server's replies in order:
+//
+//	c := h2test.NewConn(t, handler)
+//	c.Handshake()
+//	c.WriteHeaders(1, true, ":method", "GET", ":scheme", "https", ":path", "/", ":authority", "test")
+//	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+//
+// The Dispatcher writes from the goroutine reading its frames, so the
+// Conn reads everything the server sends as soon as it's sent and
+// queues it; a server that writes never blocks the test.
+package h2test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+)
+
+// A Conn is a scripted client connected to a Dispatcher. Its methods
+// fail the test on errors, so they can be called from the test's
+// goroutine only.
+type Conn struct {
+	T    testing.TB
+	Sess *session.Dispatcher
+
+	// How long the Expect methods wait for the server to send
+	// something. Defaults to five seconds.
+	Timeout time.Duration
+
+	conn net.Conn
+	// The HPACK tables of the requests written and the responses
+	// read.
+	enc, dec *hpack.HeaderLookupTable
+	ignore   map[frame.FrameType]bool
+
+	mu      sync.Mutex
+	queue   []*frame.Frame
+	readErr error
+	arrived chan struct{}
+
+	started bool
+	served  chan error
+}
+
+// NewConn connects a Dispatcher serving handler to a new Conn and
+// starts serving. The connection is closed when the test ends.
+func NewConn(t testing.TB, handler session.Handler) *Conn {
+	c := NewUnstartedConn(t, handler)
+	c.Start()
+	return c
+}
+
+// NewUnstartedConn is like NewConn, but leaves the Dispatcher to be
+// configured before Start.
+func NewUnstartedConn(t testing.TB, handler session.Handler) *Conn {
+	client, server := net.Pipe()
+	ctx := session.NewConnectionContext(server, server, handler)
+	c := &Conn{
+		T:       t,
+		Sess:    session.NewDispatcher(ctx, frame.NewFramer(server)),
+		conn:    client,
+		enc:     hpack.NewHeaderLookupTable(),
+		dec:     hpack.NewHeaderLookupTable(),
+		ignore:  make(map[frame.FrameType]bool),
+		arrived: make(chan struct{}, 1),
+		served:  make(chan error, 1),
+	}
+	t.Cleanup(func() {
+		client.Close()
+		if c.started {
+			<-c.served
+		}
+	})
+	return c
+}
+
+// Start serves the connection and starts reading what the server
+// sends.
+func (c *Conn) Start() {
+	if c.started {
+		c.T.Fatal("h2test: Conn already started")
+	}
+	c.started = true
+	go func() {
+		err := c.Sess.Serve()
+		// A real server closes the connection when Serve returns.
+		c.serverConn().Close()
+		c.served <- err
+	}()
+	go c.readLoop()
+}
+
+func (c *Conn) serverConn() net.Conn {
+	return c.Sess.Framer.Incoming.(net.Conn)
+}
+
+func (c *Conn) readLoop() {
+	fr := frame.NewFramer(c.conn)
+	for {
+		f, err := fr.ReadFrame()
+		c.mu.Lock()
+		if err != nil {
+			c.readErr = err
+		} else {
+			c.queue = append(c.queue, f)
+		}
+		c.mu.Unlock()
+		select {
+		case c.arrived <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Ignore makes the Conn drop the frames of the given types that the
+// server sends, such as the WINDOW_UPDATE frames that follow request
+// bodies, so that tests needn't expect them.
+func (c *Conn) Ignore(types ...frame.FrameType) {
+	for _, typ := range types {
+		c.ignore[typ] = true
+	}
+}
+
+// Wait waits for the Dispatcher to stop serving and returns what
+// Serve returned.
+func (c *Conn) Wait() error {
+	c.T.Helper()
+	select {
+	case err := <-c.served:
+		c.served <- err
+		return err
+	case <-time.After(c.timeout()):
+		c.T.Fatal("h2test: Dispatcher still serving")
+		return nil
+	}
+}
+
+func (c *Conn) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return 5 * time.Second
+	}
+	return c.Timeout
+}
+
+// Write writes raw octets to the server.
+func (c *Conn) Write(p []byte) {
+	c.T.Helper()
+	if _, err := c.conn.Write(p); err != nil {
+		c.T.Fatalf("h2test: write: %v", err)
+	}
+}
+
+// WritePreface writes the client connection preface.
+func (c *Conn) WritePreface() {
+	c.T.Helper()
+	c.Write(frame.ClientPreface)
+}
+
+// WriteFrame writes a frame with the given payload. The length is
+// the payload's, and nothing else is checked, so it can write frames
+// the server ought to reject.
+func (c *Conn) WriteFrame(typ frame.FrameType, flags uint8, sid frame.Sid, payload []byte) {
+	c.T.Helper()
+	var buf bytes.Buffer
+	fh := frame.FrameHeader{Length: uint32(len(payload)), Type: typ, Sid: sid, Flags: flags}
+	fh.Marshal(&buf)
+	buf.Write(payload)
+	c.Write(buf.Bytes())
+}
+
+// WriteSettings writes a SETTINGS frame. A SETTINGS_HEADER_TABLE_SIZE
+// is applied to the table responses are decoded with at once, so a
+// server that keeps using a larger table fails to decode.
+func (c *Conn) WriteSettings(sl settings.SettingsList) {
+	c.T.Helper()
+	if size, ok := sl.Get(settings.HeaderTableSize); ok {
+		c.dec.SetSizeLimit(int(size))
+		c.dec.SetMaxSize(min(c.dec.MaxSize(), int(size)))
+	}
+	c.WriteFrame(frame.FrameSettings, 0, 0, sl.ToPayload())
+}
+
+// WriteSettingsAck acknowledges the server's SETTINGS.
+func (c *Conn) WriteSettingsAck() {
+	c.T.Helper()
+	c.WriteFrame(frame.FrameSettings, settings.STGS_ACK, 0, nil)
+}
+
+// Handshake writes the preface and empty SETTINGS, and expects the
+// server's SETTINGS and its acknowledgement of the client's. It
+// returns the server's settings.
+func (c *Conn) Handshake() *settings.SettingsList {
+	c.T.Helper()
+	return c.HandshakeWith(settings.SettingsList{})
+}
+
+// HandshakeWith is like Handshake, with the client's settings.
+func (c *Conn) HandshakeWith(local settings.SettingsList) *settings.SettingsList {
+	c.T.Helper()
+	c.WritePreface()
+	c.WriteSettings(local)
+	f := c.ExpectFrame(frame.FrameSettings, 0)
+	if f.FrameHeader.Flags&settings.STGS_ACK != 0 {
+		c.T.Fatal("h2test: server acknowledged SETTINGS before sending its own")
+	}
+	sl := settings.SettingsListFromFramePayload(f.Data)
+	c.WriteSettingsAck()
+	ack := c.ExpectFrame(frame.FrameSettings, 0)
+	if ack.FrameHeader.Flags&settings.STGS_ACK == 0 {
+		c.T.Fatal("h2test: server didn't acknowledge SETTINGS")
+	}
+	return sl
+}
+
+// EncodeHeaders encodes fields, given as alternating names and values,
+// into a header block, updating the Conn's HPACK table as the server
+// will when it decodes them.
+func (c *Conn) EncodeHeaders(kv ...string) []byte {
+	c.T.Helper()
+	if len(kv)%2 != 0 {
+		c.T.Fatal("h2test: odd number of header arguments")
+	}
+	hl := hpack.NewHeaderList(c.enc)
+	for i := 0; i < len(kv); i += 2 {
+		hl.Put(kv[i], kv[i+1])
+	}
+	return hl.Dump()
+}
+
+// WriteHeaders writes a HEADERS frame with END_HEADERS, its fields
+// given as alternating names and values.
+func (c *Conn) WriteHeaders(sid frame.Sid, endStream bool, kv ...string) {
+	c.T.Helper()
+	flags := session.FLAG_END_HEADERS
+	if endStream {
+		flags |= session.FLAG_END_STREAM
+	}
+	c.WriteFrame(frame.FrameHeaders, flags, sid, c.EncodeHeaders(kv...))
+}
+
+// WriteData writes a DATA frame.
+func (c *Conn) WriteData(sid frame.Sid, endStream bool, data []byte) {
+	c.T.Helper()
+	var flags uint8
+	if endStream {
+		flags = session.FLAG_END_STREAM
+	}
+	c.WriteFrame(frame.FrameData, flags, sid, data)
+}
+
+// WriteRST resets a stream.
+func (c *Conn) WriteRST(sid frame.Sid, code session.ErrorCode) {
+	c.T.Helper()
+	c.WriteFrame(frame.FrameResetStream, 0, sid, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+// WriteGoAway writes a GOAWAY frame.
+func (c *Conn) WriteGoAway(lastSid frame.Sid, code session.ErrorCode, debug string) {
+	c.T.Helper()
+	gf := session.GoawayFrame{LastStreamId: lastSid, ErrorCode: code, DebugInfo: []byte(debug)}
+	c.WriteFrame(frame.FrameGoaway, 0, 0, gf.Marshal())
+}
+
+// WritePing writes a PING frame.
+func (c *Conn) WritePing(ack bool, data [8]byte) {
+	c.T.Helper()
+	var flags uint8
+	if ack {
+		flags = 0x1
+	}
+	c.WriteFrame(frame.FramePing, flags, 0, data[:])
+}
+
+// ReadFrame returns the next frame the server sent, skipping ignored
+// types. It fails the test if none arrives in time. At the end of the
+// connection it returns nil and the read error, usually io.EOF.
+func (c *Conn) ReadFrame() (*frame.Frame, error) {
+	c.T.Helper()
+	deadline := time.After(c.timeout())
+	for {
+		c.mu.Lock()
+		for len(c.queue) > 0 {
+			f := c.queue[0]
+			c.queue = c.queue[1:]
+			if !c.ignore[f.FrameHeader.Type] {
+				c.mu.Unlock()
+				return f, nil
+			}
+		}
+		err := c.readErr
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-c.arrived:
+		case <-deadline:
+			c.T.Fatalf("h2test: no frame from the server after %v", c.timeout())
+			return nil, nil
+		}
+	}
+}
+
+// ExpectFrame reads the next frame and fails the test unless it has
+// the given type and stream.
+func (c *Conn) ExpectFrame(typ frame.FrameType, sid frame.Sid) *frame.Frame {
+	c.T.Helper()
+	f, err := c.ReadFrame()
+	if err != nil {
+		c.T.Fatalf("h2test: expected %s on stream %d, got %v", typ, sid, err)
+	}
+	fh := f.FrameHeader
+	if fh.Type != typ || fh.Sid != sid {
+		c.T.Fatalf("h2test: expected %s on stream %d, got %s", typ, sid, describe(f))
+	}
+	return f
+}
+
+// ExpectGoAway expects a GOAWAY frame with the given code.
+func (c *Conn) ExpectGoAway(code session.ErrorCode) *session.GoawayFrame {
+	c.T.Helper()
+	f := c.ExpectFrame(frame.FrameGoaway, 0)
+	if len(f.Data) < 8 {
+		c.T.Fatalf("h2test: GOAWAY payload is %d octets", len(f.Data))
+	}
+	gf := session.GoawayFrameFromPayload(f.Data)
+	if gf.ErrorCode != code {
+		c.T.Fatalf("h2test: expected GOAWAY with %s, got %s (%q)", code, gf.ErrorCode, gf.DebugInfo)
+	}
+	return gf
+}
+
+// ExpectRST expects the server to reset a stream with the given code.
+func (c *Conn) ExpectRST(sid frame.Sid, code session.ErrorCode) {
+	c.T.Helper()
+	f := c.ExpectFrame(frame.FrameResetStream, sid)
+	if len(f.Data) != 4 {
+		c.T.Fatalf("h2test: RST_STREAM payload is %d octets", len(f.Data))
+	}
+	if got := session.ErrorCode(binary.BigEndian.Uint32(f.Data)); got != code {
+		c.T.Fatalf("h2test: expected RST_STREAM with %s on stream %d, got %s", code, sid, got)
+	}
+}
+
+// ExpectWindowUpdate expects the server to give n octets of window
+// back on a stream, or on the connection if sid is 0.
+func (c *Conn) ExpectWindowUpdate(sid frame.Sid, n uint32) {
+	c.T.Helper()
+	f := c.ExpectFrame(frame.FrameWindowUpdate, sid)
+	if len(f.Data) != 4 {
+		c.T.Fatalf("h2test: WINDOW_UPDATE payload is %d octets", len(f.Data))
+	}
+	if got := binary.BigEndian.Uint32(f.Data); got != n {
+		c.T.Fatalf("h2test: expected WINDOW_UPDATE of %d on stream %d, got %d", n, sid, got)
+	}
+}
+
+// ExpectHeaders expects a header block on a stream, joining any
+// CONTINUATION frames, and fails the test unless it has every field
+// in want. It returns all the fields and whether the block ended the
+// stream.
+func (c *Conn) ExpectHeaders(sid frame.Sid, want ...session.HeaderField) ([]session.HeaderField, bool) {
+	c.T.Helper()
+	f := c.ExpectFrame(frame.FrameHeaders, sid)
+	fh := f.FrameHeader
+	block, err := frame.HeaderBlockFragment(fh, f.Data)
+	if err != nil {
+		c.T.Fatalf("h2test: HEADERS on stream %d: %v", sid, err)
+	}
+	block = append([]byte{}, block...)
+	for fh.Flags&session.FLAG_END_HEADERS == 0 {
+		cont := c.ExpectFrame(frame.FrameContinuation, sid)
+		fh = cont.FrameHeader
+		block = append(block, cont.Data...)
+	}
+
+	var got []session.HeaderField
+	if err := hpack.DecodeBlock(c.dec, block, func(k, v string) {
+		got = append(got, session.HeaderField{Name: k, Value: v})
+	}); err != nil {
+		c.T.Fatalf("h2test: decoding headers on stream %d: %v", sid, err)
+	}
+	for _, w := range want {
+		if !hasField(got, w) {
+			c.T.Fatalf("h2test: stream %d: expected %s: %s in %v", sid, w.Name, w.Value, got)
+		}
+	}
+	return got, f.FrameHeader.Flags&session.FLAG_END_STREAM != 0
+}
+
+// ExpectClosed expects the server to have nothing more to send and
+// to close the connection.
+func (c *Conn) ExpectClosed() {
+	c.T.Helper()
+	f, err := c.ReadFrame()
+	if f != nil {
+		c.T.Fatalf("h2test: expected the connection to close, got %s", describe(f))
+	}
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+		c.T.Fatalf("h2test: expected the connection to close, got %v", err)
+	}
+}
+
+func hasField(fields []session.HeaderField, want session.HeaderField) bool {
+	for _, f := range fields {
+		if f == want {
+			return true
+		}
+	}
+	return false
+}
+
+func describe(f *frame.Frame) string {
+	fh := f.FrameHeader
+	return fmt.Sprintf("%s on stream %d (flags %#x, %d octets)", fh.Type, fh.Sid, fh.Flags, fh.Length)
+}
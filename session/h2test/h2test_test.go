@@ -0,0 +1,411 @@
+package h2test
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var get = []string{":method", "GET", ":scheme", "https", ":path", "/", ":authority", "test"}
+
+func hello(req *session.Request, res *session.Response) {
+	res.SetHeader("content-type", "text/plain")
+	io.WriteString(res, "hello")
+}
+
+func TestRequest(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Handshake()
+
+	c.WriteHeaders(1, true, get...)
+	fields, endStream := c.ExpectHeaders(1,
+		session.HeaderField{Name: ":status", Value: "200"},
+		session.HeaderField{Name: "content-type", Value: "text/plain"},
+	)
+	assert.Equal(t, ":status", fields[0].Name)
+	assert.False(t, endStream)
+	var body []byte
+	for {
+		f := c.ExpectFrame(frame.FrameData, 1)
+		body = append(body, f.Data...)
+		if f.FrameHeader.Flags&session.FLAG_END_STREAM != 0 {
+			break
+		}
+	}
+	assert.Equal(t, "hello", string(body))
+
+	// The second request is encoded against the table the first
+	// one filled.
+	c.WriteHeaders(3, true, get...)
+	c.ExpectHeaders(3, session.HeaderField{Name: ":status", Value: "200"})
+}
+
+func TestRequestBody(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(func(req *session.Request, res *session.Response) {
+		io.Copy(res, req.Body)
+	}))
+	c.Handshake()
+	c.Ignore(frame.FrameWindowUpdate)
+
+	c.WriteHeaders(1, false, ":method", "POST", ":scheme", "https", ":path", "/", ":authority", "test")
+	c.WriteData(1, true, []byte("echo"))
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	f := c.ExpectFrame(frame.FrameData, 1)
+	assert.Equal(t, "echo", string(f.Data))
+}
+
+func TestWindowFollowsReads(t *testing.T) {
+	release := make(chan struct{})
+	c := NewConn(t, session.FuncHandler(func(req *session.Request, res *session.Response) {
+		<-release
+		io.Copy(res, req.Body)
+	}))
+	c.Handshake()
+
+	c.WriteHeaders(1, false, ":method", "POST", ":scheme", "https", ":path", "/", ":authority", "test")
+	// Four octets of data, and five of padding with the pad length.
+	c.WriteFrame(frame.FrameData, session.FLAG_PADDED, 1, []byte{4, 'e', 'c', 'h', 'o', 0, 0, 0, 0})
+	c.ExpectWindowUpdate(0, 5)
+	c.ExpectWindowUpdate(1, 5)
+
+	// The data's share comes back once the handler reads it.
+	close(release)
+	c.ExpectWindowUpdate(0, 4)
+	c.ExpectWindowUpdate(1, 4)
+
+	c.WriteData(1, true, nil)
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	f := c.ExpectFrame(frame.FrameData, 1)
+	assert.Equal(t, "echo", string(f.Data))
+}
+
+func TestUnreadBodyIsDiscarded(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Handshake()
+
+	c.WriteHeaders(1, false, ":method", "POST", ":scheme", "https", ":path", "/", ":authority", "test")
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	c.ExpectFrame(frame.FrameData, 1)
+
+	// The handler is gone, so the body is dropped and its window
+	// given back right away.
+	c.WriteData(1, false, make([]byte, 10))
+	c.ExpectWindowUpdate(0, 10)
+	c.ExpectWindowUpdate(1, 10)
+	// The stream's window isn't needed after END_STREAM.
+	c.WriteData(1, true, make([]byte, 3))
+	c.ExpectWindowUpdate(0, 3)
+
+	c.WriteHeaders(3, true, get...)
+	c.ExpectHeaders(3, session.HeaderField{Name: ":status", Value: "200"})
+}
+
+func TestMalformedRequestIsReset(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Handshake()
+
+	// No :path.
+	c.WriteHeaders(1, true, ":method", "GET", ":scheme", "https", ":authority", "test")
+	c.ExpectRST(1, session.ErrorCodeProtocol)
+
+	// The connection is still usable.
+	c.WriteHeaders(3, true, get...)
+	c.ExpectHeaders(3, session.HeaderField{Name: ":status", Value: "200"})
+}
+
+func TestConnectionErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(c *Conn)
+		code  session.ErrorCode
+	}{
+		{"settings length", func(c *Conn) {
+			c.WriteFrame(frame.FrameSettings, 0, 0, []byte{0, 1, 0})
+		}, session.ErrorCodeFrameSize},
+		{"rst on stream 0", func(c *Conn) {
+			c.WriteRST(0, session.ErrorCodeCancel)
+		}, session.ErrorCodeProtocol},
+		{"rst length", func(c *Conn) {
+			c.WriteFrame(frame.FrameResetStream, 0, 1, []byte{0, 0, 8})
+		}, session.ErrorCodeFrameSize},
+		{"padded headers without pad length", func(c *Conn) {
+			c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_PADDED, 1, nil)
+		}, session.ErrorCodeFrameSize},
+		{"headers too short for priority", func(c *Conn) {
+			c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_PRIORITY, 1, []byte{0, 0, 0})
+		}, session.ErrorCodeFrameSize},
+		{"padded and priority headers too short", func(c *Conn) {
+			c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_PADDED|session.FLAG_PRIORITY, 1, []byte{0, 0, 0, 0, 0})
+		}, session.ErrorCodeFrameSize},
+		{"padding longer than headers", func(c *Conn) {
+			c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_PADDED, 1, []byte{9, 0x82})
+		}, session.ErrorCodeProtocol},
+		{"padded data without pad length", func(c *Conn) {
+			c.WriteFrame(frame.FrameData, session.FLAG_PADDED, 1, nil)
+		}, session.ErrorCodeFrameSize},
+		{"bad header block", func(c *Conn) {
+			// An index past the end of both tables.
+			c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_END_STREAM, 1, []byte{0xff, 0x7f})
+		}, session.ErrorCodeCompression},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewConn(t, session.FuncHandler(hello))
+			c.Handshake()
+			tc.write(c)
+			c.ExpectGoAway(tc.code)
+			c.ExpectClosed()
+
+			var ce *session.ConnError
+			require.ErrorAs(t, c.Wait(), &ce)
+			assert.Equal(t, tc.code, ce.ErrorCode)
+		})
+	}
+}
+
+func TestFramesAfterClientClosed(t *testing.T) {
+	hold := []string{":method", "POST", ":scheme", "https", ":path", "/hold", ":authority", "test"}
+	cases := []struct {
+		name  string
+		close func(c *Conn)
+		write func(c *Conn)
+	}{
+		{"data after end stream", func(c *Conn) {
+			c.WriteHeaders(1, true, hold...)
+		}, func(c *Conn) {
+			c.WriteData(1, false, []byte("late"))
+		}},
+		{"headers after end stream", func(c *Conn) {
+			c.WriteHeaders(1, true, hold...)
+		}, func(c *Conn) {
+			c.WriteHeaders(1, true, "x-trailer", "late")
+		}},
+		{"data after rst", func(c *Conn) {
+			c.WriteHeaders(1, false, hold...)
+			c.WriteRST(1, session.ErrorCodeCancel)
+		}, func(c *Conn) {
+			c.WriteData(1, true, []byte("late"))
+		}},
+		{"headers after rst", func(c *Conn) {
+			c.WriteHeaders(1, false, hold...)
+			c.WriteRST(1, session.ErrorCodeCancel)
+		}, func(c *Conn) {
+			c.WriteHeaders(1, true, "x-trailer", "late")
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Holding the response back keeps stream 1 quiet
+			// until the server resets it.
+			c := NewConn(t, session.FuncHandler(func(req *session.Request, res *session.Response) {
+				if req.URL().Path == "/hold" {
+					<-req.Context().Done()
+					return
+				}
+				hello(req, res)
+			}))
+			c.Handshake()
+			c.Ignore(frame.FrameWindowUpdate)
+
+			tc.close(c)
+			tc.write(c)
+			c.ExpectRST(1, session.ErrorCodeStreamClosed)
+
+			// The connection is still usable.
+			c.WriteHeaders(3, true, get...)
+			c.ExpectHeaders(3, session.HeaderField{Name: ":status", Value: "200"})
+		})
+	}
+}
+
+func TestClientGoAway(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Handshake()
+	c.WriteGoAway(0, session.ErrorCodeNoError, "bye")
+	gf := c.ExpectGoAway(session.ErrorCodeNoError)
+	assert.Equal(t, frame.Sid(0), gf.LastStreamId)
+	c.ExpectClosed()
+}
+
+func TestShutdown(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Handshake()
+	c.Sess.Shutdown()
+	gf := c.ExpectGoAway(session.ErrorCodeNoError)
+	assert.Equal(t, frame.Sid(1<<31-1), gf.LastStreamId)
+	ping := c.ExpectFrame(frame.FramePing, 0)
+	assert.False(t, c.Sess.Idle())
+
+	// A stream that crossed the first GOAWAY is still served.
+	c.WriteHeaders(1, true, get...)
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	c.ExpectFrame(frame.FrameData, 1)
+
+	// The answer to the PING brings the real last stream ID.
+	c.WritePing(true, [8]byte(ping.Data))
+	gf = c.ExpectGoAway(session.ErrorCodeNoError)
+	assert.Equal(t, frame.Sid(1), gf.LastStreamId)
+
+	// Streams opened after that are refused.
+	c.WriteHeaders(3, true, get...)
+	c.ExpectRST(3, session.ErrorCodeRefusedStream)
+}
+
+func TestShutdownDuringHandshake(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.Sess.Shutdown()
+	// There's nothing to wait for yet.
+	assert.True(t, c.Sess.Idle())
+
+	// The GOAWAY waits for the server's SETTINGS.
+	c.Handshake()
+	gf := c.ExpectGoAway(session.ErrorCodeNoError)
+	assert.Equal(t, frame.Sid(1<<31-1), gf.LastStreamId)
+	c.ExpectFrame(frame.FramePing, 0)
+	assert.False(t, c.Sess.Idle())
+}
+
+func TestShutdownWithoutPingAck(t *testing.T) {
+	clock := session.NewFakeClock(time.Unix(0, 0))
+	c := NewUnstartedConn(t, session.FuncHandler(hello))
+	c.Sess.Clock = clock
+	c.Start()
+	c.Handshake()
+	c.Sess.Shutdown()
+	c.ExpectGoAway(session.ErrorCodeNoError)
+	c.ExpectFrame(frame.FramePing, 0)
+
+	go clock.Advance(time.Second)
+	gf := c.ExpectGoAway(session.ErrorCodeNoError)
+	assert.Equal(t, frame.Sid(0), gf.LastStreamId)
+}
+
+func TestLocalHeaderTableSize(t *testing.T) {
+	c := NewUnstartedConn(t, session.FuncHandler(hello))
+	c.Sess.LocalSettings.Put(settings.HeaderTableSize, 8192)
+	c.Start()
+	c.Handshake()
+
+	// The client may grow its table up to what the server advertised.
+	c.enc.SetMaxSize(8192)
+	block := append(hpack.TableSizeUpdate(8192).Encode(), c.EncodeHeaders(get...)...)
+	c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_END_STREAM, 1, block)
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	c.ExpectFrame(frame.FrameData, 1)
+
+	// But no further.
+	c.WriteFrame(frame.FrameHeaders, session.FLAG_END_HEADERS|session.FLAG_END_STREAM, 3, hpack.TableSizeUpdate(8193).Encode())
+	c.ExpectGoAway(session.ErrorCodeCompression)
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	release := make(chan struct{})
+	c := NewUnstartedConn(t, session.FuncHandler(func(req *session.Request, res *session.Response) {
+		if req.URL().Path == "/slow" {
+			<-release
+		}
+		hello(req, res)
+	}))
+	c.Sess.LocalSettings.Put(settings.MaxConcurrentStreams, 1)
+	c.Start()
+	c.Handshake()
+
+	c.WriteHeaders(1, true, ":method", "GET", ":scheme", "https", ":path", "/slow", ":authority", "test")
+	c.WriteHeaders(3, true, get...)
+	c.ExpectRST(3, session.ErrorCodeRefusedStream)
+
+	close(release)
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+	c.ExpectFrame(frame.FrameData, 1)
+
+	// Once the first stream is done there's room for another.
+	c.WriteHeaders(5, true, get...)
+	c.ExpectHeaders(5, session.HeaderField{Name: ":status", Value: "200"})
+}
+
+func TestMaxFrameSize(t *testing.T) {
+	echo := session.FuncHandler(func(req *session.Request, res *session.Response) {
+		io.Copy(res, req.Body)
+	})
+	post := []string{":method", "POST", ":scheme", "https", ":path", "/", ":authority", "test"}
+	big := make([]byte, 1<<14+1)
+
+	c := NewConn(t, echo)
+	c.Handshake()
+	c.WriteHeaders(1, false, post...)
+	// Only the header of the DATA frame; the server gives up
+	// without reading the payload.
+	c.Write([]byte{0x00, 0x40, 0x01, byte(frame.FrameData), session.FLAG_END_STREAM, 0, 0, 0, 1})
+	c.ExpectGoAway(session.ErrorCodeFrameSize)
+
+	c = NewUnstartedConn(t, echo)
+	c.Sess.LocalSettings.Put(settings.MaxFrameSize, 1<<15)
+	c.Start()
+	c.Handshake()
+	c.Ignore(frame.FrameWindowUpdate)
+	c.WriteHeaders(1, false, post...)
+	c.WriteData(1, true, big)
+	c.ExpectHeaders(1, session.HeaderField{Name: ":status", Value: "200"})
+}
+
+func TestUnsupportedLocalSetting(t *testing.T) {
+	c := NewUnstartedConn(t, session.FuncHandler(hello))
+	c.Sess.LocalSettings.Put(settings.InitialWindowSize, 1<<20)
+	c.Start()
+	c.WritePreface()
+	c.ExpectClosed()
+	assert.ErrorIs(t, c.Wait(), session.UnsupportedSetting)
+}
+
+func TestHandshakeSettingsLength(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	c.WritePreface()
+	c.WriteFrame(frame.FrameSettings, 0, 0, []byte{0, 1, 0})
+	c.ExpectFrame(frame.FrameSettings, 0)
+	c.ExpectGoAway(session.ErrorCodeFrameSize)
+}
+
+func TestClientHeaderTableSize(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(hello))
+	var local settings.SettingsList
+	local.Put(settings.HeaderTableSize, 0)
+	c.HandshakeWith(local)
+
+	// Responses can't refer to the dynamic table, which the client
+	// has no room for.
+	for _, sid := range []frame.Sid{1, 3} {
+		c.WriteHeaders(sid, true, get...)
+		c.ExpectHeaders(sid, session.HeaderField{Name: "content-type", Value: "text/plain"})
+		c.ExpectFrame(frame.FrameData, sid)
+	}
+	assert.Zero(t, c.dec.MaxSize())
+}
+
+func TestHandlerResetWhileReading(t *testing.T) {
+	c := NewConn(t, session.FuncHandler(func(req *session.Request, res *session.Response) {
+		res.Reset(session.ErrorCodeCancel)
+	}))
+	c.Handshake()
+	c.Ignore(frame.FrameWindowUpdate)
+
+	// Frames keep arriving on the stream while the handler resets
+	// it. Empty DATA frames make the server look at the stream
+	// without sending anything first.
+	c.WriteHeaders(1, false, ":method", "POST", ":scheme", "https", ":path", "/", ":authority", "test")
+	for range 50 {
+		c.WriteData(1, false, nil)
+	}
+	c.WriteHeaders(1, true, "x-trailer", "yes")
+	c.ExpectRST(1, session.ErrorCodeCancel)
+
+	c.WriteHeaders(3, true, get...)
+	c.ExpectRST(3, session.ErrorCodeCancel)
+}
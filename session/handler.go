@@ -4,11 +4,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"http2/frame"
-	"http2/hpack"
-	"http2/pkg/bodystream"
-	"http2/session/settings"
 	"strconv"
+	"strings"
 )
 
 type HttpCode int
@@ -36,54 +33,204 @@ const (
 	NotFound         = 404
 	MethodNotAllowed = 405
 
+	RangeNotSatisfiable = 416
+
 	ServerError    = 500
 	NotImplemented = 501
 )
 
-type Request struct {
-	Headers []stringpair
-	Body    *bodystream.BodyStream
-}
-
-func (req *Request) GetHeader(k string) string {
-	for _, pair := range req.Headers {
-		if pair.k == k {
-			return pair.v
-		}
-	}
-	return ""
-}
+var (
+	HeadersAlreadyWritten = errors.New("response headers already written")
+	InvalidStatusCode     = errors.New("invalid status code")
+	BodyNotAllowed        = errors.New("response status doesn't allow a body")
+	ContentLengthExceeded = errors.New("wrote more than the declared content-length")
+	ResponseReset         = errors.New("response stream was reset")
+)
 
+// A Response is built by a Handler. The status and header fields are
+// committed by WriteHeader, or by the first call to Write, and sent
+// with the first DATA frame. Bodies small enough to be buffered until
+// the handler returns get a content-length automatically.
 type Response struct {
 	Code        HttpCode
+	wroteHeader bool
 	headersSent bool
 	headers     []stringpair
+	trailer     Header
 	body        *bytes.Buffer
-	stream      *Stream
+	req         *Request
+	out         responseSink
+
+	// Octets passed to Write, and the declared
+	// content-length (-1 if there is none).
+	written       int64
+	contentLength int64
+
+	reset bool
+
+	// Set by EnableCompression.
+	encoder *bodyEncoder
+}
+
+// A responseSink is where a Response's header blocks and body go:
+// a Stream, or a net/http ResponseWriter when a Handler is mounted
+// in a net/http server.
+type responseSink interface {
+	writeHeaders(fields []stringpair, endStream bool) error
+	writeData(data []byte, endStream bool) error
+	Reset(code ErrorCode) error
+	maxFrameSize() int
+}
+
+func newResponse(req *Request, out responseSink) *Response {
+	return &Response{
+		body:          bytes.NewBuffer(nil),
+		req:           req,
+		out:           out,
+		contentLength: -1,
+	}
+}
+
+// Trailer returns the fields to send in a HEADERS frame after the
+// body. Fields can be added until the handler returns; names added
+// before the response headers are sent are announced up front in a
+// trailer header.
+func (res *Response) Trailer() Header {
+	if res.trailer == nil {
+		res.trailer = make(Header)
+	}
+	return res.trailer
+}
+
+// trailerFields returns the trailers to send, sorted by name.
+func (res *Response) trailerFields() []stringpair {
+	var ret []stringpair
+	for _, k := range res.trailer.names() {
+		if strings.HasPrefix(k, ":") {
+			continue
+		}
+		for _, v := range res.trailer[k] {
+			ret = append(ret, stringpair{k, v})
+		}
+	}
+	return ret
 }
 
-func (res *Response) SetHeader(k, v string) {
+// SetHeader adds a response header field. Setting ":status" is the
+// same as calling SetResponseCode.
+func (res *Response) SetHeader(k, v string) error {
 	if k == ":status" {
 		code, err := strconv.Atoi(v)
 		if err != nil {
-			panic(fmt.Sprintf("Attempt to set non-int status code: %s", v))
+			return fmt.Errorf("%w: %q", InvalidStatusCode, v)
 		}
-		res.SetResponseCode(HttpCode(code))
-	} else {
-		res.headers = append(res.headers, stringpair{k, v})
+		return res.SetResponseCode(HttpCode(code))
+	}
+	if res.wroteHeader {
+		return HeadersAlreadyWritten
+	}
+	k = CanonicalHeaderKey(k)
+	if err := validateRegularField(k, v); err != nil {
+		return err
+	}
+	if k == "content-length" {
+		cl, err := parseContentLength([]string{v})
+		if err != nil {
+			return err
+		}
+		res.contentLength = cl
+	}
+	res.headers = append(res.headers, stringpair{k, v})
+	return nil
+}
+
+// SetResponseCode sets the status without committing it,
+// so headers can still be added.
+func (res *Response) SetResponseCode(code HttpCode) error {
+	if res.wroteHeader {
+		return HeadersAlreadyWritten
+	}
+	if code < 100 || code > 999 {
+		return fmt.Errorf("%w: %d", InvalidStatusCode, code)
+	}
+	res.Code = code
+	return nil
+}
+
+// WriteHeader commits the status and header fields. It's an error to
+// change either afterwards.
+func (res *Response) WriteHeader(code HttpCode) error {
+	if err := res.SetResponseCode(code); err != nil {
+		return err
+	}
+	res.wroteHeader = true
+	return nil
+}
+
+// Status is the response code that was or will be sent.
+func (res *Response) Status() HttpCode {
+	if res.Code == CodeUnset {
+		return Ok
+	}
+	return res.Code
+}
+
+// Written is the number of body octets the handler has written.
+func (res *Response) Written() int64 {
+	return res.written
+}
+
+// Reset abandons the response and resets the stream with code.
+// Nothing more is sent once the stream is reset.
+func (res *Response) Reset(code ErrorCode) error {
+	if res.reset {
+		return nil
 	}
+	res.reset = true
+	return res.out.Reset(code)
+}
+
+// bodyAllowed reports whether the response can carry content
+// (RFC 9110 §6.4.1).
+func (res *Response) bodyAllowed() bool {
+	code := res.Status()
+	return code >= 200 && code != NoContent && code != NotModified
 }
 
+// isHead reports whether the body is generated but not sent,
+// as for a HEAD request.
+func (res *Response) isHead() bool {
+	return res.req != nil && res.req.Method() == "HEAD"
+}
+
+// Flush sends the header block, if it hasn't been sent, and any
+// buffered body. The stream stays open.
 func (res *Response) Flush() error {
-	maxFrameSize, ok := res.stream.Context.Settings.Get(settings.MaxFrameSize)
-	if !ok {
-		maxFrameSize, _ = settings.Default(settings.MaxFrameSize)
+	res.wroteHeader = true
+	if res.reset {
+		return ResponseReset
+	}
+	if err := res.flushEncoder(); err != nil {
+		return err
+	}
+	if !res.headersSent {
+		if err := res.sendHeaders(false); err != nil {
+			return err
+		}
 	}
-	buf := make([]byte, maxFrameSize)
-	for res.body.Len() > 0 {
+	return res.sendData(true, false)
+}
+
+// sendData sends the buffered body in frames no bigger than the
+// peer's maximum. If all is false, a partial final frame is kept
+// back so it can carry END_STREAM later; endStream marks the
+// last frame sent.
+func (res *Response) sendData(all, endStream bool) error {
+	size := res.out.maxFrameSize()
+	buf := make([]byte, size)
+	for res.body.Len() > 0 && (all || res.body.Len() >= size) {
 		nRead, _ := res.body.Read(buf)
-		err := res.stream.SendFrame(frame.FrameData, 0, buf[:nRead])
-		if err != nil {
+		if err := res.out.writeData(buf[:nRead], endStream && res.body.Len() == 0); err != nil {
 			return err
 		}
 	}
@@ -91,52 +238,107 @@ func (res *Response) Flush() error {
 }
 
 func (res *Response) Write(data []byte) (n int, err error) {
-	if !res.headersSent {
-		err := res.sendHeaders(false)
-		if err != nil {
-			return 0, err
+	res.wroteHeader = true
+	if res.reset {
+		return 0, ResponseReset
+	}
+	if !res.bodyAllowed() {
+		return 0, BodyNotAllowed
+	}
+	if res.contentLength >= 0 && res.written+int64(len(data)) > res.contentLength {
+		return 0, ContentLengthExceeded
+	}
+	if res.isHead() {
+		res.written += int64(len(data))
+		if res.encoder != nil {
+			// A GET would have to decide once it streams.
+			now := res.written > int64(res.out.maxFrameSize())
+			return len(data), res.decideEncoding(now, res.encoder.minSize)
 		}
+		return len(data), nil
 	}
-	n, err = res.body.Write(data)
+	n, err = res.writeBody(data)
+	res.written += int64(n)
 	if err != nil {
 		return
 	}
-	maxFrameSize, ok := res.stream.Context.Settings.Get(settings.MaxFrameSize)
-	if !ok {
-		maxFrameSize, _ = settings.Default(settings.MaxFrameSize)
-	}
-	if res.body.Len() > int(maxFrameSize) {
-		res.Flush()
+	if res.body.Len() > res.out.maxFrameSize() {
+		if err := res.decideEncoding(true, 0); err != nil {
+			return n, err
+		}
+		if !res.headersSent {
+			if err := res.sendHeaders(false); err != nil {
+				return n, err
+			}
+		}
+		err = res.sendData(false, false)
 	}
 	return
 }
 
-func (res *Response) SetResponseCode(code HttpCode) {
-	res.Code = code
+// finish ends the stream once the handler has returned, putting
+// END_STREAM on the last frame sent.
+func (res *Response) finish() error {
+	res.wroteHeader = true
+	if res.reset {
+		return nil
+	}
+	if res.contentLength > res.written && res.bodyAllowed() && !res.isHead() {
+		res.Reset(ErrorCodeInternal)
+		return fmt.Errorf("content-length %d, but handler wrote %d octets", res.contentLength, res.written)
+	}
+	if err := res.closeEncoder(); err != nil {
+		res.Reset(ErrorCodeInternal)
+		return err
+	}
+	trailers := res.trailerFields()
+	last := len(trailers) == 0
+
+	if !res.headersSent {
+		// The whole body is known; tell the client how long it is.
+		// A compressed HEAD response can't, since nothing was
+		// actually compressed.
+		headEncoded := res.isHead() && res.encoder != nil && res.encoder.encoded
+		if res.contentLength < 0 && res.bodyAllowed() && !headEncoded {
+			n := res.written
+			if !res.isHead() {
+				n = int64(res.body.Len())
+			}
+			res.contentLength = n
+			res.headers = append(res.headers, stringpair{"content-length", strconv.FormatInt(n, 10)})
+		}
+		bodiless := res.body.Len() == 0
+		if err := res.sendHeaders(bodiless && last); err != nil {
+			return err
+		}
+		if bodiless && last {
+			return nil
+		}
+	}
+	if res.body.Len() > 0 {
+		if err := res.sendData(true, last); err != nil {
+			return err
+		}
+	} else if last {
+		return res.out.writeData(nil, true)
+	}
+	if !last {
+		return res.out.writeHeaders(trailers, true)
+	}
+	return nil
 }
 
 func (res *Response) sendHeaders(endStream bool) error {
 	if res.headersSent {
-		return errors.New("already sent headers")
-	}
-	hl := hpack.NewHeaderList(res.stream.Context.outgoingHeadertable)
-	code := res.Code
-	if code == CodeUnset {
-		code = Ok
+		return HeadersAlreadyWritten
 	}
-	hl.Put(":status", strconv.Itoa(int(code)))
-	if res.headers != nil {
-		for _, pair := range res.headers {
-			hl.Put(pair.k, pair.v)
-		}
+	fields := append([]stringpair{{":status", strconv.Itoa(int(res.Status()))}}, res.headers...)
+	if len(res.trailer) > 0 {
+		names := res.trailer.names()
+		fields = append(fields, stringpair{"trailer", strings.Join(names, ", ")})
 	}
-	flags := FLAG_END_HEADERS
-	if endStream {
-		flags |= FLAG_END_STREAM
-	}
-	res.stream.SendFrame(frame.FrameHeaders, flags, hl.Dump())
 	res.headersSent = true
-	return nil
+	return res.out.writeHeaders(fields, endStream)
 }
 
 type Handler interface {
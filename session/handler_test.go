@@ -0,0 +1,225 @@
+package session
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"http2/frame"
+	"http2/hpack"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sentFrame struct {
+	Type    frame.FrameType
+	Flags   uint8
+	Data    []byte
+	Headers []stringpair
+}
+
+// serve runs h for a request with the given method and returns the
+// frames written to the client.
+func serve(t *testing.T, method string, h FuncHandler) []sentFrame {
+	var out bytes.Buffer
+	ctx := NewConnectionContext(nil, &out, h)
+	st := NewStream(1, ctx)
+	st.Request = &Request{
+		method:        method,
+		url:           &url.URL{Path: "/"},
+		requestURI:    "/",
+		header:        make(Header),
+		contentLength: -1,
+	}
+	st.Serve(ctx)
+
+	var ret []sentFrame
+	tbl := hpack.NewHeaderLookupTable()
+	fr := frame.NewFramer(&out)
+	for {
+		f, err := fr.ReadFrame()
+		if err == io.EOF {
+			return ret
+		}
+		require.NoError(t, err)
+		sf := sentFrame{Type: f.FrameHeader.Type, Flags: f.FrameHeader.Flags, Data: f.Data}
+		if sf.Type == frame.FrameHeaders {
+			require.NoError(t, hpack.DecodeBlock(tbl, f.Data, func(k, v string) {
+				sf.Headers = append(sf.Headers, stringpair{k, v})
+			}))
+		}
+		ret = append(ret, sf)
+	}
+}
+
+func TestResponseTrailerFields(t *testing.T) {
+	res := &Response{}
+	assert.Nil(t, res.trailerFields())
+
+	res.Trailer().Set("X-Checksum", "abc")
+	res.Trailer().Add("grpc-message", "one")
+	res.Trailer().Add("grpc-message", "two")
+	res.Trailer().Set(":status", "200")
+	assert.Equal(t, pairs("grpc-message", "one", "grpc-message", "two", "x-checksum", "abc"), res.trailerFields())
+}
+
+func TestResponseBuffered(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		res.SetHeader("Content-Type", "text/plain")
+		io.WriteString(res, "hello")
+	})
+	require.Len(t, frames, 2)
+	assert.Equal(t, FLAG_END_HEADERS, frames[0].Flags)
+	assert.Equal(t, pairs(":status", "200", "content-type", "text/plain", "content-length", "5"), frames[0].Headers)
+	assert.Equal(t, frame.FrameData, frames[1].Type)
+	assert.Equal(t, FLAG_END_STREAM, frames[1].Flags)
+	assert.Equal(t, []byte("hello"), frames[1].Data)
+}
+
+func TestResponseEmpty(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		res.WriteHeader(NotFound)
+	})
+	require.Len(t, frames, 1)
+	assert.Equal(t, FLAG_END_HEADERS|FLAG_END_STREAM, frames[0].Flags)
+	assert.Equal(t, pairs(":status", "404", "content-length", "0"), frames[0].Headers)
+}
+
+func TestResponseLarge(t *testing.T) {
+	body := strings.Repeat("x", 40000)
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		io.WriteString(res, body)
+	})
+	// Flushed before the handler returned, so the length isn't known.
+	assert.Equal(t, pairs(":status", "200"), frames[0].Headers)
+
+	var got []byte
+	for i, f := range frames[1:] {
+		assert.Equal(t, frame.FrameData, f.Type)
+		assert.Equal(t, i == len(frames)-2, f.Flags&FLAG_END_STREAM != 0)
+		got = append(got, f.Data...)
+	}
+	assert.Equal(t, body, string(got))
+}
+
+func TestResponseFlushed(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		io.WriteString(res, "data: 1\n\n")
+		res.Flush()
+	})
+	require.Len(t, frames, 3)
+	assert.Equal(t, uint8(0), frames[1].Flags)
+	assert.Equal(t, FLAG_END_STREAM, frames[2].Flags)
+	assert.Empty(t, frames[2].Data)
+}
+
+func TestResponseHead(t *testing.T) {
+	frames := serve(t, "HEAD", func(req *Request, res *Response) {
+		io.WriteString(res, "hello")
+	})
+	require.Len(t, frames, 1)
+	assert.Equal(t, FLAG_END_HEADERS|FLAG_END_STREAM, frames[0].Flags)
+	assert.Equal(t, pairs(":status", "200", "content-length", "5"), frames[0].Headers)
+}
+
+func TestResponseNoContent(t *testing.T) {
+	for _, code := range []HttpCode{NoContent, NotModified} {
+		frames := serve(t, "GET", func(req *Request, res *Response) {
+			res.WriteHeader(code)
+			_, err := io.WriteString(res, "hello")
+			assert.ErrorIs(t, err, BodyNotAllowed)
+		})
+		require.Len(t, frames, 1)
+		assert.Equal(t, FLAG_END_HEADERS|FLAG_END_STREAM, frames[0].Flags)
+		assert.Len(t, frames[0].Headers, 1)
+	}
+}
+
+func TestResponseTrailers(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		res.Trailer().Set("x-checksum", "")
+		io.WriteString(res, "hello")
+		res.Trailer().Set("x-checksum", "abc")
+	})
+	require.Len(t, frames, 3)
+	assert.Contains(t, frames[0].Headers, stringpair{"trailer", "x-checksum"})
+	assert.Equal(t, uint8(0), frames[1].Flags)
+	assert.Equal(t, FLAG_END_HEADERS|FLAG_END_STREAM, frames[2].Flags)
+	assert.Equal(t, pairs("x-checksum", "abc"), frames[2].Headers)
+}
+
+func TestResponseErrors(t *testing.T) {
+	serve(t, "GET", func(req *Request, res *Response) {
+		assert.ErrorIs(t, res.SetHeader(":status", "ok"), InvalidStatusCode)
+		assert.ErrorIs(t, res.SetResponseCode(42), InvalidStatusCode)
+		assert.ErrorIs(t, res.SetHeader("connection", "close"), MalformedRequest)
+		require.NoError(t, res.SetHeader("content-length", "2"))
+		_, err := io.WriteString(res, "abc")
+		assert.ErrorIs(t, err, ContentLengthExceeded)
+		io.WriteString(res, "ab")
+		assert.ErrorIs(t, res.WriteHeader(ServerError), HeadersAlreadyWritten)
+		assert.ErrorIs(t, res.SetHeader("x", "y"), HeadersAlreadyWritten)
+	})
+}
+
+func TestResponseShortContentLength(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		res.SetHeader("content-length", "10")
+		io.WriteString(res, "abc")
+	})
+	require.Len(t, frames, 1)
+	assert.Equal(t, frame.FrameResetStream, frames[0].Type)
+}
+
+func TestResponseCompressed(t *testing.T) {
+	body := strings.Repeat("abc", 1000)
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		require.NoError(t, res.EnableCompression("gzip", gzip.DefaultCompression, 100))
+		io.WriteString(res, body)
+	})
+	require.Len(t, frames, 2)
+	assert.Contains(t, frames[0].Headers, stringpair{"content-encoding", "gzip"})
+	assert.Contains(t, frames[0].Headers, stringpair{"content-length", strconv.Itoa(len(frames[1].Data))})
+
+	zr, err := gzip.NewReader(bytes.NewReader(frames[1].Data))
+	require.NoError(t, err)
+	got, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(got))
+}
+
+func TestResponseCompressedFlush(t *testing.T) {
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		res.SetHeader("content-type", "text/event-stream")
+		res.EnableCompression("gzip", gzip.DefaultCompression, 1024)
+		io.WriteString(res, "data: 1\n\n")
+		res.Flush()
+		io.WriteString(res, "data: 2\n\n")
+		res.Flush()
+	})
+	require.Len(t, frames, 4)
+	assert.Contains(t, frames[0].Headers, stringpair{"content-encoding", "gzip"})
+
+	// Each flush sends enough to decode the events written before it.
+	zr, err := gzip.NewReader(bytes.NewReader(frames[1].Data))
+	require.NoError(t, err)
+	got := make([]byte, 9)
+	_, err = io.ReadFull(zr, got)
+	require.NoError(t, err)
+	assert.Equal(t, "data: 1\n\n", string(got))
+
+	var all []byte
+	for _, f := range frames[1:] {
+		all = append(all, f.Data...)
+	}
+	zr, err = gzip.NewReader(bytes.NewReader(all))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "data: 1\n\ndata: 2\n\n", string(rest))
+}
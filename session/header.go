@@ -0,0 +1,59 @@
+package session
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Header maps field names to their values. HTTP/2 field names are
+// lowercase on the wire, so keys are canonicalized to lowercase.
+type Header map[string][]string
+
+// CanonicalHeaderKey returns the form of k used as a Header key.
+func CanonicalHeaderKey(k string) string {
+	return strings.ToLower(k)
+}
+
+// Get returns the first value for k, or "" if there is none.
+func (h Header) Get(k string) string {
+	if vs := h[CanonicalHeaderKey(k)]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Values returns every value for k in the order they were received.
+func (h Header) Values(k string) []string {
+	return h[CanonicalHeaderKey(k)]
+}
+
+func (h Header) Add(k, v string) {
+	k = CanonicalHeaderKey(k)
+	h[k] = append(h[k], v)
+}
+
+func (h Header) Set(k, v string) {
+	h[CanonicalHeaderKey(k)] = []string{v}
+}
+
+func (h Header) Del(k string) {
+	delete(h, CanonicalHeaderKey(k))
+}
+
+func (h Header) Clone() Header {
+	ret := make(Header, len(h))
+	for k, vs := range h {
+		ret[k] = append([]string(nil), vs...)
+	}
+	return ret
+}
+
+// names returns the keys of h in sorted order.
+func (h Header) names() []string {
+	ret := make([]string, 0, len(h))
+	for k := range h {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,291 @@
+package session
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"http2/pkg/bodystream"
+)
+
+// FromHTTPHandler serves a net/http Handler on a Dispatcher. Each
+// request is translated into an *http.Request, and the handler writes
+// its response through an http.ResponseWriter that also implements
+// http.Flusher and net/http's trailer conventions.
+func FromHTTPHandler(h http.Handler) Handler {
+	return FuncHandler(func(req *Request, res *Response) {
+		w := &responseWriter{res: res, header: make(http.Header)}
+		h.ServeHTTP(w, newHTTPRequest(req))
+		w.finish()
+	})
+}
+
+// newHTTPRequest builds the net/http form of a request, the way
+// net/http's own HTTP/2 server would.
+func newHTTPRequest(req *Request) *http.Request {
+	header := make(http.Header, len(req.header))
+	for k, vs := range req.header {
+		// The handler may change its copy.
+		header[http.CanonicalHeaderKey(k)] = slices.Clone(vs)
+	}
+	u := *req.URL()
+	hr := &http.Request{
+		Method:        req.Method(),
+		URL:           &u,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		ContentLength: req.ContentLength(),
+		Host:          req.Authority(),
+		RemoteAddr:    req.RemoteAddr(),
+		RequestURI:    req.RequestURI(),
+		TLS:           req.TLS(),
+	}
+	for _, v := range header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if hr.Trailer == nil {
+				hr.Trailer = make(http.Header)
+			}
+			hr.Trailer[http.CanonicalHeaderKey(strings.TrimSpace(k))] = nil
+		}
+	}
+	// WithContext copies the request, so the body has to point at
+	// the copy the handler gets.
+	hr = hr.WithContext(req.Context())
+	hr.Body = http.NoBody
+	if req.Body != nil {
+		hr.Body = &requestBody{req: req, hr: hr}
+	}
+	return hr
+}
+
+// requestBody copies the request's trailers into the *http.Request
+// once the body has been read, as net/http does.
+type requestBody struct {
+	req *Request
+	hr  *http.Request
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.req.Body.Read(p)
+	if err == io.EOF {
+		for k, vs := range b.req.Trailer() {
+			if b.hr.Trailer == nil {
+				b.hr.Trailer = make(http.Header)
+			}
+			b.hr.Trailer[http.CanonicalHeaderKey(k)] = slices.Clone(vs)
+		}
+	}
+	return n, err
+}
+
+// Close doesn't close the underlying stream; the Dispatcher
+// owns that.
+func (b *requestBody) Close() error {
+	return nil
+}
+
+// responseWriter implements http.ResponseWriter on top of a Response.
+type responseWriter struct {
+	res         *Response
+	header      http.Header
+	wroteHeader bool
+	// Trailer names declared in the Trailer header before
+	// WriteHeader.
+	declared []string
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	for _, v := range w.header.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+			if k == "" {
+				continue
+			}
+			w.declared = append(w.declared, k)
+			w.res.Trailer()[CanonicalHeaderKey(k)] = nil
+		}
+	}
+	for k, vs := range w.header {
+		if k == "Trailer" || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		for _, v := range vs {
+			// Fields HTTP/2 can't carry, such as Connection,
+			// are dropped as net/http's server does.
+			w.res.SetHeader(k, v)
+		}
+	}
+	w.res.WriteHeader(HttpCode(code))
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if _, ok := w.header["Content-Type"]; !ok && len(p) > 0 && w.header.Get("Content-Encoding") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.res.Write(p)
+}
+
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.res.Flush()
+}
+
+// finish moves trailer values from the header map into the Response
+// once the handler has returned.
+func (w *responseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	for _, k := range w.declared {
+		for _, v := range w.header.Values(k) {
+			w.res.Trailer().Add(k, v)
+		}
+	}
+	for k, vs := range w.header {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			for _, v := range vs {
+				w.res.Trailer().Add(name, v)
+			}
+		}
+	}
+}
+
+// ToHTTPHandler mounts a Handler in a net/http server, which is handy
+// for comparing it against other servers.
+func ToHTTPHandler(h Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := newRequestFromHTTP(r)
+		res := newResponse(req, &httpSink{w: w})
+		h.Handle(req, res)
+		res.finish()
+	})
+}
+
+func newRequestFromHTTP(r *http.Request) *Request {
+	req := &Request{
+		method:        r.Method,
+		scheme:        "http",
+		authority:     r.Host,
+		url:           r.URL,
+		requestURI:    r.RequestURI,
+		header:        make(Header),
+		trailer:       make(Header),
+		contentLength: r.ContentLength,
+		remoteAddr:    r.RemoteAddr,
+		tls:           r.TLS,
+		ctx:           r.Context(),
+	}
+	if r.TLS != nil {
+		req.scheme = "https"
+	}
+	if r.Method == "CONNECT" {
+		req.url = &url.URL{Host: r.Host}
+		req.requestURI = r.Host
+	}
+	if req.contentLength == 0 && r.Body != http.NoBody {
+		req.contentLength = -1
+	}
+
+	req.Headers = append(req.Headers, stringpair{":method", req.method})
+	if req.method != "CONNECT" {
+		req.Headers = append(req.Headers,
+			stringpair{":scheme", req.scheme},
+			stringpair{":path", req.requestURI},
+		)
+	}
+	req.Headers = append(req.Headers, stringpair{":authority", req.authority})
+	for k, vs := range r.Header {
+		k = CanonicalHeaderKey(k)
+		for _, v := range vs {
+			req.header.Add(k, v)
+			req.Headers = append(req.Headers, stringpair{k, v})
+		}
+	}
+
+	// The handler reads r.Body itself, so nothing touches it once
+	// ServeHTTP returns, as net/http requires.
+	var body io.Reader = http.NoBody
+	if r.Body != nil {
+		body = r.Body
+	}
+	req.Body = bodystream.NewReaderStream(body, func() {
+		for k, vs := range r.Trailer {
+			for _, v := range vs {
+				req.trailer.Add(k, v)
+			}
+		}
+	})
+	return req
+}
+
+// httpSink writes a Response to a net/http ResponseWriter.
+type httpSink struct {
+	w           http.ResponseWriter
+	wroteHeader bool
+}
+
+func (s *httpSink) writeHeaders(fields []stringpair, endStream bool) error {
+	h := s.w.Header()
+	if s.wroteHeader {
+		// The second header block carries trailers.
+		for _, f := range fields {
+			h.Add(http.TrailerPrefix+f.k, f.v)
+		}
+		return nil
+	}
+	code := http.StatusOK
+	for _, f := range fields {
+		switch f.k {
+		case ":status":
+			code, _ = strconv.Atoi(f.v)
+		case "trailer":
+			h.Add("Trailer", f.v)
+		default:
+			h.Add(f.k, f.v)
+		}
+	}
+	// HTTP/1.1 can only send trailers with chunked encoding.
+	if h.Get("Trailer") != "" {
+		h.Del("Content-Length")
+	}
+	s.wroteHeader = true
+	s.w.WriteHeader(code)
+	return nil
+}
+
+func (s *httpSink) writeData(data []byte, endStream bool) error {
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok && !endStream {
+		f.Flush()
+	}
+	return nil
+}
+
+// Reset aborts the response; net/http closes the connection or
+// resets the stream.
+func (s *httpSink) Reset(code ErrorCode) error {
+	panic(http.ErrAbortHandler)
+}
+
+func (s *httpSink) maxFrameSize() int {
+	return 16384
+}
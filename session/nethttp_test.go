@@ -0,0 +1,159 @@
+package session
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"http2/pkg/bodystream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromHTTPHandler(t *testing.T) {
+	var got *http.Request
+	h := FromHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("Connection", "close")
+		io.WriteString(w, "<html></html>")
+		w.Header().Set("X-Checksum", "abc")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "1")
+	}))
+
+	frames := serve(t, "GET", func(req *Request, res *Response) {
+		req.url, _ = url.ParseRequestURI("/a?b=c")
+		req.authority = "example.com"
+		req.header = Header{"accept": {"*/*"}}
+		h.Handle(req, res)
+	})
+
+	require.NotNil(t, got)
+	assert.Equal(t, "GET", got.Method)
+	assert.Equal(t, "/a", got.URL.Path)
+	assert.Equal(t, "c", got.URL.Query().Get("b"))
+	assert.Equal(t, "example.com", got.Host)
+	assert.Equal(t, "*/*", got.Header.Get("Accept"))
+	assert.Equal(t, 2, got.ProtoMajor)
+
+	require.Len(t, frames, 3)
+	assert.Equal(t, pairs(
+		":status", "200",
+		"content-type", "text/html; charset=utf-8",
+		"content-length", "13",
+		"trailer", "x-checksum, x-late",
+	), frames[0].Headers)
+	assert.Equal(t, "<html></html>", string(frames[1].Data))
+	assert.Equal(t, pairs("x-checksum", "abc", "x-late", "1"), frames[2].Headers)
+}
+
+func TestFromHTTPHandlerRequestTrailers(t *testing.T) {
+	req := &Request{
+		url:     &url.URL{Path: "/"},
+		header:  Header{"x-list": {"a", "b"}},
+		trailer: Header{"x-sum": {"42"}},
+		Body:    bodystream.NewBodyStream(),
+	}
+	io.WriteString(req.Body, "body")
+	req.Body.Close()
+
+	// No Trailer header was declared, but the handler still sees
+	// what arrived.
+	var trailer http.Header
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		trailer = r.Trailer
+		r.Header["X-List"][0] = "changed"
+	})
+	h.ServeHTTP(httptest.NewRecorder(), newHTTPRequest(req))
+	assert.Equal(t, http.Header{"X-Sum": {"42"}}, trailer)
+	assert.Equal(t, []string{"a", "b"}, req.header["x-list"])
+}
+
+func TestFromHTTPHandlerFlush(t *testing.T) {
+	h := FromHTTPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "one")
+		w.(http.Flusher).Flush()
+	}))
+	frames := serve(t, "GET", h.Handle)
+	require.Len(t, frames, 3)
+	assert.Equal(t, pairs(":status", "202"), frames[0].Headers)
+	assert.Equal(t, "one", string(frames[1].Data))
+	assert.Equal(t, FLAG_END_STREAM, frames[2].Flags)
+}
+
+func TestToHTTPHandler(t *testing.T) {
+	srv := httptest.NewServer(ToHTTPHandler(FuncHandler(func(req *Request, res *Response) {
+		body, _ := io.ReadAll(req.Body)
+		res.SetHeader("x-method", req.Method())
+		res.SetHeader("x-accept", req.Header().Get("accept"))
+		res.SetHeader("x-query", req.Query().Get("q"))
+		res.Trailer().Set("x-checksum", "")
+		res.WriteHeader(Created)
+		res.Write(body)
+		res.Trailer().Set("x-checksum", "abc")
+	})))
+	defer srv.Close()
+
+	r, err := http.NewRequest("POST", srv.URL+"/echo?q=1", strings.NewReader("hello"))
+	require.NoError(t, err)
+	r.Header.Set("Accept", "text/plain")
+	resp, err := srv.Client().Do(r)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "POST", resp.Header.Get("X-Method"))
+	assert.Equal(t, "text/plain", resp.Header.Get("X-Accept"))
+	assert.Equal(t, "1", resp.Header.Get("X-Query"))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+}
+
+// countingReader counts the reads made on it.
+type countingReader struct {
+	io.Reader
+	reads atomic.Int32
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.reads.Add(1)
+	return r.Reader.Read(p)
+}
+
+func TestToHTTPHandlerLeavesBodyAfterReturn(t *testing.T) {
+	h := ToHTTPHandler(FuncHandler(func(req *Request, res *Response) {
+		io.WriteString(res, "ignored the body")
+	}))
+	body := &countingReader{Reader: strings.NewReader("unread")}
+	r := httptest.NewRequest("POST", "/", body)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	// Only the handler reads the body, so nothing does once
+	// ServeHTTP has returned.
+	time.Sleep(20 * time.Millisecond)
+	assert.Zero(t, body.reads.Load())
+	assert.Equal(t, "ignored the body", w.Body.String())
+}
+
+func TestToHTTPHandlerTrailers(t *testing.T) {
+	var got string
+	h := ToHTTPHandler(FuncHandler(func(req *Request, res *Response) {
+		io.Copy(io.Discard, req.Body)
+		got = req.Trailer().Get("x-sum")
+	}))
+	r := httptest.NewRequest("POST", "/", strings.NewReader("body"))
+	r.Trailer = http.Header{"X-Sum": {"42"}}
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "42", got)
+}
@@ -0,0 +1,274 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"http2/frame"
+	"http2/hpack"
+	"http2/session/settings"
+)
+
+// A Replayer feeds the client's side of a capture, as recorded with
+// Server.Capture, to a fresh Dispatcher, and compares the frames it
+// sends with the ones recorded. It turns real traffic into regression
+// tests.
+//
+// Frames are fed in the order they were read, each once the Dispatcher
+// has dealt with the one before, on a FakeClock set to the time they
+// were recorded. Handlers run as usual, so the order in which streams
+// answer can differ from the recording; frames are compared stream by
+// stream, and header blocks by the fields they decode to.
+type Replayer struct {
+	Handler Handler
+
+	// Given to the Dispatcher. They should be what the recording
+	// server used.
+	LocalSettings settings.SettingsList
+	IdleTimeout   time.Duration
+
+	// How long to wait for handlers once the capture runs out.
+	// Defaults to five seconds.
+	Timeout time.Duration
+}
+
+// Replay replays the capture in r. It returns a line for every
+// difference between the frames sent and those recorded, so an empty
+// result means the replay matched.
+func (rp *Replayer) Replay(r io.Reader) ([]string, error) {
+	cr, err := frame.NewCaptureReader(r)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, nil
+	}
+
+	clock := NewFakeClock(recs[0].Time)
+	var sent bytes.Buffer
+	cw := frame.NewCaptureWriter(&sent)
+	cw.Now = clock.Now
+
+	in := newReplayReader()
+	ctx := NewConnectionContext(in, cw.Outbound(), rp.Handler)
+	sess := NewDispatcher(ctx, frame.NewFramer(in))
+	sess.LocalSettings = rp.LocalSettings
+	sess.IdleTimeout = rp.IdleTimeout
+	sess.Clock = clock
+	go func() {
+		sess.Serve()
+		close(in.stopped)
+	}()
+
+	// The clock only moves once the Dispatcher is done with the
+	// frame before and asks for the next one.
+	stopped := !in.next() || !in.feed(frame.ClientPreface)
+	for _, rec := range recs {
+		if stopped {
+			break
+		}
+		if rec.Dir != frame.Inbound {
+			continue
+		}
+		var buf bytes.Buffer
+		rec.Frame.FrameHeader.Marshal(&buf)
+		buf.Write(rec.Frame.Data)
+		stopped = !in.next()
+		if !stopped {
+			clock.Set(rec.Time)
+			stopped = !in.feed(buf.Bytes())
+		}
+	}
+	if !stopped && in.next() {
+		clock.Set(recs[len(recs)-1].Time)
+		in.finish()
+	}
+
+	timeout := rp.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for !sess.Idle() {
+		if time.Now().After(deadline) {
+			close(in.release)
+			return nil, errors.New("session: handlers still running at the end of the replay")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(in.release)
+	<-in.stopped
+
+	replayed, err := frame.NewCaptureReader(&sent)
+	if err != nil {
+		// Nothing was sent at all.
+		return diffStreams(summarize(recs), nil), nil
+	}
+	got, err := replayed.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return diffStreams(summarize(recs), summarize(got)), nil
+}
+
+// A replayReader hands the Dispatcher one fed chunk at a time, and
+// says when it has used up one and asks for the next. Once the
+// capture runs out it reports that the Dispatcher has read
+// everything, and holds off EOF until released, since EOF ends the
+// connection and cancels the handlers.
+type replayReader struct {
+	wants   chan struct{}
+	chunks  chan []byte
+	buf     []byte
+	eof     bool
+	drained chan struct{}
+	release chan struct{}
+	// Closed when Serve returns.
+	stopped chan struct{}
+}
+
+func newReplayReader() *replayReader {
+	return &replayReader{
+		wants:   make(chan struct{}),
+		chunks:  make(chan []byte),
+		drained: make(chan struct{}),
+		release: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (rr *replayReader) Read(p []byte) (int, error) {
+	if len(rr.buf) == 0 {
+		if rr.eof {
+			return 0, io.EOF
+		}
+		rr.wants <- struct{}{}
+		c, ok := <-rr.chunks
+		if !ok {
+			rr.eof = true
+			close(rr.drained)
+			<-rr.release
+			return 0, io.EOF
+		}
+		rr.buf = c
+	}
+	n := copy(p, rr.buf)
+	rr.buf = rr.buf[n:]
+	return n, nil
+}
+
+// next waits for the Dispatcher to ask for another chunk, which it
+// does once it has handled everything fed before. It reports false if
+// the Dispatcher has stopped reading.
+func (rr *replayReader) next() bool {
+	select {
+	case <-rr.wants:
+		return true
+	case <-rr.stopped:
+		return false
+	}
+}
+
+// feed hands b to the Dispatcher, after next. It reports false if the
+// Dispatcher has stopped reading.
+func (rr *replayReader) feed(b []byte) bool {
+	select {
+	case rr.chunks <- b:
+		return true
+	case <-rr.stopped:
+		return false
+	}
+}
+
+// finish tells the Dispatcher, after next, that there's nothing more
+// to read, and waits for it to see so.
+func (rr *replayReader) finish() {
+	close(rr.chunks)
+	select {
+	case <-rr.drained:
+	case <-rr.stopped:
+	}
+}
+
+// summarize describes the outbound frames of a capture, by stream.
+// Header blocks are decoded in the order they were sent, so the
+// descriptions don't depend on the state of the HPACK table.
+func summarize(recs []*frame.Record) map[frame.Sid][]string {
+	ret := make(map[frame.Sid][]string)
+	tbl := hpack.NewHeaderLookupTable()
+	var block []byte
+	var head *frame.FrameHeader
+	for _, rec := range recs {
+		if rec.Dir != frame.Outbound {
+			continue
+		}
+		fh, data := rec.Frame.FrameHeader, rec.Frame.Data
+		switch fh.Type {
+		case frame.FrameHeaders:
+			head, block = fh, append([]byte{}, data...)
+		case frame.FrameContinuation:
+			block = append(block, data...)
+		case frame.FrameData:
+			desc := fmt.Sprintf("Data flags=%#x len=%d crc=%08x", fh.Flags, len(data), crc32.ChecksumIEEE(data))
+			ret[fh.Sid] = append(ret[fh.Sid], desc)
+			continue
+		default:
+			name := strings.TrimPrefix(fh.Type.String(), "Frame")
+			desc := fmt.Sprintf("%s flags=%#x % x", name, fh.Flags, data)
+			ret[fh.Sid] = append(ret[fh.Sid], desc)
+			continue
+		}
+		if !fh.Flag(2) || head == nil {
+			continue
+		}
+		var fields []string
+		if err := hpack.DecodeBlock(tbl, block, func(k, v string) {
+			fields = append(fields, k+": "+v)
+		}); err != nil {
+			fields = append(fields, "hpack: "+err.Error())
+		}
+		desc := fmt.Sprintf("Headers flags=%#x [%s]", head.Flags, strings.Join(fields, ", "))
+		ret[head.Sid] = append(ret[head.Sid], desc)
+		head, block = nil, nil
+	}
+	return ret
+}
+
+func diffStreams(want, got map[frame.Sid][]string) []string {
+	var sids []frame.Sid
+	for sid := range want {
+		sids = append(sids, sid)
+	}
+	for sid := range got {
+		if _, ok := want[sid]; !ok {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Slice(sids, func(i, j int) bool { return sids[i] < sids[j] })
+
+	var diffs []string
+	for _, sid := range sids {
+		w, g := want[sid], got[sid]
+		for i := range max(len(w), len(g)) {
+			switch {
+			case i >= len(g):
+				diffs = append(diffs, fmt.Sprintf("stream %d: missing %s", sid, w[i]))
+			case i >= len(w):
+				diffs = append(diffs, fmt.Sprintf("stream %d: unexpected %s", sid, g[i]))
+			case w[i] != g[i]:
+				diffs = append(diffs, fmt.Sprintf("stream %d, frame %d: recorded %s, replayed %s", sid, i, w[i], g[i]))
+			}
+		}
+	}
+	return diffs
+}
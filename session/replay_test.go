@@ -0,0 +1,131 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"http2/frame"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureFile is a capture kept in memory, which says when the
+// connection it records has closed.
+type captureFile struct {
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (f *captureFile) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func echoHandler(req *Request, res *Response) {
+	res.SetHeader("x-path", req.URL().Path)
+	if req.Body != nil {
+		io.Copy(res, req.Body)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	capture := &captureFile{closed: make(chan struct{})}
+	srv := &Server{
+		Handler: FuncHandler(echoHandler),
+		Capture: func(net.Conn) *frame.CaptureWriter {
+			return frame.NewCaptureWriter(capture)
+		},
+	}
+	addr, client, _ := startServer(t, srv)
+
+	resp, err := client.Get("https://" + addr + "/a")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	resp, err = client.Post("https://"+addr+"/b", "text/plain", strings.NewReader("echo me"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "echo me", string(body))
+	client.CloseIdleConnections()
+	select {
+	case <-capture.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't closed")
+	}
+	recorded := capture.Bytes()
+
+	rp := &Replayer{Handler: FuncHandler(echoHandler)}
+	diffs, err := rp.Replay(bytes.NewReader(recorded))
+	require.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	// A handler that answers differently shows up stream by stream.
+	rp.Handler = FuncHandler(func(req *Request, res *Response) {
+		if req.URL().Path == "/b" {
+			io.WriteString(res, "changed")
+			return
+		}
+		echoHandler(req, res)
+	})
+	diffs, err = rp.Replay(bytes.NewReader(recorded))
+	require.NoError(t, err)
+	require.NotEmpty(t, diffs)
+	for _, d := range diffs {
+		assert.Contains(t, d, "stream 3")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := NewFakeClock(start)
+	var fired []string
+	c.AfterFunc(2*time.Second, func() { fired = append(fired, "b") })
+	a := c.AfterFunc(time.Second, func() { fired = append(fired, "a") })
+	stopped := c.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	assert.True(t, stopped.Stop())
+
+	c.Advance(1500 * time.Millisecond)
+	assert.Equal(t, []string{"a"}, fired)
+	assert.False(t, a.Reset(time.Second))
+
+	c.Set(start.Add(3 * time.Second))
+	assert.Equal(t, []string{"a", "b", "a"}, fired)
+	assert.Equal(t, start.Add(3*time.Second), c.Now())
+
+	// Time doesn't go backwards.
+	c.Set(start)
+	assert.Equal(t, start.Add(3*time.Second), c.Now())
+}
+
+func TestReplayReaderWaitsForNextRead(t *testing.T) {
+	rr := newReplayReader()
+	var read atomic.Int32
+	go func() {
+		defer close(rr.stopped)
+		p := make([]byte, 1)
+		for {
+			if _, err := rr.Read(p); err != nil {
+				return
+			}
+			// Slow, so next would get ahead of a reader that hasn't
+			// used up its chunk.
+			time.Sleep(time.Millisecond)
+			read.Add(1)
+		}
+	}()
+
+	require.True(t, rr.next())
+	require.True(t, rr.feed([]byte("hello")))
+	require.True(t, rr.next())
+	assert.EqualValues(t, 5, read.Load())
+	rr.finish()
+	close(rr.release)
+	<-rr.stopped
+}
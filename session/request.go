@@ -0,0 +1,201 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"http2/pkg/bodystream"
+	"net/url"
+	"strings"
+)
+
+type Request struct {
+	// The decoded header block, including pseudo-headers,
+	// in the order it was received.
+	Headers []stringpair
+	Body    *bodystream.BodyStream
+
+	method     string
+	scheme     string
+	authority  string
+	url        *url.URL
+	query      url.Values
+	requestURI string
+	header     Header
+	trailer    Header
+
+	contentLength int64
+
+	remoteAddr string
+	tls        *tls.ConnectionState
+
+	ctx context.Context
+
+	// Set by routers that parse the path.
+	pathValues map[string]string
+}
+
+// newRequest builds a Request from a stream's complete header block.
+func newRequest(st *Stream, ctx *ConnectionContext) (*Request, error) {
+	req := &Request{
+		Headers:    st.InHeaders.Headers,
+		Body:       st.Body,
+		header:     make(Header),
+		trailer:    make(Header),
+		remoteAddr: ctx.RemoteAddr,
+		tls:        ctx.TLS,
+		ctx:        st.ctx,
+	}
+
+	var path string
+	for _, pair := range req.Headers {
+		switch pair.k {
+		case ":method":
+			req.method = pair.v
+		case ":scheme":
+			req.scheme = pair.v
+		case ":authority":
+			req.authority = pair.v
+		case ":path":
+			path = pair.v
+		default:
+			if !strings.HasPrefix(pair.k, ":") {
+				req.header.Add(pair.k, pair.v)
+			}
+		}
+	}
+
+	// HTTP/2 allows cookies to be split across fields; join them
+	// back into one (RFC 9113 §8.2.3).
+	if cookies := req.header.Values("cookie"); len(cookies) > 1 {
+		req.header.Set("cookie", strings.Join(cookies, "; "))
+	}
+	if req.authority == "" {
+		req.authority = req.header.Get("host")
+	}
+	cl, err := parseContentLength(req.header.Values("content-length"))
+	if err != nil {
+		return nil, err
+	}
+	req.contentLength = cl
+
+	// Like net/http, CONNECT requests carry their target in
+	// :authority, and everything else in :path.
+	if req.method == "CONNECT" {
+		req.url = &url.URL{Host: req.authority}
+		req.requestURI = req.authority
+	} else {
+		u, err := url.ParseRequestURI(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid :path %q: %w", path, err)
+		}
+		req.url = u
+		req.requestURI = path
+	}
+	return req, nil
+}
+
+// GetHeader returns the first value of a header or pseudo-header.
+func (req *Request) GetHeader(k string) string {
+	for _, pair := range req.Headers {
+		if pair.k == k {
+			return pair.v
+		}
+	}
+	return ""
+}
+
+func (req *Request) Method() string {
+	return req.method
+}
+
+func (req *Request) Scheme() string {
+	return req.scheme
+}
+
+// Authority returns the :authority pseudo-header, or the Host
+// header if the client didn't send one.
+func (req *Request) Authority() string {
+	return req.authority
+}
+
+// URL returns the parsed request target. As with net/http servers,
+// only the path and query are set, except for CONNECT requests where
+// only the host is.
+func (req *Request) URL() *url.URL {
+	return req.url
+}
+
+// Query returns the parsed query string of the URL.
+func (req *Request) Query() url.Values {
+	if req.query == nil {
+		req.query = req.url.Query()
+	}
+	return req.query
+}
+
+// RequestURI is the unmodified request target: :path, or :authority
+// for CONNECT requests.
+func (req *Request) RequestURI() string {
+	return req.requestURI
+}
+
+// Header returns the regular (non-pseudo) header fields.
+func (req *Request) Header() Header {
+	return req.header
+}
+
+// Trailer returns the trailer fields the client sent after the body.
+// It's only safe to call once Body has returned io.EOF, and is empty
+// if there were no trailers.
+func (req *Request) Trailer() Header {
+	return req.trailer
+}
+
+// PathValue returns a value a router matched in the path,
+// or "" if there is none.
+func (req *Request) PathValue(name string) string {
+	return req.pathValues[name]
+}
+
+func (req *Request) SetPathValue(name, value string) {
+	if req.pathValues == nil {
+		req.pathValues = make(map[string]string)
+	}
+	req.pathValues[name] = value
+}
+
+// ContentLength is the value of the content-length header,
+// or -1 if it wasn't sent.
+func (req *Request) ContentLength() int64 {
+	return req.contentLength
+}
+
+// RemoteAddr is the network address of the client, if known.
+func (req *Request) RemoteAddr() string {
+	return req.remoteAddr
+}
+
+// TLS returns the connection's TLS state, or nil for
+// unencrypted connections.
+func (req *Request) TLS() *tls.ConnectionState {
+	return req.tls
+}
+
+// Context returns the request's context. It's cancelled when the
+// client resets the stream, the connection closes, or the handler
+// returns.
+func (req *Request) Context() context.Context {
+	if req.ctx == nil {
+		return context.Background()
+	}
+	return req.ctx
+}
+
+// WithContext returns a shallow copy of the request with its
+// context changed to ctx.
+func (req *Request) WithContext(ctx context.Context) *Request {
+	r2 := *req
+	r2.ctx = ctx
+	return &r2
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func streamWithHeaders(pairs ...string) *Stream {
+	st := NewStream(1, nil)
+	for i := 0; i < len(pairs); i += 2 {
+		st.InHeaders.Add(pairs[i], pairs[i+1])
+	}
+	return st
+}
+
+func TestNewRequest(t *testing.T) {
+	st := streamWithHeaders(
+		":method", "GET",
+		":scheme", "https",
+		":authority", "example.com:8000",
+		":path", "/users/42?fields=a&fields=b",
+		"accept", "text/html",
+		"x-multi", "one",
+		"x-multi", "two",
+		"cookie", "a=1",
+		"cookie", "b=2",
+	)
+	ctx := &ConnectionContext{RemoteAddr: "10.0.0.1:5555"}
+
+	req, err := newRequest(st, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "GET", req.Method())
+	assert.Equal(t, "https", req.Scheme())
+	assert.Equal(t, "example.com:8000", req.Authority())
+	assert.Equal(t, "/users/42", req.URL().Path)
+	assert.Equal(t, []string{"a", "b"}, req.Query()["fields"])
+	assert.Equal(t, "/users/42?fields=a&fields=b", req.RequestURI())
+	assert.Equal(t, "10.0.0.1:5555", req.RemoteAddr())
+	assert.Nil(t, req.TLS())
+
+	assert.Equal(t, "text/html", req.Header().Get("Accept"))
+	assert.Equal(t, []string{"one", "two"}, req.Header().Values("X-Multi"))
+	assert.Equal(t, "a=1; b=2", req.Header().Get("cookie"))
+	assert.Empty(t, req.Header().Values(":path"))
+}
+
+func TestNewRequestConnect(t *testing.T) {
+	st := streamWithHeaders(":method", "CONNECT", ":authority", "example.com:443")
+
+	req, err := newRequest(st, &ConnectionContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com:443", req.URL().Host)
+	assert.Equal(t, "example.com:443", req.RequestURI())
+}
+
+func TestNewRequestHostFallback(t *testing.T) {
+	st := streamWithHeaders(":method", "GET", ":scheme", "http", ":path", "*", "host", "example.com")
+
+	req, err := newRequest(st, &ConnectionContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", req.Authority())
+	assert.Equal(t, "*", req.URL().Path)
+}
+
+func TestNewRequestBadPath(t *testing.T) {
+	st := streamWithHeaders(":method", "GET", ":scheme", "http", ":path", "no-slash")
+
+	_, err := newRequest(st, &ConnectionContext{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+package session
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"http2/frame"
+	"http2/pkg/pcapng"
+	"http2/session/settings"
+)
+
+// ServerClosed is returned by Serve and the ListenAndServe methods
+// after Close or Shutdown.
+var ServerClosed = errors.New("session: server closed")
+
+// A Server accepts HTTP/2 connections and serves them with a Handler.
+// The zero value is usable once Handler is set.
+type Server struct {
+	// TCP address to listen on, ":http" or ":https" if empty.
+	Addr    string
+	Handler Handler
+
+	// Used by ListenAndServeTLS. "h2" is added to NextProtos,
+	// since clients pick HTTP/2 with ALPN.
+	TLSConfig *tls.Config
+
+	// Advertised to every client in the server's initial
+	// SETTINGS frame, and enforced. Serve refuses settings the
+	// server can't honour; see UnsupportedSetting.
+	Settings settings.SettingsList
+
+	// How long a client has to finish the TLS handshake and send
+	// its connection preface, and how long a connection may sit
+	// with nothing to do before it's closed. Zero means no limit.
+	HandshakeTimeout time.Duration
+	IdleTimeout      time.Duration
+
+	// Where to log errors accepting and serving connections.
+	// Defaults to the log package's standard logger.
+	ErrorLog *log.Logger
+
+	// Traces every connection, if set. See NewSlogTracer and
+	// NewConsoleTracer.
+	Tracer Tracer
+
+	// If set, is called for each connection once it's ready for
+	// HTTP/2, and every frame read or written on the connection is
+	// recorded to the CaptureWriter it returns, which is closed
+	// with the connection. Returning nil records nothing.
+	Capture func(conn net.Conn) *frame.CaptureWriter
+
+	// If set, the plaintext of every connection is written to it as
+	// a TCP stream to port 443, or 80 for h2c, for Wireshark. Set
+	// TLSConfig.KeyLogWriter to decrypt a real capture instead.
+	Pcap *pcapng.Writer
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[*serverConn]struct{}
+	inShutdown bool
+
+	// Connections given made-up addresses in the pcapng file.
+	flows atomic.Uint32
+}
+
+type serverConn struct {
+	conn net.Conn
+	sess *Dispatcher
+}
+
+func (srv *Server) logf(format string, args ...any) {
+	if srv.ErrorLog != nil {
+		srv.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// ListenAndServe serves cleartext HTTP/2 with prior knowledge (h2c)
+// on srv.Addr.
+func (srv *Server) ListenAndServe() error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// ListenAndServeTLS serves HTTP/2 over TLS on srv.Addr. The
+// certificate files can be empty if TLSConfig already has one.
+func (srv *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	cfg := srv.tlsConfig()
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(tls.NewListener(l, cfg))
+}
+
+func (srv *Server) tlsConfig() *tls.Config {
+	var cfg *tls.Config
+	if srv.TLSConfig != nil {
+		cfg = srv.TLSConfig.Clone()
+	} else {
+		cfg = new(tls.Config)
+	}
+	if !slices.Contains(cfg.NextProtos, "h2") {
+		cfg.NextProtos = append(cfg.NextProtos, "h2")
+	}
+	return cfg
+}
+
+// Serve accepts connections on l until it fails or the server is
+// closed, and always closes l. It fails at once if srv.Settings has
+// one the server can't honour. TLS connections have to negotiate h2
+// with ALPN; anything else is expected to speak h2c.
+func (srv *Server) Serve(l net.Listener) error {
+	if err := checkLocalSettings(srv.Settings); err != nil {
+		l.Close()
+		return err
+	}
+	if !srv.trackListener(l, true) {
+		l.Close()
+		return ServerClosed
+	}
+	defer srv.trackListener(l, false)
+	defer l.Close()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if srv.shuttingDown() {
+				return ServerClosed
+			}
+			// Back off on errors like running out of file
+			// descriptors, as net/http does.
+			if retryAccept(err) {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				srv.logf("session: accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go srv.serveConn(conn)
+	}
+}
+
+// retryAccept reports whether Accept failed for a reason that may
+// pass, such as the process or system running out of file
+// descriptors.
+func retryAccept(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+func (srv *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		ctx := context.Background()
+		if srv.HandshakeTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, srv.HandshakeTimeout)
+			defer cancel()
+		}
+		if err := tc.HandshakeContext(ctx); err != nil {
+			srv.logf("session: TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if p := tc.ConnectionState().NegotiatedProtocol; p != "h2" {
+			srv.logf("session: client %s negotiated %q instead of h2", conn.RemoteAddr(), p)
+			return
+		}
+	}
+
+	var in io.Reader = conn
+	var out io.Writer = conn
+	if srv.Capture != nil {
+		if cw := srv.Capture(conn); cw != nil {
+			defer cw.Close()
+			in = io.TeeReader(conn, cw.Inbound())
+			out = io.MultiWriter(conn, cw.Outbound())
+		}
+	}
+	if srv.Pcap != nil {
+		flow := srv.Pcap.NewFlow(srv.flowAddrs(conn))
+		defer flow.Close()
+		in = io.TeeReader(in, flow.Client())
+		out = io.MultiWriter(out, flow.Server())
+	}
+
+	// The context keeps conn as its reader so it can find the
+	// client's address and TLS state.
+	ctx := NewConnectionContext(conn, out, srv.Handler)
+	ctx.Tracer = srv.Tracer
+	sess := NewDispatcher(ctx, frame.NewFramer(in))
+	sess.LocalSettings = srv.Settings
+	sess.HandshakeTimeout = srv.HandshakeTimeout
+	sess.IdleTimeout = srv.IdleTimeout
+
+	sc := &serverConn{conn: conn, sess: sess}
+	if !srv.trackConn(sc, true) {
+		return
+	}
+	defer srv.trackConn(sc, false)
+
+	if err := sess.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+		srv.logf("session: error serving %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// flowAddrs picks the addresses conn's plaintext is written to a pcapng
+// file with. They're conn's own if it's over IPv4, but the server's
+// port says how Wireshark should decode the stream. Other connections
+// each get a client port of their own from the ephemeral range, so
+// Wireshark tells their streams apart.
+func (srv *Server) flowAddrs(conn net.Conn) (client, server netip.AddrPort) {
+	ipv4 := func(addr net.Addr, def netip.AddrPort) netip.AddrPort {
+		if ta, ok := addr.(*net.TCPAddr); ok {
+			ap := ta.AddrPort()
+			if ip := ap.Addr().Unmap(); ip.Is4() {
+				return netip.AddrPortFrom(ip, ap.Port())
+			}
+		}
+		return def
+	}
+	port := 49152 + uint16(srv.flows.Add(1)%16384)
+	client = ipv4(conn.RemoteAddr(), netip.AddrPortFrom(netip.MustParseAddr("10.0.0.2"), port))
+	server = ipv4(conn.LocalAddr(), netip.MustParseAddrPort("10.0.0.1:0"))
+	port = 80
+	if _, ok := conn.(*tls.Conn); ok {
+		port = 443
+	}
+	return client, netip.AddrPortFrom(server.Addr(), port)
+}
+
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if add {
+		if srv.inShutdown {
+			return false
+		}
+		if srv.listeners == nil {
+			srv.listeners = make(map[net.Listener]struct{})
+		}
+		srv.listeners[l] = struct{}{}
+	} else {
+		delete(srv.listeners, l)
+	}
+	return true
+}
+
+func (srv *Server) trackConn(sc *serverConn, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if add {
+		if srv.inShutdown {
+			return false
+		}
+		if srv.conns == nil {
+			srv.conns = make(map[*serverConn]struct{})
+		}
+		srv.conns[sc] = struct{}{}
+	} else {
+		delete(srv.conns, sc)
+	}
+	return true
+}
+
+func (srv *Server) shuttingDown() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.inShutdown
+}
+
+// closeListeners stops accepting connections. Callers must hold mu.
+func (srv *Server) closeListeners() error {
+	var err error
+	for l := range srv.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Close stops the server at once, closing its listeners and every
+// connection. Handlers see their request contexts cancelled.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.inShutdown = true
+	err := srv.closeListeners()
+	for sc := range srv.conns {
+		sc.conn.Close()
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully. It closes the listeners,
+// sends GOAWAY on every connection so clients stop opening streams,
+// and waits for running handlers to finish before closing each
+// connection. If ctx ends first, Shutdown returns its error and the
+// remaining connections are left for Close.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.inShutdown = true
+	err := srv.closeListeners()
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for sc := range srv.conns {
+		conns = append(conns, sc)
+	}
+	srv.mu.Unlock()
+
+	// A client that has stopped reading blocks writes to its
+	// connection until Close, so none of them may hold up the rest,
+	// or the wait below. Each is marked first, so it isn't taken
+	// for idle before its GOAWAY is out.
+	for _, sc := range conns {
+		if sc.sess.beginShutdown() {
+			go sc.sess.sendShutdown()
+		}
+	}
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if srv.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections with no running handlers, and
+// reports whether there are none left.
+func (srv *Server) closeIdleConns() bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for sc := range srv.conns {
+		if sc.sess.Idle() {
+			sc.conn.Close()
+			delete(srv.conns, sc)
+		}
+	}
+	return len(srv.conns) == 0
+}
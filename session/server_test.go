@@ -0,0 +1,343 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"http2/frame"
+	"http2/internal/selfsigned"
+	"http2/pkg/pcapng"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert makes a self-signed certificate for 127.0.0.1.
+func testCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	cert, err := selfsigned.New("127.0.0.1")
+	require.NoError(t, err)
+	return cert, selfsigned.Pool(cert)
+}
+
+// startServer serves srv over TLS on a local port, returning the
+// address and an HTTP/2 client that trusts it.
+func startServer(t *testing.T, srv *Server) (string, *http.Client, <-chan error) {
+	cert, pool := testCert(t)
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(tls.NewListener(l, srv.tlsConfig())) }()
+	t.Cleanup(func() { srv.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+	return l.Addr().String(), client, done
+}
+
+func TestServerServesHTTP2(t *testing.T) {
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {
+		res.SetHeader("content-type", "text/plain")
+		io.WriteString(res, "hello "+req.URL().Path)
+	})}
+	addr, client, _ := startServer(t, srv)
+
+	resp, err := client.Get("https://" + addr + "/world")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "hello /world", string(body))
+}
+
+func TestServerShutdownWaitsForHandlers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {
+		close(started)
+		<-release
+		io.WriteString(res, "done")
+	})}
+	addr, client, served := startServer(t, srv)
+
+	type result struct {
+		body string
+		err  error
+	}
+	got := make(chan result, 1)
+	go func() {
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			got <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		got <- result{string(body), err}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	assert.ErrorIs(t, <-served, ServerClosed)
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a handler still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	r := <-got
+	require.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {
+		close(started)
+		<-req.Context().Done()
+	})}
+	addr, client, _ := startServer(t, srv)
+	go func() {
+		if resp, err := client.Get("https://" + addr + "/"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+}
+
+func TestServerShutdownStuckClient(t *testing.T) {
+	started := make(chan struct{})
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {
+		close(started)
+		chunk := make([]byte, 1<<14)
+		for {
+			if _, err := res.Write(chunk); err != nil {
+				return
+			}
+		}
+	})}
+	addr, _, _ := startServer(t, srv)
+
+	// A client that asks for the response and then stops reading.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(frame.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, (&frame.FrameHeader{Type: frame.FrameSettings}).Marshal(conn))
+	// GET https://test/, with static table entries.
+	block := []byte{0x82, 0x87, 0x84, 0x41, 4, 't', 'e', 's', 't'}
+	require.NoError(t, (&frame.FrameHeader{
+		Length: uint32(len(block)),
+		Type:   frame.FrameHeaders,
+		Flags:  FLAG_END_HEADERS | FLAG_END_STREAM,
+		Sid:    1,
+	}).Marshal(conn))
+	_, err = conn.Write(block)
+	require.NoError(t, err)
+	<-started
+	// Long enough for the handler to fill the socket's buffers.
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, srv.Shutdown(ctx), context.DeadlineExceeded)
+		assert.NoError(t, srv.Close())
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown and Close blocked on a client that isn't reading")
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	srv := &Server{
+		Handler:     FuncHandler(func(req *Request, res *Response) {}),
+		IdleTimeout: 20 * time.Millisecond,
+	}
+	addr, _, _ := startServer(t, srv)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(frame.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, (&frame.FrameHeader{Type: frame.FrameSettings}).Marshal(conn))
+
+	// The server's SETTINGS and ACK, then GOAWAY once idle.
+	var types []frame.FrameType
+	fr := frame.NewFramer(conn)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+		types = append(types, f.FrameHeader.Type)
+	}
+	require.NotEmpty(t, types)
+	assert.Equal(t, frame.FrameGoaway, types[len(types)-1])
+}
+
+func TestServerRequiresALPN(t *testing.T) {
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {})}
+	addr, _, _ := startServer(t, srv)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	})
+	if err != nil {
+		// No protocol in common.
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerRejectsUnsupportedSettings(t *testing.T) {
+	srv := &Server{Handler: FuncHandler(func(req *Request, res *Response) {})}
+	srv.Settings.Put(settings.MaxHeaderListSize, 1<<16)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.ErrorIs(t, srv.Serve(l), UnsupportedSetting)
+
+	// The listener is closed all the same.
+	_, err = l.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+// emfileListener fails its first Accept as if the process had run
+// out of file descriptors.
+type emfileListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *emfileListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerRetriesAcceptErrors(t *testing.T) {
+	srv := &Server{
+		Handler:  FuncHandler(func(req *Request, res *Response) {}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(&emfileListener{Listener: l}) }()
+
+	// The server is still accepting after the error.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(frame.ClientPreface)
+	require.NoError(t, err)
+	require.NoError(t, (&frame.FrameHeader{Type: frame.FrameSettings}).Marshal(conn))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, err := frame.NewFramer(conn).ReadFrame()
+	require.NoError(t, err)
+	assert.Equal(t, frame.FrameSettings, f.FrameHeader.Type)
+
+	srv.Close()
+	assert.ErrorIs(t, <-served, ServerClosed)
+}
+
+// syncBuffer is a bytes.Buffer that's safe to read while a server
+// writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
+
+func TestServerPcap(t *testing.T) {
+	var pcap syncBuffer
+	srv := &Server{
+		Handler: FuncHandler(func(req *Request, res *Response) {
+			io.WriteString(res, "in the clear")
+		}),
+		Pcap: pcapng.NewWriter(&pcap),
+	}
+	addr, client, _ := startServer(t, srv)
+
+	resp, err := client.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The plaintext of both sides ends up in the file.
+	assert.Eventually(t, func() bool {
+		data := pcap.Bytes()
+		return bytes.Contains(data, frame.ClientPreface) && bytes.Contains(data, []byte("in the clear"))
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFlowAddrsDistinct(t *testing.T) {
+	var srv Server
+	seen := make(map[netip.AddrPort]bool)
+	for range 3 {
+		a, b := net.Pipe()
+		defer a.Close()
+		defer b.Close()
+		client, server := srv.flowAddrs(a)
+		assert.Equal(t, netip.MustParseAddrPort("10.0.0.1:80"), server)
+		assert.False(t, seen[client], "%v used twice", client)
+		seen[client] = true
+	}
+}
@@ -101,6 +101,7 @@ func (sl *SettingsList) ToPayload() []byte {
 	for _, setting := range sl.Settings {
 		binary.BigEndian.PutUint16(ret[i:], uint16(setting.Type))
 		binary.BigEndian.PutUint32(ret[i+2:], setting.Value)
+		i += 6
 	}
 	return ret
 }
@@ -1,9 +1,14 @@
 package session
 
 import (
-	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
 	"http2/frame"
 	"http2/pkg/bodystream"
+	"http2/session/settings"
+	"sync"
+	"sync/atomic"
 )
 
 type Headers struct {
@@ -27,19 +32,49 @@ type Stream struct {
 
 	Context *ConnectionContext
 
-	State StreamState
+	// Handlers reset streams from their own goroutines while the
+	// Dispatcher reads, so the state is only touched under stateMu.
+	stateMu sync.Mutex
+	state   StreamState
+
+	// Counts the connection's open streams, if set.
+	open *atomic.Int32
 
 	InHeaders *Headers
 	Body      *bodystream.BodyStream
+
+	// Built by the Dispatcher once the header block is complete.
+	Request *Request
+
+	// Octets of DATA payload received so far.
+	dataReceived int64
+
+	// Whether the client ended the stream with END_STREAM or
+	// RST_STREAM, after which it may not send DATA or HEADERS on it.
+	// Only the Dispatcher touches it.
+	peerClosed bool
+
+	// Cancelled when the stream is reset, the handler returns,
+	// or the connection closes.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewStream(sid frame.Sid, ctx *ConnectionContext) *Stream {
 	var s Stream
 	s.Sid = sid
 	s.Context = ctx
-	s.State = StreamStateIdle
+	s.state = StreamStateIdle
 	s.InHeaders = new(Headers)
 	s.Body = bodystream.NewBodyStream()
+	if ctx != nil {
+		s.Body.SetOnConsume(s.consumed)
+	}
+	var parent context.Context = context.Background()
+	if ctx != nil {
+		parent = ctx
+	}
+	s.ctx, s.cancel = context.WithCancel(parent)
 	return &s
 }
 
@@ -55,23 +90,128 @@ func (stream *Stream) SendFrame(typ frame.FrameType, flags uint8, data []uint8)
 	return stream.Context.SendFrame(fh, data)
 }
 
-func (stream *Stream) Serve(ctx *ConnectionContext) {
-	req := &Request{
-		Body:    stream.Body,
-		Headers: stream.InHeaders.Headers,
+// writeHeaders sends a complete header block in one HEADERS frame.
+func (stream *Stream) writeHeaders(fields []stringpair, endStream bool) error {
+	if err := stream.ctx.Err(); err != nil {
+		return fmt.Errorf("stream %d closed: %w", stream.Sid, err)
+	}
+	flags := FLAG_END_HEADERS
+	if endStream {
+		flags |= FLAG_END_STREAM
+		// Before the frame is out, so the stream no longer
+		// counts once the client can open another.
+		stream.transition(StreamState.SentEndStream)
+	}
+	return stream.Context.SendHeaderBlock(stream.Sid, flags, fields)
+}
+
+func (stream *Stream) writeData(data []byte, endStream bool) error {
+	if err := stream.ctx.Err(); err != nil {
+		return fmt.Errorf("stream %d closed: %w", stream.Sid, err)
+	}
+	var flags uint8
+	if endStream {
+		flags = FLAG_END_STREAM
+		stream.transition(StreamState.SentEndStream)
+	}
+	return stream.SendFrame(frame.FrameData, flags, data)
+}
+
+// maxFrameSize is the largest frame payload the client accepts.
+func (stream *Stream) maxFrameSize() int {
+	maxFrameSize, ok := stream.Context.Settings.Get(settings.MaxFrameSize)
+	if !ok {
+		maxFrameSize, _ = settings.Default(settings.MaxFrameSize)
+	}
+	return int(maxFrameSize)
+}
+
+// checkContentLength counts n more octets of DATA and checks them
+// against the request's content-length (RFC 9113 §8.1.1).
+func (stream *Stream) checkContentLength(n int, endStream bool) error {
+	stream.dataReceived += int64(n)
+	if stream.Request == nil {
+		return nil
 	}
-	resp := &Response{
-		body:   bytes.NewBuffer(nil),
-		stream: stream,
+	cl := stream.Request.ContentLength()
+	if cl < 0 {
+		return nil
 	}
-	ctx.Handler.Handle(req, resp)
-	l := resp.body.Len()
-	if !resp.headersSent {
-		resp.sendHeaders(l <= 0)
+	if stream.dataReceived > cl || (endStream && stream.dataReceived != cl) {
+		return malformed("content-length %d, but received %d octets of data", cl, stream.dataReceived)
+	}
+	return nil
+}
+
+// Reset abruptly terminates the stream with RST_STREAM.
+func (stream *Stream) Reset(code ErrorCode) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(code))
+	stream.transition(StreamState.SentRstStream)
+	stream.Body.Discard()
+	err := stream.SendFrame(frame.FrameResetStream, 0, data)
+	stream.cancel()
+	return err
+}
+
+// receivedReset handles RST_STREAM from the client.
+func (stream *Stream) receivedReset() {
+	stream.peerClosed = true
+	stream.setState(StreamStateClosed)
+	stream.Body.Discard()
+	stream.cancel()
+}
+
+// consumed gives the client back the flow-control window for n octets
+// of request body that the handler read or the server dropped. The
+// stream's own window only matters while the client can still send.
+func (stream *Stream) consumed(n int) {
+	stream.Context.windowUpdate(0, uint32(n))
+	if s := stream.State(); s != StreamStateRemoteClosed && s != StreamStateClosed {
+		stream.Context.windowUpdate(stream.Sid, uint32(n))
+	}
+}
+
+func (stream *Stream) Serve(ctx *ConnectionContext) {
+	defer stream.cancel()
+	resp := newResponse(stream.Request, stream)
+	ctx.Handler.Handle(stream.Request, resp)
+	// Nobody reads the rest of the body now.
+	stream.Body.Discard()
+	if err := resp.finish(); err != nil {
+		ctx.trace().Error(ctx, stream.Sid, err)
+	}
+}
+
+// State returns the stream's state. It's safe to call from any
+// goroutine.
+func (stream *Stream) State() StreamState {
+	stream.stateMu.Lock()
+	defer stream.stateMu.Unlock()
+	return stream.state
+}
+
+// setState moves the stream to state s, and traces the change.
+func (stream *Stream) setState(s StreamState) {
+	stream.transition(func(StreamState) StreamState { return s })
+}
+
+// transition moves the stream to the state next gives for its current
+// one, and traces the change.
+func (stream *Stream) transition(next func(StreamState) StreamState) {
+	stream.stateMu.Lock()
+	from := stream.state
+	to := next(from)
+	stream.state = to
+	stream.stateMu.Unlock()
+	if stream.open != nil && from.isOpen() != to.isOpen() {
+		if to.isOpen() {
+			stream.open.Add(1)
+		} else {
+			stream.open.Add(-1)
+		}
 	}
-	if l > 0 {
-		resp.Flush()
-	} else {
-		resp.stream.SendFrame(frame.FrameData, FLAG_END_STREAM, nil)
+	if from != to {
+		stream.Context.trace().StreamStateChanged(stream.Context, stream.Sid, from, to)
 	}
 }
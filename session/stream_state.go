@@ -15,6 +15,17 @@ const (
 	StreamStateClosed
 )
 
+// isOpen reports whether the stream counts towards
+// SETTINGS_MAX_CONCURRENT_STREAMS (RFC 9113 §5.1.2).
+func (ss StreamState) isOpen() bool {
+	return ss != StreamStateUnset && ss != StreamStateIdle && ss != StreamStateClosed
+}
+
+// The transitions below return the state unchanged when the event
+// isn't allowed in it. The peer can send any frame in any state, so
+// it's up to the caller to check the state first and decide what
+// error the frame is.
+
 func (ss StreamState) ReceivedHeader() StreamState {
 	switch ss {
 	case StreamStateIdle:
@@ -22,7 +33,7 @@ func (ss StreamState) ReceivedHeader() StreamState {
 	case StreamStateRemoteReserved:
 		return StreamStateLocalClosed
 	}
-	panic("wrong state")
+	return ss
 }
 
 func (ss StreamState) SentHeader() StreamState {
@@ -32,7 +43,7 @@ func (ss StreamState) SentHeader() StreamState {
 	case StreamStateLocalReserved:
 		return StreamStateRemoteClosed
 	}
-	panic("wrong state")
+	return ss
 }
 
 func (ss StreamState) ReceivedEndStream() StreamState {
@@ -42,7 +53,7 @@ func (ss StreamState) ReceivedEndStream() StreamState {
 	case StreamStateLocalClosed:
 		return StreamStateClosed
 	}
-	panic("bad")
+	return ss
 }
 
 func (ss StreamState) SentEndStream() StreamState {
@@ -52,7 +63,7 @@ func (ss StreamState) SentEndStream() StreamState {
 	case StreamStateRemoteClosed:
 		return StreamStateClosed
 	}
-	panic("bad")
+	return ss
 }
 
 func (ss StreamState) SentRstStream() StreamState {
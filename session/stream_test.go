@@ -0,0 +1,47 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"http2/frame"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientResetCancelsRequest(t *testing.T) {
+	var out bytes.Buffer
+	ctx := NewConnectionContext(nil, &out, nil)
+	sess := NewDispatcher(ctx, nil)
+	st := sess.Stream(1)
+	st.setState(StreamStateOpen)
+	reqCtx := st.ctx
+
+	err := sess.Dispatch(&frame.Frame{
+		FrameHeader: &frame.FrameHeader{Type: frame.FrameResetStream, Sid: 1, Length: 4},
+		Data:        []byte{0, 0, 0, 8},
+	})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, reqCtx.Err(), context.Canceled)
+	assert.Equal(t, StreamStateClosed, st.State())
+	assert.Error(t, st.writeData([]byte("late"), true))
+	assert.Zero(t, out.Len())
+
+	// Other streams are unaffected until the connection closes.
+	other := sess.Stream(3)
+	assert.NoError(t, other.ctx.Err())
+	ctx.cancel()
+	assert.ErrorIs(t, other.ctx.Err(), context.Canceled)
+}
+
+func TestResetOnStreamZero(t *testing.T) {
+	sess := NewDispatcher(NewConnectionContext(nil, nil, nil), nil)
+	err := sess.Dispatch(&frame.Frame{
+		FrameHeader: &frame.FrameHeader{Type: frame.FrameResetStream, Length: 4},
+		Data:        []byte{0, 0, 0, 8},
+	})
+	var ce *ConnError
+	assert.ErrorAs(t, err, &ce)
+	assert.Equal(t, ErrorCodeProtocol, ce.ErrorCode)
+}
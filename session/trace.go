@@ -0,0 +1,181 @@
+package session
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"http2/frame"
+	"http2/session/settings"
+)
+
+// A Tracer is told what happens on a connection. Its methods are called
+// from the connection's read loop and from handler goroutines, so they
+// must be safe for concurrent use, and shouldn't block: the connection
+// waits for them.
+//
+// Embed NopTracer to implement only some of the methods.
+type Tracer interface {
+	// A frame was read from the client, or written to it.
+	FrameRead(conn *ConnectionContext, f *frame.Frame)
+	FrameWritten(conn *ConnectionContext, fh *frame.FrameHeader, data []byte)
+
+	// A header block from the client was decoded, either a
+	// request's headers or its trailers.
+	HeadersDecoded(conn *ConnectionContext, sid frame.Sid, fields []HeaderField)
+
+	// The client sent SETTINGS.
+	SettingsChanged(conn *ConnectionContext, sl *settings.SettingsList)
+
+	// A stream moved from one state to another.
+	StreamStateChanged(conn *ConnectionContext, sid frame.Sid, from, to StreamState)
+
+	// Something went wrong on a stream, or on the connection if
+	// sid is 0.
+	Error(conn *ConnectionContext, sid frame.Sid, err error)
+}
+
+// A HeaderField is one field of a decoded header block.
+type HeaderField struct {
+	Name, Value string
+}
+
+// NopTracer ignores everything.
+type NopTracer struct{}
+
+func (NopTracer) FrameRead(*ConnectionContext, *frame.Frame)                                 {}
+func (NopTracer) FrameWritten(*ConnectionContext, *frame.FrameHeader, []byte)                {}
+func (NopTracer) HeadersDecoded(*ConnectionContext, frame.Sid, []HeaderField)                {}
+func (NopTracer) SettingsChanged(*ConnectionContext, *settings.SettingsList)                 {}
+func (NopTracer) StreamStateChanged(*ConnectionContext, frame.Sid, StreamState, StreamState) {}
+func (NopTracer) Error(*ConnectionContext, frame.Sid, error)                                 {}
+
+// A SlogTracer logs to a slog.Logger: errors at LevelWarn, and
+// everything else at LevelDebug.
+type SlogTracer struct {
+	Logger *slog.Logger
+}
+
+func NewSlogTracer(logger *slog.Logger) *SlogTracer {
+	return &SlogTracer{Logger: logger}
+}
+
+func (t *SlogTracer) log(conn *ConnectionContext, level slog.Level, msg string, attrs ...slog.Attr) {
+	var ctx context.Context = context.Background()
+	if conn != nil {
+		ctx = conn
+	}
+	if !t.Logger.Enabled(ctx, level) {
+		return
+	}
+	if conn != nil && conn.RemoteAddr != "" {
+		attrs = append(attrs, slog.String("remote", conn.RemoteAddr))
+	}
+	t.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func frameAttrs(fh *frame.FrameHeader) []slog.Attr {
+	return []slog.Attr{
+		slog.String("type", fh.Type.String()),
+		slog.Uint64("sid", uint64(fh.Sid)),
+		slog.Uint64("len", uint64(fh.Length)),
+		slog.Uint64("flags", uint64(fh.Flags)),
+	}
+}
+
+func (t *SlogTracer) FrameRead(conn *ConnectionContext, f *frame.Frame) {
+	t.log(conn, slog.LevelDebug, "frame read", frameAttrs(f.FrameHeader)...)
+}
+
+func (t *SlogTracer) FrameWritten(conn *ConnectionContext, fh *frame.FrameHeader, data []byte) {
+	t.log(conn, slog.LevelDebug, "frame written", frameAttrs(fh)...)
+}
+
+func (t *SlogTracer) HeadersDecoded(conn *ConnectionContext, sid frame.Sid, fields []HeaderField) {
+	var hdrs []any
+	for _, f := range fields {
+		hdrs = append(hdrs, slog.String(f.Name, f.Value))
+	}
+	t.log(conn, slog.LevelDebug, "headers decoded",
+		slog.Uint64("sid", uint64(sid)), slog.Group("headers", hdrs...))
+}
+
+func (t *SlogTracer) SettingsChanged(conn *ConnectionContext, sl *settings.SettingsList) {
+	var attrs []any
+	for _, s := range sl.Settings {
+		attrs = append(attrs, slog.Uint64(s.Type.String(), uint64(s.Value)))
+	}
+	t.log(conn, slog.LevelDebug, "settings changed", slog.Group("settings", attrs...))
+}
+
+func (t *SlogTracer) StreamStateChanged(conn *ConnectionContext, sid frame.Sid, from, to StreamState) {
+	t.log(conn, slog.LevelDebug, "stream state changed",
+		slog.Uint64("sid", uint64(sid)), slog.String("from", from.String()), slog.String("to", to.String()))
+}
+
+func (t *SlogTracer) Error(conn *ConnectionContext, sid frame.Sid, err error) {
+	t.log(conn, slog.LevelWarn, "error", slog.Uint64("sid", uint64(sid)), slog.Any("err", err))
+}
+
+// A ConsoleTracer writes colourful, verbose dumps meant for watching a
+// connection by eye: every frame with a hex dump of its payload, and
+// every header block along with the dynamic table it left behind.
+type ConsoleTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewConsoleTracer(w io.Writer) *ConsoleTracer {
+	return &ConsoleTracer{w: w}
+}
+
+func (t *ConsoleTracer) FrameRead(conn *ConnectionContext, f *frame.Frame) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "\x1b[33mReceive Frame\x1b[0m %s\n", f.FrameHeader)
+	if len(f.Data) > 0 {
+		fmt.Fprintln(t.w, hex.Dump(f.Data[:min(len(f.Data), 1024)]))
+	}
+}
+
+func (t *ConsoleTracer) FrameWritten(conn *ConnectionContext, fh *frame.FrameHeader, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "\x1b[31mSend Frame\x1b[0m %s\n", fh)
+	if len(data) > 1 {
+		fmt.Fprint(t.w, hex.Dump(data[:min(len(data), 1024)]))
+	}
+}
+
+func (t *ConsoleTracer) HeadersDecoded(conn *ConnectionContext, sid frame.Sid, fields []HeaderField) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, f := range fields {
+		fmt.Fprintf(t.w, "%s = %s\n", f.Name, f.Value)
+	}
+	// Only the read loop decodes, and it's the one calling.
+	fmt.Fprintln(t.w, conn.incomingHeaderTable)
+}
+
+func (t *ConsoleTracer) SettingsChanged(conn *ConnectionContext, sl *settings.SettingsList) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintln(t.w, "---(CLIENT SETTINGS)---")
+	fmt.Fprint(t.w, sl)
+	fmt.Fprintln(t.w, "-----------------------")
+}
+
+func (t *ConsoleTracer) StreamStateChanged(conn *ConnectionContext, sid frame.Sid, from, to StreamState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "\x1b[32m(Stream %d)\x1b[0m %s -> %s\n", sid, from, to)
+}
+
+func (t *ConsoleTracer) Error(conn *ConnectionContext, sid frame.Sid, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "\x1b[31mERROR\x1b[0m (stream %d) %s\n", sid, err)
+}
@@ -0,0 +1,108 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"http2/frame"
+	"http2/session/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordTracer keeps a line for every event it's told about.
+type recordTracer struct {
+	NopTracer
+	mu     sync.Mutex
+	events []string
+}
+
+func (t *recordTracer) add(format string, args ...any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, fmt.Sprintf(format, args...))
+}
+
+func (t *recordTracer) FrameRead(conn *ConnectionContext, f *frame.Frame) {
+	t.add("read %s %d", f.FrameHeader.Type, f.FrameHeader.Sid)
+}
+
+func (t *recordTracer) FrameWritten(conn *ConnectionContext, fh *frame.FrameHeader, data []byte) {
+	t.add("wrote %s %d", fh.Type, fh.Sid)
+}
+
+func (t *recordTracer) HeadersDecoded(conn *ConnectionContext, sid frame.Sid, fields []HeaderField) {
+	for _, f := range fields {
+		if f.Name == "x-test" {
+			t.add("header %d %s", sid, f.Value)
+		}
+	}
+}
+
+func (t *recordTracer) SettingsChanged(conn *ConnectionContext, sl *settings.SettingsList) {
+	t.add("settings")
+}
+
+func (t *recordTracer) StreamStateChanged(conn *ConnectionContext, sid frame.Sid, from, to StreamState) {
+	t.add("stream %d %s", sid, to)
+}
+
+func (t *recordTracer) snapshot() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.events...)
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordTracer{}
+	srv := &Server{
+		Handler: FuncHandler(func(req *Request, res *Response) {
+			io.WriteString(res, "traced")
+		}),
+		Tracer: tracer,
+	}
+	addr, client, _ := startServer(t, srv)
+
+	req, err := http.NewRequest("GET", "https://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Test", "yes")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	events := tracer.snapshot()
+	assert.Contains(t, events, "settings")
+	assert.Contains(t, events, "read FrameHeaders 1")
+	assert.Contains(t, events, "header 1 yes")
+	assert.Contains(t, events, "stream 1 StreamStateOpen")
+	assert.Contains(t, events, "stream 1 StreamStateRemoteClosed")
+	assert.Contains(t, events, "wrote FrameData 1")
+}
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	ctx := NewConnectionContext(nil, io.Discard, nil)
+	ctx.RemoteAddr = "192.0.2.1:1234"
+
+	// Only errors get through at the default level.
+	tracer := NewSlogTracer(slog.New(slog.NewTextHandler(&buf, nil)))
+	tracer.FrameWritten(ctx, &frame.FrameHeader{Type: frame.FramePing}, nil)
+	tracer.Error(ctx, 3, errors.New("boom"))
+	assert.Contains(t, buf.String(), `level=WARN msg=error sid=3 err=boom remote=192.0.2.1:1234`)
+	assert.NotContains(t, buf.String(), "frame written")
+
+	buf.Reset()
+	tracer = NewSlogTracer(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	tracer.FrameWritten(ctx, &frame.FrameHeader{Type: frame.FramePing, Length: 8}, nil)
+	tracer.HeadersDecoded(ctx, 1, []HeaderField{{":method", "GET"}})
+	assert.Contains(t, buf.String(), `msg="frame written" type=FramePing sid=0 len=8 flags=0`)
+	assert.Contains(t, buf.String(), `msg="headers decoded" sid=1 headers.:method=GET`)
+}
@@ -0,0 +1,185 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MalformedRequest is wrapped by the errors validateRequestHeaders
+// returns. Malformed requests are reset with PROTOCOL_ERROR
+// (RFC 9113 §8.1.1).
+var MalformedRequest = errors.New("malformed request")
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", MalformedRequest, fmt.Sprintf(format, args...))
+}
+
+// Fields that only make sense for a single HTTP/1.1 connection
+// (RFC 9113 §8.2.2).
+var connectionSpecificHeaders = map[string]bool{
+	"connection":        true,
+	"proxy-connection":  true,
+	"keep-alive":        true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+var requestPseudoHeaders = map[string]bool{
+	":method":    true,
+	":scheme":    true,
+	":authority": true,
+	":path":      true,
+}
+
+// validateFieldName rejects empty names and names with characters
+// outside of 0x21-0x7e or uppercase letters (RFC 9113 §8.2.1).
+// Colons are only allowed as the pseudo-header prefix.
+func validateFieldName(k string) error {
+	if k == "" || k == ":" {
+		return malformed("empty field name")
+	}
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+		switch {
+		case c <= 0x20 || c >= 0x7f:
+			return malformed("invalid character %#x in field name %q", c, k)
+		case c >= 'A' && c <= 'Z':
+			return malformed("uppercase field name %q", k)
+		case c == ':' && i > 0:
+			return malformed("colon in field name %q", k)
+		}
+	}
+	return nil
+}
+
+// validateFieldValue rejects NUL, CR and LF, and leading or
+// trailing whitespace (RFC 9113 §8.2.1).
+func validateFieldValue(k, v string) error {
+	if strings.ContainsAny(v, "\x00\r\n") {
+		return malformed("invalid character in value of %q", k)
+	}
+	if v != "" && (v[0] == ' ' || v[0] == '\t' || v[len(v)-1] == ' ' || v[len(v)-1] == '\t') {
+		return malformed("whitespace around value of %q", k)
+	}
+	return nil
+}
+
+// validateRegularField checks the rules shared by headers and trailers.
+func validateRegularField(k, v string) error {
+	if err := validateFieldName(k); err != nil {
+		return err
+	}
+	if err := validateFieldValue(k, v); err != nil {
+		return err
+	}
+	if connectionSpecificHeaders[k] {
+		return malformed("connection-specific field %q", k)
+	}
+	if k == "te" && v != "trailers" {
+		return malformed("te must be \"trailers\", got %q", v)
+	}
+	return nil
+}
+
+// validateRequestHeaders checks a decoded request header block against
+// RFC 9113 §8.2 and §8.3.1.
+func validateRequestHeaders(fields []stringpair) error {
+	pseudo := make(map[string]string)
+	var contentLength []string
+	regular := false
+
+	for _, f := range fields {
+		if strings.HasPrefix(f.k, ":") {
+			if err := validateFieldName(f.k); err != nil {
+				return err
+			}
+			if err := validateFieldValue(f.k, f.v); err != nil {
+				return err
+			}
+			if regular {
+				return malformed("pseudo-header %q after regular fields", f.k)
+			}
+			if !requestPseudoHeaders[f.k] {
+				return malformed("unknown request pseudo-header %q", f.k)
+			}
+			if _, ok := pseudo[f.k]; ok {
+				return malformed("duplicate pseudo-header %q", f.k)
+			}
+			pseudo[f.k] = f.v
+			continue
+		}
+		regular = true
+		if err := validateRegularField(f.k, f.v); err != nil {
+			return err
+		}
+		if f.k == "content-length" {
+			contentLength = append(contentLength, f.v)
+		}
+	}
+
+	method, ok := pseudo[":method"]
+	if !ok || method == "" {
+		return malformed("missing :method")
+	}
+	if method == "CONNECT" {
+		if _, ok := pseudo[":scheme"]; ok {
+			return malformed("CONNECT with :scheme")
+		}
+		if _, ok := pseudo[":path"]; ok {
+			return malformed("CONNECT with :path")
+		}
+		if pseudo[":authority"] == "" {
+			return malformed("CONNECT without :authority")
+		}
+	} else {
+		if pseudo[":scheme"] == "" {
+			return malformed("missing :scheme")
+		}
+		path := pseudo[":path"]
+		if path == "" {
+			return malformed("missing :path")
+		}
+		if path[0] != '/' && !(path == "*" && method == "OPTIONS") {
+			return malformed("invalid :path %q", path)
+		}
+	}
+
+	if _, err := parseContentLength(contentLength); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateTrailers checks a trailer block, which can't carry
+// pseudo-headers (RFC 9113 §8.1).
+func validateTrailers(fields []stringpair) error {
+	for _, f := range fields {
+		if strings.HasPrefix(f.k, ":") {
+			return malformed("pseudo-header %q in trailers", f.k)
+		}
+		if err := validateRegularField(f.k, f.v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseContentLength returns the value of the content-length fields,
+// or -1 if there are none. Repeated fields must agree.
+func parseContentLength(values []string) (int64, error) {
+	if len(values) == 0 {
+		return -1, nil
+	}
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return 0, malformed("conflicting content-length values")
+		}
+	}
+	n, err := strconv.ParseUint(values[0], 10, 63)
+	if err != nil {
+		return 0, malformed("invalid content-length %q", values[0])
+	}
+	return int64(n), nil
+}
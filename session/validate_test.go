@@ -0,0 +1,102 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func pairs(kv ...string) []stringpair {
+	var ret []stringpair
+	for i := 0; i < len(kv); i += 2 {
+		ret = append(ret, stringpair{kv[i], kv[i+1]})
+	}
+	return ret
+}
+
+var validGet = []string{":method", "GET", ":scheme", "https", ":path", "/", ":authority", "example.com"}
+
+func TestValidateRequestHeaders(t *testing.T) {
+	cases := []struct {
+		Name string
+		KV   []string
+	}{
+		{"Get", validGet},
+		{"Te", append(validGet, "te", "trailers")},
+		{"Options", []string{":method", "OPTIONS", ":scheme", "https", ":path", "*"}},
+		{"Connect", []string{":method", "CONNECT", ":authority", "example.com:443"}},
+		{"ContentLength", append(validGet, "content-length", "5", "content-length", "5")},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			assert.NoError(t, validateRequestHeaders(pairs(c.KV...)))
+		})
+	}
+}
+
+func TestValidateRequestHeaders_Malformed(t *testing.T) {
+	cases := []struct {
+		Name string
+		KV   []string
+	}{
+		{"UppercaseName", append(validGet, "Accept", "*/*")},
+		{"SpaceInName", append(validGet, "x y", "z")},
+		{"ColonInName", append(validGet, "x:y", "z")},
+		{"MissingMethod", validGet[2:]},
+		{"MissingScheme", []string{":method", "GET", ":path", "/"}},
+		{"MissingPath", []string{":method", "GET", ":scheme", "https"}},
+		{"EmptyPath", []string{":method", "GET", ":scheme", "https", ":path", ""}},
+		{"RelativePath", []string{":method", "GET", ":scheme", "https", ":path", "index.html"}},
+		{"AsteriskGet", []string{":method", "GET", ":scheme", "https", ":path", "*"}},
+		{"DuplicatePseudo", append(validGet, ":path", "/other")},
+		{"PseudoAfterRegular", []string{":method", "GET", "accept", "*/*", ":scheme", "https", ":path", "/"}},
+		{"UnknownPseudo", append([]string{":status", "200"}, validGet...)},
+		{"Connection", append(validGet, "connection", "keep-alive")},
+		{"TransferEncoding", append(validGet, "transfer-encoding", "chunked")},
+		{"Upgrade", append(validGet, "upgrade", "h2c")},
+		{"TeGzip", append(validGet, "te", "gzip")},
+		{"NulInValue", append(validGet, "x", "a\x00b")},
+		{"NewlineInValue", append(validGet, "x", "a\r\nb: c")},
+		{"LeadingSpace", append(validGet, "x", " a")},
+		{"TrailingTab", append(validGet, "x", "a\t")},
+		{"ConnectWithPath", []string{":method", "CONNECT", ":authority", "example.com:443", ":path", "/"}},
+		{"ConnectNoAuthority", []string{":method", "CONNECT"}},
+		{"BadContentLength", append(validGet, "content-length", "-1")},
+		{"ConflictingContentLength", append(validGet, "content-length", "1", "content-length", "2")},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			assert.ErrorIs(t, validateRequestHeaders(pairs(c.KV...)), MalformedRequest)
+		})
+	}
+}
+
+func TestCheckContentLength(t *testing.T) {
+	st := NewStream(1, nil)
+	st.Request = &Request{contentLength: 10}
+
+	assert.NoError(t, st.checkContentLength(4, false))
+	assert.NoError(t, st.checkContentLength(6, true))
+
+	st = NewStream(1, nil)
+	st.Request = &Request{contentLength: 10}
+	assert.ErrorIs(t, st.checkContentLength(11, false), MalformedRequest)
+
+	st = NewStream(1, nil)
+	st.Request = &Request{contentLength: 10}
+	assert.ErrorIs(t, st.checkContentLength(9, true), MalformedRequest)
+
+	st = NewStream(1, nil)
+	st.Request = &Request{contentLength: -1}
+	assert.NoError(t, st.checkContentLength(100, true))
+}
+
+func TestValidateTrailers(t *testing.T) {
+	assert.NoError(t, validateTrailers(pairs("grpc-status", "0", "x-checksum", "abc")))
+	assert.NoError(t, validateTrailers(nil))
+
+	assert.ErrorIs(t, validateTrailers(pairs(":status", "200")), MalformedRequest)
+	assert.ErrorIs(t, validateTrailers(pairs("X-Checksum", "abc")), MalformedRequest)
+	assert.ErrorIs(t, validateTrailers(pairs("transfer-encoding", "chunked")), MalformedRequest)
+	assert.ErrorIs(t, validateTrailers(pairs("x", "a\nb")), MalformedRequest)
+}
@@ -0,0 +1,149 @@
+// Package sse streams Server-Sent Events over a session.Response.
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"http2/session"
+)
+
+var InvalidField = errors.New("event field contains a newline")
+
+// An Event is one message on the stream. Only Data is required.
+type Event struct {
+	// The event type, dispatched to addEventListener(Event)
+	// on the client. Empty means "message".
+	Event string
+	// Sets the client's last event ID, which it sends back in
+	// Last-Event-ID when it reconnects.
+	ID   string
+	Data string
+	// How long the client should wait before reconnecting.
+	Retry time.Duration
+}
+
+// An EventWriter writes events to a response. It's safe to use from
+// several goroutines. Writes fail once the client resets the stream
+// or the connection goes away.
+type EventWriter struct {
+	mu  sync.Mutex
+	res *session.Response
+	ctx context.Context
+
+	lastEventID string
+}
+
+// NewEventWriter sends the event stream headers and returns a writer
+// for the events.
+func NewEventWriter(req *session.Request, res *session.Response) (*EventWriter, error) {
+	res.SetHeader("content-type", "text/event-stream")
+	res.SetHeader("cache-control", "no-cache")
+	if err := res.WriteHeader(session.Ok); err != nil {
+		return nil, err
+	}
+	if err := res.Flush(); err != nil {
+		return nil, err
+	}
+	return &EventWriter{
+		res:         res,
+		ctx:         req.Context(),
+		lastEventID: req.Header().Get("last-event-id"),
+	}, nil
+}
+
+// LastEventID is the ID of the last event a reconnecting client saw,
+// so the stream can resume after it. It's "" for new clients.
+func (w *EventWriter) LastEventID() string {
+	return w.lastEventID
+}
+
+// Done is closed when the stream can't be written to any more.
+func (w *EventWriter) Done() <-chan struct{} {
+	return w.ctx.Done()
+}
+
+// Send writes an event and flushes it to the client.
+func (w *EventWriter) Send(ev Event) error {
+	if strings.ContainsAny(ev.Event, "\r\n") || strings.ContainsAny(ev.ID, "\r\n\x00") {
+		return InvalidField
+	}
+	var b strings.Builder
+	if ev.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", ev.Event)
+	}
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", ev.ID)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %s\n", strconv.FormatInt(ev.Retry.Milliseconds(), 10))
+	}
+	data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return w.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore. Comments keep
+// idle connections from being closed by proxies.
+func (w *EventWriter) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(&b, ": %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	b.WriteString("\n")
+	return w.write(b.String())
+}
+
+func (w *EventWriter) write(s string) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.res.Write([]byte(s)); err != nil {
+		return err
+	}
+	return w.res.Flush()
+}
+
+// Run sends events from the channel until it's closed or the stream
+// ends, with a comment heartbeat after every idle interval (none if
+// heartbeat is 0). It returns nil when the channel is closed, and the
+// context's error when the client goes away.
+func (w *EventWriter) Run(events <-chan Event, heartbeat time.Duration) error {
+	var tick <-chan time.Time
+	idle := func() {}
+	if heartbeat > 0 {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		tick = t.C
+		idle = func() { t.Reset(heartbeat) }
+	}
+	for {
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := w.Send(ev); err != nil {
+				return err
+			}
+			idle()
+		case <-tick:
+			if err := w.Comment("heartbeat"); err != nil {
+				return err
+			}
+		}
+	}
+}
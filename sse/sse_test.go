@@ -0,0 +1,109 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"http2/session"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// start serves h and returns a reader over the event stream.
+func start(t *testing.T, ctx context.Context, h session.FuncHandler, header ...string) *bufio.Reader {
+	srv := httptest.NewServer(session.ToHTTPHandler(h))
+	t.Cleanup(srv.Close)
+
+	r, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	require.NoError(t, err)
+	for i := 0; i < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	resp, err := srv.Client().Do(r)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	return bufio.NewReader(resp.Body)
+}
+
+// readEvent reads up to the blank line that ends an event.
+func readEvent(t *testing.T, rd *bufio.Reader) string {
+	var b strings.Builder
+	for {
+		line, err := rd.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return b.String()
+		}
+		b.WriteString(line)
+	}
+}
+
+func TestSend(t *testing.T) {
+	rd := start(t, context.Background(), func(req *session.Request, res *session.Response) {
+		w, err := NewEventWriter(req, res)
+		require.NoError(t, err)
+		assert.Equal(t, "41", w.LastEventID())
+		w.Send(Event{Data: "hello"})
+		w.Send(Event{Event: "update", ID: "42", Data: "line one\nline two\r\nline three", Retry: 3 * time.Second})
+		w.Comment("ping")
+		assert.ErrorIs(t, w.Send(Event{ID: "4\n2"}), InvalidField)
+	}, "Last-Event-ID", "41")
+
+	assert.Equal(t, "data: hello\n", readEvent(t, rd))
+	assert.Equal(t, "event: update\nid: 42\nretry: 3000\ndata: line one\ndata: line two\ndata: line three\n", readEvent(t, rd))
+	assert.Equal(t, ": ping\n", readEvent(t, rd))
+}
+
+func TestRun(t *testing.T) {
+	events := make(chan Event)
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rd := start(t, ctx, func(req *session.Request, res *session.Response) {
+		w, err := NewEventWriter(req, res)
+		require.NoError(t, err)
+		done <- w.Run(events, 20*time.Millisecond)
+	})
+
+	events <- Event{Data: "first"}
+	ev := readEvent(t, rd)
+	for ev == ": heartbeat\n" {
+		ev = readEvent(t, rd)
+	}
+	assert.Equal(t, "data: first\n", ev)
+	// Nothing to send, so a heartbeat follows.
+	assert.Equal(t, ": heartbeat\n", readEvent(t, rd))
+
+	// The client going away ends the stream.
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the client went away")
+	}
+}
+
+func TestRunChannelClosed(t *testing.T) {
+	events := make(chan Event, 1)
+	events <- Event{Data: "only"}
+	close(events)
+
+	rd := start(t, context.Background(), func(req *session.Request, res *session.Response) {
+		w, err := NewEventWriter(req, res)
+		require.NoError(t, err)
+		assert.NoError(t, w.Run(events, 0))
+	})
+	assert.Equal(t, "data: only\n", readEvent(t, rd))
+	_, err := rd.ReadString('\n')
+	assert.Error(t, err)
+}